@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/doduda/pkg/doduda"
+	"github.com/spf13/viper"
+)
+
+// PipelineConfig is the doduda.yaml that `doduda run` executes. Keys that are
+// missing use the defaults of the flags with the same name.
+type PipelineConfig struct {
+	Releases    []string               `mapstructure:"releases"`
+	Platforms   []string               `mapstructure:"platforms"`
+	Version     string                 `mapstructure:"version"`
+	Output      string                 `mapstructure:"output"` // with several targets each gets <output>/<release>/<platform>
+	Indent      bool                   `mapstructure:"indent"`
+	Headless    bool                   `mapstructure:"headless"`
+	Full        bool                   `mapstructure:"full"`
+	Fragments   []string               `mapstructure:"fragments"` // fragments of a full download, empty downloads all
+	Incremental bool                   `mapstructure:"incremental"`
+	Bin         int                    `mapstructure:"bin"`
+	Ignore      []string               `mapstructure:"ignore"`
+	Maps        bool                   `mapstructure:"maps"`
+	Languages   []string               `mapstructure:"languages"`  // empty downloads all languages of the game version
+	RulesFile   string                 `mapstructure:"rules_file"` // replaces the built-in selection rules
+	Rules       []doduda.SelectionRule `mapstructure:"rules"`      // take precedence over the rules file
+	Images      *ImageLayout           `mapstructure:"images"`
+	Map         MapConfig              `mapstructure:"map"`
+	Render      RenderConfig           `mapstructure:"render"`
+	Download    DownloadConfig         `mapstructure:"download"`
+}
+
+// MapConfig controls the mapping after the download.
+type MapConfig struct {
+	Enabled        bool     `mapstructure:"enabled"`
+	PersistenceDir string   `mapstructure:"persistence_dir"`
+	Targets        []string `mapstructure:"targets"` // items, mounts, almanax, sets, recipes. Empty maps all
+}
+
+// RenderConfig controls rendering the item vectors to PNG after the mapping.
+// Dofus 2 only, targets without vectors are skipped.
+type RenderConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	Input       string `mapstructure:"input"`  // relative to the target directory
+	Output      string `mapstructure:"output"` // relative to the target directory
+	Resolution  int    `mapstructure:"resolution"`
+	Runtime     string `mapstructure:"runtime"`     // docker, podman, nerdctl or local
+	Incremental string `mapstructure:"incremental"` // <owner>/<repo>/<filename> like render --incremental
+}
+
+// DownloadConfig holds the settings of the CDN client.
+type DownloadConfig struct {
+	Concurrency    int           `mapstructure:"concurrency"`
+	Retries        int           `mapstructure:"retries"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+	BandwidthLimit string        `mapstructure:"bandwidth_limit"`
+	BundleCache    string        `mapstructure:"bundle_cache"` // empty disables it, "default" is the user cache directory
+}
+
+// LoadPipelineConfig reads a doduda.yaml. Unknown keys are an error so typos
+// do not silently fall back to defaults. Lists replace the defaults of the
+// image layout, rename entries are added to them.
+func LoadPipelineConfig(file string) (*PipelineConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetDefault("releases", []string{"dofus3"})
+	v.SetDefault("platforms", []string{"windows"})
+	v.SetDefault("version", "latest")
+	v.SetDefault("output", "./data")
+	v.SetDefault("bin", 500)
+	v.SetDefault("render.input", "vector/item")
+	v.SetDefault("render.output", "vector/item-png")
+	v.SetDefault("render.resolution", 200)
+	v.SetDefault("render.runtime", "docker")
+	v.SetDefault("download.concurrency", 8)
+	v.SetDefault("download.retries", 4)
+	v.SetDefault("download.timeout", 5*time.Minute)
+
+	if err := v.ReadInConfig(); err != nil {
+		if os.IsNotExist(err) {
+			return nil, newError(KindUsage, "config", err)
+		}
+		return nil, newError(KindUsage, "config "+filepath.Base(file), err)
+	}
+
+	// mapstructure decodes lists into the elements of an existing slice, so
+	// the defaults are only filled in afterwards
+	config := &PipelineConfig{Images: &ImageLayout{}}
+	if err := v.UnmarshalExact(config); err != nil {
+		return nil, newError(KindUsage, "config "+filepath.Base(file), err)
+	}
+	config.Images = withDefaultImageLayout(config.Images)
+
+	if len(config.Releases) == 0 {
+		return nil, newError(KindUsage, "config", fmt.Errorf("releases must not be empty"))
+	}
+
+	platforms, err := parsePlatforms(config.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	config.Platforms = platforms
+
+	for _, target := range config.Map.Targets {
+		if !contains(doduda.MapTargets, target) {
+			return nil, newError(KindUsage, "config map", fmt.Errorf("unknown target %q, valid targets are %s", target, strings.Join(doduda.MapTargets, ", ")))
+		}
+	}
+
+	if config.Render.Incremental != "" && len(strings.Split(config.Render.Incremental, "/")) != 3 {
+		return nil, newError(KindUsage, "config render", fmt.Errorf("incremental must be <owner>/<repo>/<filename>"))
+	}
+
+	return config, nil
+}
+
+// withDefaultImageLayout fills the lists the layout does not set with the
+// defaults and adds its rename entries to the default ones.
+func withDefaultImageLayout(layout *ImageLayout) *ImageLayout {
+	defaults := DefaultImageLayout()
+	if layout.Remove != nil {
+		defaults.Remove = layout.Remove
+	}
+	if layout.Cleaning != nil {
+		defaults.Cleaning = layout.Cleaning
+	}
+	if layout.Flatten != nil {
+		defaults.Flatten = layout.Flatten
+	}
+	for destination, source := range layout.Rename {
+		defaults.Rename[destination] = source
+	}
+	return defaults
+}
+
+// selectionRules returns the inline rules followed by the rules of the rules
+// file or the built-in rules.
+func (c *PipelineConfig) selectionRules(configDir string) (*doduda.SelectionRules, error) {
+	base := doduda.DefaultSelectionRules()
+	if c.RulesFile != "" {
+		rulesFile := c.RulesFile
+		if !filepath.IsAbs(rulesFile) {
+			rulesFile = filepath.Join(configDir, rulesFile)
+		}
+
+		var err error
+		base, err = doduda.LoadSelectionRules(rulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rules := &doduda.SelectionRules{Rules: append(append([]doduda.SelectionRule(nil), c.Rules...), base.Rules...)}
+	if err := rules.Compile(); err != nil {
+		return nil, newError(KindUsage, "config rules", err)
+	}
+	return rules, nil
+}
+
+// PipelineError is an error of RunPipeline with the stage that failed:
+// setup, download, unpack, map or render.
+type PipelineError struct {
+	Stage string
+	Err   error
+}
+
+func (e *PipelineError) Error() string {
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// PipelineSetup returns the selection rules and the download client of the
+// config. Relative paths in the config are relative to configDir. Errors are
+// *PipelineError.
+func (c *PipelineConfig) PipelineSetup(configDir string) (*doduda.SelectionRules, *doduda.Client, error) {
+	rules, err := c.selectionRules(configDir)
+	if err != nil {
+		return nil, nil, &PipelineError{Stage: "setup", Err: err}
+	}
+
+	client, err := newCDNClient(c.Download.Concurrency, c.Download.Retries, c.Download.Timeout, c.Download.BandwidthLimit, c.Download.BundleCache)
+	if err != nil {
+		return nil, nil, &PipelineError{Stage: "setup", Err: err}
+	}
+	return rules, client, nil
+}
+
+// RunPipeline downloads, maps and renders every target of the config with the
+// rules and client of PipelineSetup. Relative paths in the config are
+// relative to configDir. Errors are *PipelineError.
+func RunPipeline(ctx context.Context, config *PipelineConfig, configDir string, rules *doduda.SelectionRules, client *doduda.Client, headless bool) error {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(configDir, path)
+	}
+
+	var indentation string
+	if config.Indent {
+		indentation = "  "
+	}
+
+	var incrementalParts []string
+	if config.Render.Incremental != "" {
+		incrementalParts = strings.Split(config.Render.Incremental, "/")
+	}
+
+	headless = headless || config.Headless
+	output := resolve(config.Output)
+	params := DownloadParams{
+		Version:      config.Version,
+		CacheIgnore:  true,
+		ManifestPath: filepath.Join(output, "manifest.json"),
+		Full:         config.Full,
+		Fragments:    config.Fragments,
+		Incremental:  config.Incremental,
+		Bin:          config.Bin,
+		Ignore:       config.Ignore,
+		Maps:         config.Maps,
+		Languages:    config.Languages,
+		Images:       config.Images,
+		Indent:       indentation,
+		Headless:     headless,
+		Client:       client,
+		Rules:        rules,
+	}
+
+	err := DownloadMatrix(ctx, config.Releases, config.Platforms, output, params, func(target downloadTarget) error {
+		if config.Full {
+			return nil
+		}
+
+		if config.Map.Enabled {
+			if err := Map(ctx, client, target.dir, indentation, resolve(config.Map.PersistenceDir), target.release, config.Map.Targets, headless); err != nil {
+				return &PipelineError{Stage: "map", Err: err}
+			}
+		}
+
+		if config.Render.Enabled {
+			inputDir := filepath.Join(target.dir, config.Render.Input)
+			if _, err := os.Stat(inputDir); os.IsNotExist(err) {
+				log.Warn("Nothing to render", "release", target.release, "platform", target.platform, "dir", inputDir)
+				return nil
+			}
+
+			outputDir := filepath.Join(target.dir, config.Render.Output)
+			if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+				return &PipelineError{Stage: "render", Err: newError(KindIO, "render", err)}
+			}
+
+			if err := Render(ctx, client, inputDir, outputDir, incrementalParts, config.Render.Resolution, config.Render.Runtime, headless); err != nil {
+				return &PipelineError{Stage: "render", Err: err}
+			}
+		}
+		return nil
+	})
+
+	var pipelineErr *PipelineError
+	if err == nil || errors.As(err, &pipelineErr) {
+		return err
+	}
+	if KindOf(err) == KindUnpack {
+		return &PipelineError{Stage: "unpack", Err: err}
+	}
+	return &PipelineError{Stage: "download", Err: err}
+}
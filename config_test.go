@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadConfig(t *testing.T, yaml string) *PipelineConfig {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "doduda.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadPipelineConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestLoadPipelineConfigImages(t *testing.T) {
+	config := loadConfig(t, `
+images:
+  remove: [foo]
+  rename:
+    ui/spells: spells/big
+    ui/extra: extra/2x
+  cleaning:
+    - { path: items, dim: 64 }
+    - { path: a }
+    - { path: b }
+    - { path: c }
+    - { path: d }
+  flatten: []
+`)
+
+	if want := []string{"foo"}; !reflect.DeepEqual(config.Images.Remove, want) {
+		t.Errorf("Remove = %v, want %v", config.Images.Remove, want)
+	}
+
+	// the fifth entry must not get the exclude of the fifth default one
+	want := []ImageCleaning{{"items", 64, ""}, {"a", 0, ""}, {"b", 0, ""}, {"c", 0, ""}, {"d", 0, ""}}
+	if !reflect.DeepEqual(config.Images.Cleaning, want) {
+		t.Errorf("Cleaning = %v, want %v", config.Images.Cleaning, want)
+	}
+
+	if len(config.Images.Flatten) != 0 {
+		t.Errorf("Flatten = %v, want none", config.Images.Flatten)
+	}
+
+	defaults := DefaultImageLayout()
+	if config.Images.Rename["ui/spells"] != "spells/big" || config.Images.Rename["ui/extra"] != "extra/2x" || config.Images.Rename["items"] != defaults.Rename["items"] {
+		t.Errorf("Rename = %v, want the defaults with ui/spells replaced and ui/extra added", config.Images.Rename)
+	}
+}
+
+func TestLoadPipelineConfigImageDefaults(t *testing.T) {
+	config := loadConfig(t, "releases: [main]\n")
+	if !reflect.DeepEqual(config.Images, DefaultImageLayout()) {
+		t.Errorf("Images = %+v, want the default layout", config.Images)
+	}
+
+	config = loadConfig(t, "images:\n  remove: [foo]\n")
+	if !reflect.DeepEqual(config.Images.Cleaning, DefaultImageLayout().Cleaning) {
+		t.Errorf("Cleaning = %v, want the defaults", config.Images.Cleaning)
+	}
+}
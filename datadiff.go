@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type mappedDataTarget struct {
+	Kind    string
+	File    string
+	IdField string
+}
+
+// Almanax is left out because its entries have no stable ankama ID.
+var mappedDataTargets = []mappedDataTarget{
+	{Kind: "items", File: "MAPPED_ITEMS.json", IdField: "ankama_id"},
+	{Kind: "sets", File: "MAPPED_SETS.json", IdField: "ankama_id"},
+	{Kind: "mounts", File: "MAPPED_MOUNTS.json", IdField: "ankama_id"},
+	{Kind: "recipes", File: "MAPPED_RECIPES.json", IdField: "result_id"},
+}
+
+type EntitySummary struct {
+	Id   int               `json:"id"`
+	Name map[string]string `json:"name,omitempty"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type TranslationChange struct {
+	Field    string `json:"field"`
+	Language string `json:"language"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+}
+
+type EntityChange struct {
+	EntitySummary
+	Fields       []FieldChange       `json:"fields"`
+	Translations []TranslationChange `json:"translations"`
+}
+
+type EntityDiff struct {
+	Kind    string          `json:"kind"`
+	Added   []EntitySummary `json:"added"`
+	Removed []EntitySummary `json:"removed"`
+	Changed []EntityChange  `json:"changed"`
+}
+
+type DataChangelog struct {
+	OldDir   string       `json:"old_dir"`
+	NewDir   string       `json:"new_dir"`
+	Entities []EntityDiff `json:"entities"`
+}
+
+func loadMappedEntities(path string, idField string) (map[int]map[string]interface{}, error) {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[int]map[string]interface{}{}, nil // only mapped on one side
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []map[string]interface{}
+	err = json.Unmarshal(raw, &entities)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	byId := make(map[int]map[string]interface{}, len(entities))
+	for _, entity := range entities {
+		id, ok := entity[idField].(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s: entity without %s", path, idField)
+		}
+		byId[int(id)] = entity
+	}
+
+	return byId, nil
+}
+
+func entitySummary(id int, entity map[string]interface{}) EntitySummary {
+	summary := EntitySummary{Id: id}
+	if name, ok := asTranslations(entity["name"]); ok {
+		summary.Name = name
+	}
+	return summary
+}
+
+// asTranslations returns the value as language -> text map if it is one.
+func asTranslations(value interface{}) (map[string]string, bool) {
+	raw, ok := value.(map[string]interface{})
+	if !ok || len(raw) == 0 {
+		return nil, false
+	}
+
+	translations := make(map[string]string, len(raw))
+	for lang, text := range raw {
+		str, ok := text.(string)
+		if !ok {
+			return nil, false
+		}
+		translations[lang] = str
+	}
+	return translations, true
+}
+
+func diffEntity(id int, oldEntity map[string]interface{}, newEntity map[string]interface{}) (EntityChange, bool) {
+	change := EntityChange{
+		EntitySummary: entitySummary(id, newEntity),
+		Fields:        []FieldChange{},
+		Translations:  []TranslationChange{},
+	}
+
+	fields := map[string]bool{}
+	for field := range oldEntity {
+		fields[field] = true
+	}
+	for field := range newEntity {
+		fields[field] = true
+	}
+
+	for field := range fields {
+		oldValue, newValue := oldEntity[field], newEntity[field]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		oldTranslations, oldOk := asTranslations(oldValue)
+		newTranslations, newOk := asTranslations(newValue)
+		if oldOk && newOk {
+			langs := map[string]bool{}
+			for lang := range oldTranslations {
+				langs[lang] = true
+			}
+			for lang := range newTranslations {
+				langs[lang] = true
+			}
+			for lang := range langs {
+				if oldTranslations[lang] != newTranslations[lang] {
+					change.Translations = append(change.Translations, TranslationChange{Field: field, Language: lang, Old: oldTranslations[lang], New: newTranslations[lang]})
+				}
+			}
+			continue
+		}
+
+		change.Fields = append(change.Fields, FieldChange{Field: field, Old: oldValue, New: newValue})
+	}
+
+	sort.Slice(change.Fields, func(i, j int) bool {
+		return change.Fields[i].Field < change.Fields[j].Field
+	})
+	sort.Slice(change.Translations, func(i, j int) bool {
+		if change.Translations[i].Field != change.Translations[j].Field {
+			return change.Translations[i].Field < change.Translations[j].Field
+		}
+		return change.Translations[i].Language < change.Translations[j].Language
+	})
+
+	return change, len(change.Fields)+len(change.Translations) > 0
+}
+
+func DiffMappedData(oldDir string, newDir string) (*DataChangelog, error) {
+	changelog := &DataChangelog{
+		OldDir:   oldDir,
+		NewDir:   newDir,
+		Entities: []EntityDiff{},
+	}
+
+	for _, target := range mappedDataTargets {
+		oldPath := filepath.Join(oldDir, target.File)
+		newPath := filepath.Join(newDir, target.File)
+
+		_, oldErr := os.Stat(oldPath)
+		_, newErr := os.Stat(newPath)
+		if os.IsNotExist(oldErr) && os.IsNotExist(newErr) {
+			continue
+		}
+
+		oldEntities, err := loadMappedEntities(oldPath, target.IdField)
+		if err != nil {
+			return nil, err
+		}
+
+		newEntities, err := loadMappedEntities(newPath, target.IdField)
+		if err != nil {
+			return nil, err
+		}
+
+		entityDiff := EntityDiff{
+			Kind:    target.Kind,
+			Added:   []EntitySummary{},
+			Removed: []EntitySummary{},
+			Changed: []EntityChange{},
+		}
+
+		for id, newEntity := range newEntities {
+			oldEntity, ok := oldEntities[id]
+			if !ok {
+				entityDiff.Added = append(entityDiff.Added, entitySummary(id, newEntity))
+				continue
+			}
+
+			if change, changed := diffEntity(id, oldEntity, newEntity); changed {
+				entityDiff.Changed = append(entityDiff.Changed, change)
+			}
+		}
+
+		for id, oldEntity := range oldEntities {
+			if _, ok := newEntities[id]; !ok {
+				entityDiff.Removed = append(entityDiff.Removed, entitySummary(id, oldEntity))
+			}
+		}
+
+		sort.Slice(entityDiff.Added, func(i, j int) bool { return entityDiff.Added[i].Id < entityDiff.Added[j].Id })
+		sort.Slice(entityDiff.Removed, func(i, j int) bool { return entityDiff.Removed[i].Id < entityDiff.Removed[j].Id })
+		sort.Slice(entityDiff.Changed, func(i, j int) bool { return entityDiff.Changed[i].Id < entityDiff.Changed[j].Id })
+
+		changelog.Entities = append(changelog.Entities, entityDiff)
+	}
+
+	return changelog, nil
+}
+
+func (summary EntitySummary) displayName(lang string) string {
+	if name, ok := summary.Name[lang]; ok && name != "" {
+		return name
+	}
+	return fmt.Sprintf("#%d", summary.Id)
+}
+
+// WriteMarkdown renders the changelog as patch notes using the names in lang.
+func (changelog *DataChangelog) WriteMarkdown(w io.Writer, lang string) {
+	fmt.Fprintln(w, "# Patch notes")
+
+	for _, entityDiff := range changelog.Entities {
+		if len(entityDiff.Added)+len(entityDiff.Removed)+len(entityDiff.Changed) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "\n## %s\n", strings.ToUpper(entityDiff.Kind[:1])+entityDiff.Kind[1:])
+
+		if len(entityDiff.Added) > 0 {
+			fmt.Fprintf(w, "\n### New (%d)\n\n", len(entityDiff.Added))
+			for _, entity := range entityDiff.Added {
+				fmt.Fprintf(w, "- %s (%d)\n", entity.displayName(lang), entity.Id)
+			}
+		}
+
+		if len(entityDiff.Removed) > 0 {
+			fmt.Fprintf(w, "\n### Removed (%d)\n\n", len(entityDiff.Removed))
+			for _, entity := range entityDiff.Removed {
+				fmt.Fprintf(w, "- %s (%d)\n", entity.displayName(lang), entity.Id)
+			}
+		}
+
+		if len(entityDiff.Changed) > 0 {
+			fmt.Fprintf(w, "\n### Changed (%d)\n\n", len(entityDiff.Changed))
+			for _, entity := range entityDiff.Changed {
+				fields := make([]string, 0, len(entity.Fields))
+				for _, field := range entity.Fields {
+					fields = append(fields, field.Field)
+				}
+
+				line := fmt.Sprintf("- **%s** (%d)", entity.displayName(lang), entity.Id)
+				if len(fields) > 0 {
+					line += ": " + strings.Join(fields, ", ")
+				}
+				fmt.Fprintln(w, line)
+
+				for _, translation := range entity.Translations {
+					if translation.Language != lang {
+						continue
+					}
+					fmt.Fprintf(w, "  - %s: \"%s\" → \"%s\"\n", translation.Field, translation.Old, translation.New)
+				}
+			}
+		}
+	}
+}
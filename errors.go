@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/doduda/pkg/doduda"
+)
+
+// The error types live in the library, the command only adds exit codes.
+type (
+	ErrorKind = doduda.ErrorKind
+	Error     = doduda.Error
+)
+
+const (
+	KindUnknown         = doduda.KindUnknown
+	KindUsage           = doduda.KindUsage
+	KindNetwork         = doduda.KindNetwork
+	KindVersionNotFound = doduda.KindVersionNotFound
+	KindManifest        = doduda.KindManifest
+	KindUnpack          = doduda.KindUnpack
+	KindContainer       = doduda.KindContainer
+	KindIO              = doduda.KindIO
+	KindCanceled        = doduda.KindCanceled
+)
+
+// Exit codes of the doduda command. They are part of the public interface,
+// only add new ones.
+const (
+	ExitOK              = 0
+	ExitFailure         = 1
+	ExitUsage           = 2
+	ExitNetwork         = 3
+	ExitVersionNotFound = 4
+	ExitManifest        = 5
+	ExitUnpack          = 6
+	ExitContainer       = 7
+	ExitIO              = 8
+	ExitDiskFull        = 9
+	ExitCanceled        = 130
+)
+
+// newError wraps err with the step that failed, see doduda.NewError.
+func newError(kind ErrorKind, op string, err error) error {
+	return doduda.NewError(kind, op, err)
+}
+
+// KindOf returns the kind of err.
+func KindOf(err error) ErrorKind {
+	return doduda.KindOf(err)
+}
+
+// ExitCode maps an error to the exit code of the doduda command.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	if errors.Is(err, syscall.ENOSPC) {
+		return ExitDiskFull
+	}
+
+	switch KindOf(err) {
+	case KindUsage:
+		return ExitUsage
+	case KindNetwork:
+		return ExitNetwork
+	case KindVersionNotFound:
+		return ExitVersionNotFound
+	case KindManifest:
+		return ExitManifest
+	case KindUnpack:
+		return ExitUnpack
+	case KindContainer:
+		return ExitContainer
+	case KindIO:
+		return ExitIO
+	case KindCanceled:
+		return ExitCanceled
+	default:
+		return ExitFailure
+	}
+}
+
+// exitWithError logs err and exits with its exit code.
+func exitWithError(err error) {
+	log.Error(err)
+	os.Exit(ExitCode(err))
+}
+
+// errCanceledByUser is returned when the interface was closed while working.
+var errCanceledByUser = &Error{Kind: KindCanceled, Err: errors.New("canceled by user")}
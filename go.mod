@@ -12,9 +12,12 @@ require (
 	github.com/docker/docker v27.3.1+incompatible
 	github.com/dofusdude/ankabuffer v0.0.9
 	github.com/dofusdude/dodumap v0.5.5
+	github.com/pierrec/lz4/v4 v4.1.31
 	github.com/spf13/cobra v1.8.1
 	github.com/spf13/viper v1.19.0
+	github.com/ulikunitz/xz v0.5.17
 	github.com/xhhuango/json v1.19.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -77,6 +80,5 @@ require (
 	golang.org/x/text v0.20.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gotest.tools/v3 v3.5.1 // indirect
 )
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"image/png"
@@ -14,11 +15,53 @@ import (
 	"github.com/charmbracelet/log"
 
 	"github.com/dofusdude/ankabuffer"
+	"github.com/dofusdude/doduda/pkg/doduda"
 	"github.com/dofusdude/doduda/ui"
 	"github.com/dofusdude/doduda/unpack"
 )
 
-func unpackD2pFolder(title string, inPath string, outPath string, headless bool) {
+// unpackD2pFile writes the files of a d2p archive into outPath. Entries are
+// read one at a time, linked archives are unpacked on their own.
+func unpackD2pFile(file string, outPath string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return newError(KindIO, filepath.Base(file), err)
+	}
+	defer f.Close()
+
+	archive, err := unpack.NewD2P(f)
+	if err != nil {
+		return newError(KindUnpack, filepath.Base(file), err)
+	}
+
+	for _, entry := range archive.Entries() {
+		if filepath.Ext(entry.Name) == ".swl" {
+			log.Warnf("can not unpack swl file %s", entry.Name)
+		}
+
+		if !filepath.IsLocal(filepath.FromSlash(entry.Name)) {
+			return newError(KindUnpack, filepath.Base(file), fmt.Errorf("invalid file name %s", entry.Name))
+		}
+
+		data, err := entry.Read()
+		if err != nil {
+			return newError(KindUnpack, filepath.Base(file), err)
+		}
+
+		outFile := filepath.Join(outPath, filepath.FromSlash(entry.Name))
+		err = os.MkdirAll(filepath.Dir(outFile), os.ModePerm)
+		if err == nil {
+			err = os.WriteFile(outFile, data, 0644)
+		}
+		if err != nil {
+			return newError(KindIO, filepath.Base(file), err)
+		}
+	}
+
+	return nil
+}
+
+func unpackD2pFolder(title string, inPath string, outPath string, headless bool) error {
 	files := []string{}
 	filepath.Walk(inPath, func(path string, info os.FileInfo, err error) error {
 		if filepath.Ext(path) == ".d2p" {
@@ -40,41 +83,19 @@ func unpackD2pFolder(title string, inPath string, outPath string, headless bool)
 	}()
 
 	for _, file := range files {
-		f, err := os.Open(file)
-		if err != nil {
-			log.Fatal(err)
+		if err := unpackD2pFile(file, outPath); err != nil {
+			return newError(KindUnpack, "unpack "+title, err)
 		}
-		defer f.Close()
-
-		converted := unpack.NewD2P(f).GetFiles()
-		for filename, specs := range converted {
-			outFile := filepath.Join(outPath, filename)
-
-			if filepath.Ext(filename) == ".swl" {
-				log.Warnf("can not unpack swl file %s", filename)
-			}
-
-			f, err := os.Create(outFile)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer f.Close()
-
-			_, err = f.Write(specs["binary"].([]byte))
-			if err != nil {
-				log.Fatal(err)
-			}
-			if isChannelClosed(updateProgress) {
-				os.Exit(1)
-			}
+		if isChannelClosed(updateProgress) {
+			return errCanceledByUser
 		}
 		updateProgress <- true
 	}
 
 	wg.Wait()
+	return nil
 }
 
-
 func moveFilesToParentFolder(dir string) error {
 	files, err := os.ReadDir(dir)
 	if err != nil {
@@ -171,203 +192,231 @@ func cleanImages(dir string, dim int, patternExcluded *regexp.Regexp) error {
 			}
 		}
 	}
-	
+
 	fmt.Printf("Renamed: %d, Deleted: %d from %d in %s\n", renamedCount, deletedCount, total, assetType)
 	return err
 }
 
-func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, version int, dir string, headless bool) error {
+// ImageCleaning keeps the images of a folder with the given dimension, see
+// cleanImages.
+type ImageCleaning struct {
+	Path    string `mapstructure:"path"`    // relative to the images folder
+	Dim     int    `mapstructure:"dim"`     // 0 keeps all dimensions
+	Exclude string `mapstructure:"exclude"` // regex of file names to delete
+}
+
+// ImageLayout arranges the unpacked Dofus 3 images in the images folder. All
+// paths are slash separated and relative to the images folder, Rename
+// sources are relative to its Assets/BuiltAssets folder.
+type ImageLayout struct {
+	Rename   map[string]string `mapstructure:"rename"` // destination -> source
+	Remove   []string          `mapstructure:"remove"`
+	Cleaning []ImageCleaning   `mapstructure:"cleaning"`
+	Flatten  []string          `mapstructure:"flatten"` // folders whose files move to their parent
+}
+
+func DefaultImageLayout() *ImageLayout {
+	return &ImageLayout{
+		Rename: map[string]string{
+			"items":         "items/2x",
+			"monsters":      "monsters/2x",
+			"ui/alignments": "alignments/2x",
+			"ui/challenges": "challenges/2x",
+			"ui/companions": "companions/2x",
+			"ui/cosmetics":  "cosmetics/2x",
+			"ui/emblems":    "emblems/big",
+			"ui/emotes":     "emotes/2x",
+			"ui/jobs":       "jobs/2x",
+			"ui/mounts":     "mounts/big",
+			"ui/presets":    "presets/2x",
+			"ui/smilies":    "smilies/2x",
+			"ui/spells":     "spells/2x",
+		},
+		Remove: []string{
+			"Assets",
+			"monster_images.imagebundle",
+			"mount_images.imagebundle",
+			"spell_images.imagebundle",
+			"spellstate_images.imagebundle",
+			"job_images.imagebundle",
+			"preset_images.imagebundle",
+			"smiley_images.imagebundle",
+			"emote_images.imagebundle",
+		},
+		Cleaning: []ImageCleaning{
+			{"items", 128, ""},
+			{"monsters", 128, ""},
+			{"ui/achievements", 58, ""},
+			{"ui/alignments", 0, ""},
+			{"ui/arena", 0, `(left|right|middle)BG`},
+			{"ui/challenges", 0, ""},
+			{"ui/companions", 168, ""},
+			{"ui/cosmetics", 128, ""},
+			{"ui/document", 0, ""},
+			{"ui/emblems/backcontent/2x", 0, ""},
+			{"ui/emblems/outlinealliance/2x", 0, ""},
+			{"ui/emblems/outlineguild/2x", 0, ""},
+			{"ui/emblems/up/2x", 0, ""},
+			{"ui/emotes", 0, ""},
+			{"ui/guidebook", 0, ""},
+			{"ui/guildrank", 0, ""},
+			{"ui/house", 0, ""},
+			{"ui/icon", 0, ""},
+			{"ui/illus", 0, `^\d`},
+			{"ui/jobs", 0, ""},
+			{"ui/mounts", 256, ""},
+			{"ui/ornament", 0, ""},
+			{"ui/presets", 96, ""},
+			{"ui/smilies", 64, ""},
+			{"ui/spells", 0, ""},
+			{"ui/spellstates", 0, ""},
+			{"ui/suggestion", 200, ""},
+		},
+		Flatten: []string{
+			"ui/emblems/backcontent/2x",
+			"ui/emblems/outlinealliance/2x",
+			"ui/emblems/outlineguild/2x",
+			"ui/emblems/up/2x",
+		},
+	}
+}
+
+// DownloadImagesLauncher downloads and unpacks the images. A nil layout uses
+// DefaultImageLayout.
+func DownloadImagesLauncher(ctx context.Context, client *doduda.Client, rules *doduda.SelectionRules, hashJson *ankabuffer.Manifest, layout *ImageLayout, bin int, version int, dir string, headless bool, state *IncrementalState) error {
+	if layout == nil {
+		layout = DefaultImageLayout()
+	}
+
 	inPath := filepath.Join(dir, "tmp")
 	outPath := filepath.Join(dir, "images")
-	monstersPath := filepath.Join(dir, "images", "monsters")
 	uiPath := filepath.Join(dir, "images", "ui")
-	itemsPath := filepath.Join(dir, "images", "items")
-	
+
 	if version == 2 {
-		fileNames := []HashFile{
-			{Filename: "content/gfx/items/bitmap0.d2p", FriendlyName: "bitmaps_0.d2p"},
-			{Filename: "content/gfx/items/bitmap0_1.d2p", FriendlyName: "bitmaps_1.d2p"},
-			{Filename: "content/gfx/items/bitmap1.d2p", FriendlyName: "bitmaps_2.d2p"},
-			{Filename: "content/gfx/items/bitmap1_1.d2p", FriendlyName: "bitmaps_3.d2p"},
-			{Filename: "content/gfx/items/bitmap1_2.d2p", FriendlyName: "bitmaps_4.d2p"},
+		fileNames, err := selectFiles(rules, hashJson, "item-bitmaps", version, dir)
+		if err != nil {
+			return err
 		}
-		
-		if err := DownloadUnpackFiles("Item Bitmaps", bin, hashJson, "main", fileNames, dir, inPath, false, "", headless, false); err != nil {
+
+		if err := downloadSelection(ctx, client, hashJson, fileNames, doduda.DownloadOptions{Title: "Item Bitmaps", DestDir: inPath, BinSize: bin, State: state}, headless); err != nil {
 			return err
 		}
-		
-		unpackD2pFolder("Item Bitmaps", inPath, outPath, headless)
-		
-		fileNames = []HashFile{
-			{Filename: "content/gfx/items/vector0.d2p", FriendlyName: "vector_0.d2p"},
-			{Filename: "content/gfx/items/vector0_1.d2p", FriendlyName: "vector_1.d2p"},
-			{Filename: "content/gfx/items/vector1.d2p", FriendlyName: "vector_2.d2p"},
-			{Filename: "content/gfx/items/vector1_1.d2p", FriendlyName: "vector_3.d2p"},
-			{Filename: "content/gfx/items/vector1_2.d2p", FriendlyName: "vector_4.d2p"},
+
+		if err := unpackD2pFolder("Item Bitmaps", inPath, outPath, headless); err != nil {
+			return err
+		}
+
+		fileNames, err = selectFiles(rules, hashJson, "item-vectors", version, dir)
+		if err != nil {
+			return err
 		}
-		
+
 		inPath = filepath.Join(dir, "tmp", "vector")
 		outPath = filepath.Join(dir, "vector", "item")
-		if err := DownloadUnpackFiles("Item Vectors", bin, hashJson, "main", fileNames, dir, inPath, false, "", headless, false); err != nil {
+		if err := downloadSelection(ctx, client, hashJson, fileNames, doduda.DownloadOptions{Title: "Item Vectors", DestDir: inPath, BinSize: bin, State: state}, headless); err != nil {
 			return err
 		}
 
-		unpackD2pFolder("Item Vectors", inPath, outPath, headless)
+		if err := unpackD2pFolder("Item Vectors", inPath, outPath, headless); err != nil {
+			return err
+		}
 
 		return nil
 	} else if version == 3 {
-		err := PullImages([]string{"stelzo/assetstudio-cli:" + ARCH}, false, headless)	
-		if err != nil { return err }
-		
-		fileNames := []HashFile{ 
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/Items/item_assets_2x.bundle", FriendlyName: "item_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/Monsters/monster_assets_2x.bundle", FriendlyName: "monster_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/mount_assets_.bundle", FriendlyName: "mount_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/Spells/spell_assets_2x.bundle", FriendlyName: "spell_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/alignment_assets_2x.bundle", FriendlyName: "alignment_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/challenge_assets_2x.bundle", FriendlyName: "challenges_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/companion_assets_2x.bundle", FriendlyName: "companion_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/cosmetic_assets_2x.bundle", FriendlyName: "cosmetic_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/emblem_assets_2x.bundle", FriendlyName: "emblem_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/emote_assets_2x.bundle", FriendlyName: "emote_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/job_assets_2x.bundle", FriendlyName: "job_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/preset_assets_2x.bundle", FriendlyName: "preset_images.imagebundle"},
-			{Filename: "Dofus_Data/StreamingAssets/Content/Picto/UI/smiley_assets_2x.bundle", FriendlyName: "smiley_images.imagebundle"},
+		fileNames, err := selectFiles(rules, hashJson, "images", version, dir)
+		if err != nil {
+			return err
 		}
-		err = DownloadUnpackFiles("Downloading assets", bin, hashJson, "picto", fileNames, dir, outPath, true, "", headless, false)
-		if err != nil { return err }
-
-		uiPaths := map[string]string{
-			"arena":        "Dofus_Data/StreamingAssets/Content/Picto/UI/arena_assets_all.bundle",
-			"achievements": "Dofus_Data/StreamingAssets/Content/Picto/UI/achievement_assets_all.bundle",
-			"document":     "Dofus_Data/StreamingAssets/Content/Picto/UI/document_assets_all.bundle",
-			"guidebook":    "Dofus_Data/StreamingAssets/Content/Picto/UI/guidebook_assets_all.bundle",
-			"guildrank":    "Dofus_Data/StreamingAssets/Content/Picto/UI/guildrank_assets_all.bundle",
-			"house":        "Dofus_Data/StreamingAssets/Content/Picto/UI/house_assets_all.bundle",
-			"icon":         "Dofus_Data/StreamingAssets/Content/Picto/UI/icon_assets_all.bundle",
-			"illus":        "Dofus_Data/StreamingAssets/Content/Picto/UI/illus_assets_all.bundle",
-			"ornament":     "Dofus_Data/StreamingAssets/Content/Picto/UI/ornament_assets_all.bundle",
-			"spellstates":  "Dofus_Data/StreamingAssets/Content/Picto/Spells/spellstate_assets_all.bundle",
-			"suggestion":   "Dofus_Data/StreamingAssets/Content/Picto/UI/suggestion_assets_all.bundle",
-			// "worldmaps":    "Dofus_Data/StreamingAssets/Content/Picto/Worldmaps/worldmap_assets__4ba03324f4420d542d1ee6d3f566f3d1.bundle",
+
+		err = downloadSelection(ctx, client, hashJson, fileNames, doduda.DownloadOptions{Title: "Downloading assets", DestDir: outPath, Unpack: true, BinSize: bin, State: state}, headless)
+		if err != nil {
+			return err
 		}
 
-		for key, path := range uiPaths {
-			outPathUI := filepath.Join(uiPath, key)
-			fileNames := []HashFile{{Filename: path, FriendlyName: key + "_images.imagebundle"}}
-			err = DownloadUnpackFiles("Downloading "+key, bin, hashJson, "picto", fileNames, dir, outPathUI, true, "", headless, false)
-			if err != nil {
-				return err
+		uiFiles, err := selectFiles(rules, hashJson, "ui-images", version, dir)
+		if err != nil {
+			return err
+		}
+
+		// each ui bundle is unpacked into the folder named like its friendly name
+		for fragment, files := range uiFiles {
+			for _, file := range files {
+				key := strings.TrimSuffix(file.FriendlyName, "_images.imagebundle")
+				outPathUI := filepath.Join(uiPath, key)
+				err = downloadFiles(ctx, client, hashJson, []HashFile{file}, doduda.DownloadOptions{Title: "Downloading " + key, Fragment: fragment, DestDir: outPathUI, Unpack: true, BinSize: bin, State: state}, headless)
+				if err != nil {
+					return err
+				}
 			}
 		}
-		
+
 		feedbacks := make(chan string)
-		
+
 		var feedbackWg sync.WaitGroup
 		feedbackWg.Add(1)
 		go func() {
 			defer feedbackWg.Done()
 			ui.Spinner("Images", feedbacks, false, headless)
 		}()
-			
+
 		defer func() {
 			close(feedbacks)
 			feedbackWg.Wait()
 		}()
-			
+
 		feedbacks <- "cleaning"
-		
-		renamePaths := map[string]string{
-			"items":                				"items/2x",
-			"monsters":             				"monsters/2x",
-			filepath.Join("ui", "alignments"):  "alignments/2x",
-			filepath.Join("ui", "challenges"):  "challenges/2x",
-			filepath.Join("ui", "companions"):  "companions/2x",
-			filepath.Join("ui", "cosmetics"):   "cosmetics/2x",
-			filepath.Join("ui", "emblems"):     "emblems/big",
-			filepath.Join("ui", "emotes"):      "emotes/2x",
-			filepath.Join("ui", "jobs"):        "jobs/2x",
-			filepath.Join("ui", "mounts"):      "mounts/big",
-			filepath.Join("ui", "presets"):     "presets/2x",
-			filepath.Join("ui", "smilies"):     "smilies/2x",
-			filepath.Join("ui", "spells"):      "spells/2x",
-		}
 
-		for key, path := range renamePaths {
-			err = os.Rename(filepath.Join(outPath, "Assets", "BuiltAssets", path), filepath.Join(dir, "images", key))
+		for dest, src := range layout.Rename {
+			src := filepath.Join(outPath, "Assets", "BuiltAssets", filepath.FromSlash(src))
+			dest := filepath.Join(dir, "images", filepath.FromSlash(dest))
+			if _, err := os.Stat(src); os.IsNotExist(err) {
+				continue // bundle was not unpacked in this run, e.g. unchanged in incremental mode
+			}
+
+			err = os.RemoveAll(dest)
 			if err != nil {
 				return err
 			}
-		}
-		
-		removePaths := []string{
-			filepath.Join(outPath, "Assets"),
-			filepath.Join(outPath, "monster_images.imagebundle"),
-			filepath.Join(outPath, "mount_images.imagebundle"),
-			filepath.Join(outPath, "spell_images.imagebundle"),
-			filepath.Join(outPath, "spellstate_images.imagebundle"),
-			filepath.Join(outPath, "job_images.imagebundle"),
-			filepath.Join(outPath, "preset_images.imagebundle"),
-			filepath.Join(outPath, "smiley_images.imagebundle"),
-			filepath.Join(outPath, "emote_images.imagebundle"),
-		}
 
-		for _, path := range removePaths {
-			err = os.RemoveAll(path)
+			err = os.Rename(src, dest)
 			if err != nil {
 				return err
 			}
 		}
 
-		cleaningTasks := []struct {
-			path    string
-			dim     int
-			exclude *regexp.Regexp
-		}{
-			{itemsPath, 128, nil},
-			{monstersPath, 128, nil},
-			{filepath.Join(uiPath, "achievements"), 58, nil},
-			{filepath.Join(uiPath, "alignments"), 0, nil},
-			{filepath.Join(uiPath, "arena"), 0, regexp.MustCompile(`(left|right|middle)BG`)},
-			{filepath.Join(uiPath, "challenges"), 0, nil},
-			{filepath.Join(uiPath, "companions"), 168, nil},
-			{filepath.Join(uiPath, "cosmetics"), 128, nil},
-			{filepath.Join(uiPath, "document"), 0, nil},
-			{filepath.Join(uiPath, "emblems", "backcontent", "2x"), 0, nil},
-			{filepath.Join(uiPath, "emblems", "outlinealliance", "2x"), 0, nil},
-			{filepath.Join(uiPath, "emblems", "outlineguild", "2x"), 0, nil},
-			{filepath.Join(uiPath, "emblems", "up", "2x"), 0, nil},
-			{filepath.Join(uiPath, "emotes"), 0, nil},
-			{filepath.Join(uiPath, "guidebook"), 0, nil},
-			{filepath.Join(uiPath, "guildrank"), 0, nil},
-			{filepath.Join(uiPath, "house"), 0, nil},
-			{filepath.Join(uiPath, "icon"), 0, nil},
-			{filepath.Join(uiPath, "illus"), 0, regexp.MustCompile(`^\d`)},
-			{filepath.Join(uiPath, "jobs"), 0, nil},
-			{filepath.Join(uiPath, "mounts"), 256, nil},
-			{filepath.Join(uiPath, "ornament"), 0, nil},
-			{filepath.Join(uiPath, "presets"), 96, nil},
-			{filepath.Join(uiPath, "smilies"), 64, nil},
-			{filepath.Join(uiPath, "spells"), 0, nil},
-			{filepath.Join(uiPath, "spellstates"), 0, nil},
-			{filepath.Join(uiPath, "suggestion"), 200, nil},
+		for _, path := range layout.Remove {
+			err = os.RemoveAll(filepath.Join(outPath, filepath.FromSlash(path)))
+			if err != nil {
+				return err
+			}
 		}
 
-		for _, task := range cleaningTasks {
-			err = cleanImages(task.path, task.dim, task.exclude)
+		for _, task := range layout.Cleaning {
+			path := filepath.Join(outPath, filepath.FromSlash(task.Path))
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				continue
+			}
+
+			var exclude *regexp.Regexp
+			if task.Exclude != "" {
+				exclude, err = regexp.Compile(task.Exclude)
+				if err != nil {
+					return newError(KindUsage, "image cleaning "+task.Path, err)
+				}
+			}
+
+			err = cleanImages(path, task.Dim, exclude)
 			if err != nil {
 				return err
 			}
 		}
 
-		emblemPaths := []string{
-			filepath.Join(uiPath, "emblems", "backcontent", "2x"),
-			filepath.Join(uiPath, "emblems", "outlinealliance", "2x"),
-			filepath.Join(uiPath, "emblems", "outlineguild", "2x"),
-			filepath.Join(uiPath, "emblems", "up", "2x"),
-		}
+		for _, path := range layout.Flatten {
+			path := filepath.Join(outPath, filepath.FromSlash(path))
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				continue
+			}
 
-		for _, path := range emblemPaths {
 			err = moveFilesToParentFolder(path)
 			if err != nil {
 				return err
@@ -1,127 +1,228 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
 
+	"github.com/charmbracelet/log"
 	"github.com/dofusdude/ankabuffer"
+	"github.com/dofusdude/doduda/pkg/doduda"
 	"github.com/dofusdude/doduda/ui"
+	"github.com/dofusdude/doduda/unpack"
 )
 
-// TODO remove release when native implementation for .bin files exists.
-func DownloadLanguageFiles(release string, hashJson *ankabuffer.Manifest, bin int, version int, lang string, dir string, indent string, headless bool) error {
+// defaultLanguages returns the languages of a major game version.
+func defaultLanguages(version int) []string {
+	if version == 2 {
+		return []string{"fr", "en", "es", "de", "it", "pt"}
+	} else if version == 3 {
+		return []string{"fr", "en", "es", "de", "pt"}
+	}
+	return nil
+}
+
+// DownloadLanguageFiles downloads and unpacks the texts of one language. For
+// Dofus 3 the release is only needed when the .bin file can not be decoded
+// and the dofusdude language release is used instead.
+func DownloadLanguageFiles(ctx context.Context, client *doduda.Client, release string, hashJson *ankabuffer.Manifest, bin int, version int, lang string, dir string, indent string, headless bool, state *IncrementalState) error {
 	destPath := filepath.Join(dir, "languages")
 
 	if version == 2 {
-		var langFile HashFile
-		langFile.Filename = "data/i18n/i18n_" + lang + ".d2i"
-		langFile.FriendlyName = lang + ".d2i"
-		err := DownloadUnpackFiles(lang, bin, hashJson, "lang_"+lang, []HashFile{langFile}, dir, destPath, true, indent, headless, false)
+		fragment, langFile := languageFile(hashJson, version, lang)
+		err := downloadFiles(ctx, client, hashJson, []HashFile{langFile}, doduda.DownloadOptions{Title: lang, Fragment: fragment, DestDir: destPath, Unpack: true, Indent: indent, BinSize: bin, State: state}, headless)
 		return err
 	} else if version == 3 {
-		// TODO remove gh api part when native implementation for .json files exists.
-		feedbacks := make(chan string)
-
-		var feedbackWg sync.WaitGroup
-		feedbackWg.Add(1)
-		go func() {
-			defer feedbackWg.Done()
-			ui.Spinner("Languages", feedbacks, false, headless)
-		}()
+		err := downloadLanguageBin(ctx, client, hashJson, bin, lang, dir, destPath, indent, headless, state)
+		if err == nil {
+			if err := state.Save(); err != nil {
+				return newError(KindIO, "incremental state", err)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
 
-		feedbacks <- "searching"
+		log.Warn("Could not decode language file, using the dofusdude release", "lang", lang, "err", err)
+		return downloadLanguageRelease(ctx, client, release, lang, destPath, headless)
+	} else {
+		return errors.New("unsupported version: " + strconv.Itoa(version))
+	}
+}
 
-		if release == "dofus3" {
-			release = "main"
+// languageFile returns the manifest file with the texts of a language and its
+// fragment, which is empty if the manifest does not know the file.
+func languageFile(manifest *ankabuffer.Manifest, version int, lang string) (string, HashFile) {
+	if version == 2 {
+		return "lang_" + lang, HashFile{
+			Filename:     "data/i18n/i18n_" + lang + ".d2i",
+			FriendlyName: lang + ".d2i",
 		}
+	}
 
-		ghUrl := fmt.Sprintf("https://api.github.com/repos/dofusdude/dofus3-lang-%s/releases/latest", release)
-		releaseApiResponse, err := http.Get(ghUrl)
-		if err != nil {
-			return err
-		}
+	langFile := HashFile{
+		Filename:     "Dofus_Data/StreamingAssets/Content/I18n/" + lang + ".bin",
+		FriendlyName: lang + ".bin",
+	}
+	return manifestFragmentOf(manifest, langFile.Filename), langFile
+}
 
-		releaseApiResponseBody, err := io.ReadAll(releaseApiResponse.Body)
-		if err != nil {
-			return err
+// manifestFragmentOf returns the fragment that contains the file or an empty
+// string if the manifest does not know it.
+func manifestFragmentOf(manifest *ankabuffer.Manifest, filename string) string {
+	for name, fragment := range manifest.Fragments {
+		if _, ok := fragment.Files[filename]; ok {
+			return name
 		}
+	}
+	return ""
+}
 
-		var v map[string]interface{}
-		err = json.Unmarshal(releaseApiResponseBody, &v)
-		if err != nil {
-			return err
+// downloadLanguageBin downloads and decodes the .bin texts of a language. The
+// file only goes into the incremental state once it is decoded, otherwise the
+// fallback to the language release would be kept forever.
+func downloadLanguageBin(ctx context.Context, client *doduda.Client, hashJson *ankabuffer.Manifest, bin int, lang string, dir string, destPath string, indent string, headless bool, state *IncrementalState) error {
+	fragment, langFile := languageFile(hashJson, 3, lang)
+	if fragment == "" {
+		return fmt.Errorf("%s is not in the manifest", langFile.Filename)
+	}
+
+	manifestFile := hashJson.Fragments[fragment].Files[langFile.Filename]
+	jsonPath := filepath.Join(destPath, lang+".json")
+	if state.Unchanged(fragment, manifestFile) {
+		if _, err := os.Stat(jsonPath); err == nil {
+			return nil // unchanged since the last incremental run
 		}
+	}
+
+	err := downloadFiles(ctx, client, hashJson, []HashFile{langFile}, doduda.DownloadOptions{Title: lang, Fragment: fragment, DestDir: destPath, BinSize: bin}, headless)
+	if err != nil {
+		return err
+	}
+
+	binPath := filepath.Join(destPath, langFile.FriendlyName)
+	if _, err := os.Stat(binPath); os.IsNotExist(err) {
+		return fmt.Errorf("%s was not downloaded", langFile.Filename)
+	}
+	defer os.Remove(binPath)
 
-		// check if assets exists
-		if _, ok := v["assets"]; !ok {
-			return errors.New("Could not find assets in the latest release.")
+	rawData, err := os.ReadFile(binPath)
+	if err != nil {
+		return err
+	}
+
+	texts, err := unpack.NewI18nFile(rawData)
+	if err != nil {
+		return err
+	}
+
+	if err := marshalSave(texts, jsonPath, indent); err != nil {
+		return err
+	}
+
+	state.Update(fragment, manifestFile)
+	return nil
+}
+
+// downloadLanguageRelease loads the pre-built <lang>.i18n.json asset from the
+// latest dofusdude/dofus3-lang-* release.
+func downloadLanguageRelease(ctx context.Context, client *doduda.Client, release string, lang string, destPath string, headless bool) error {
+	feedbacks := make(chan string)
+
+	var feedbackWg sync.WaitGroup
+	feedbackWg.Add(1)
+	go func() {
+		defer feedbackWg.Done()
+		ui.Spinner("Languages", feedbacks, false, headless)
+	}()
+
+	defer func() {
+		close(feedbacks)
+		feedbackWg.Wait()
+	}()
+
+	feedbacks <- "searching"
+
+	if release == "dofus3" {
+		release = "main"
+	}
+
+	ghUrl := fmt.Sprintf("https://api.github.com/repos/dofusdude/dofus3-lang-%s/releases/latest", release)
+	releaseApiResponse, err := client.Get(ctx, ghUrl)
+	if err != nil {
+		return newError(KindNetwork, "language release", err)
+	}
+	defer releaseApiResponse.Body.Close()
+
+	releaseApiResponseBody, err := io.ReadAll(releaseApiResponse.Body)
+	if err != nil {
+		return newError(KindNetwork, "language release", err)
+	}
+
+	var v map[string]interface{}
+	err = json.Unmarshal(releaseApiResponseBody, &v)
+	if err != nil {
+		return err
+	}
+
+	// check if assets exists
+	if _, ok := v["assets"]; !ok {
+		return errors.New("Could not find assets in the latest release.")
+	}
+
+	assets := v["assets"].([]interface{})
+	for _, asset := range assets {
+		assetMap := asset.(map[string]interface{})
+		if assetMap["name"].(string) != fmt.Sprintf("%s.i18n.json", lang) {
+			continue
 		}
 
-		assets := v["assets"].([]interface{})
-		found := false
-		for _, asset := range assets {
-			assetMap := asset.(map[string]interface{})
-			if assetMap["name"].(string) == fmt.Sprintf("%s.i18n.json", lang) {
-				found = true
-				assetUrl := assetMap["browser_download_url"].(string)
-
-				feedbacks <- "loading " + lang
-
-				imagesResponse, err := http.Get(assetUrl)
-				if err != nil {
-					return err
-				}
-
-				file := filepath.Join(destPath, lang+".json")
-				out, err := os.Create(file)
-				if err != nil {
-					return err
-				}
-
-				_, err = io.Copy(out, imagesResponse.Body)
-				if err != nil {
-					return err
-				}
-			}
+		feedbacks <- "loading " + lang
+
+		assetResponse, err := client.Get(ctx, assetMap["browser_download_url"].(string))
+		if err != nil {
+			return newError(KindNetwork, "language release", err)
 		}
+		defer assetResponse.Body.Close()
 
-		if !found {
-			close(feedbacks)
-			feedbackWg.Wait()
-			return errors.New("Could not find the specified file in the latest release")
+		err = os.MkdirAll(destPath, os.ModePerm)
+		if err != nil {
+			return newError(KindIO, "language release", err)
 		}
 
-		close(feedbacks)
-		feedbackWg.Wait()
+		langPath := filepath.Join(destPath, lang+".json")
+		out, err := os.Create(langPath)
+		if err != nil {
+			return newError(KindIO, "language release", err)
+		}
+		defer out.Close()
 
-		/*langFile := HashFile{
-			Filename:     "Dofus_Data/StreamingAssets/Content/I18n/" + lang + ".bin",
-			FriendlyName: lang + ".bin",
+		_, err = io.Copy(out, assetResponse.Body)
+		if err != nil {
+			out.Close()
+			os.Remove(langPath)
+			return newError(KindNetwork, "language release", err)
 		}
-		err := DownloadUnpackFiles(lang, bin, hashJson, "i18n", []HashFile{langFile}, dir, destPath, false, indent, headless, false)*/
 		return nil
-	} else {
-		return errors.New("unsupported version: " + strconv.Itoa(version))
 	}
+
+	return errors.New("Could not find the specified file in the latest release")
 }
 
-func DownloadLanguages(release string, hashJson *ankabuffer.Manifest, bin int, version int, dir string, indent string, headless bool) error {
-	var langs []string
-	if version == 2 {
-		langs = []string{"fr", "en", "es", "de", "it", "pt"}
-	} else if version == 3 {
-		langs = []string{"fr", "en", "es", "de", "pt"}
+func DownloadLanguages(ctx context.Context, client *doduda.Client, release string, hashJson *ankabuffer.Manifest, langs []string, bin int, version int, dir string, indent string, headless bool, state *IncrementalState) error {
+	if len(langs) == 0 {
+		langs = defaultLanguages(version)
 	}
 
 	for _, lang := range langs {
-		err := DownloadLanguageFiles(release, hashJson, bin, version, lang, dir, indent, headless)
+		err := DownloadLanguageFiles(ctx, client, release, hashJson, bin, version, lang, dir, indent, headless, state)
 		if err != nil {
 			return err
 		}
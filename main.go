@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"path/filepath"
 
 	"github.com/charmbracelet/log"
+	"github.com/dofusdude/doduda/pkg/doduda"
 	"github.com/dofusdude/doduda/ui"
+	"github.com/dofusdude/doduda/unpack"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -24,6 +31,14 @@ var (
 	DodudaVersionHelp = DodudaShort + "\n" + DodudaVersion + "\nhttps://github.com/dofusdude/doduda"
 	ARCH              string
 
+	// cdn is shared by all commands, the root command configures it from
+	// its flags
+	cdn *doduda.Client
+
+	// selection picks the manifest files of each download stage, the root
+	// command replaces it with the rules of --rules
+	selection = doduda.DefaultSelectionRules()
+
 	rootCmd = &cobra.Command{
 		Use:           "doduda",
 		Short:         DodudaShort,
@@ -54,12 +69,52 @@ var (
 	watchdogCmd = &cobra.Command{
 		Use:           "listen",
 		Short:         "Spawns a watchdog.",
-		Long:          `Listens to the game version API from the Ankama Launcher and notifies you when a new version is available.`,
+		Long:          `Listens to the game version API from the Ankama Launcher and notifies you when a new version is available. Several releases and platforms are watched at once with comma separated lists like '-r main,dofus3,beta -p windows,linux', every changed release and platform is a separate hook call. With --pipeline each change is also downloaded, mapped and optionally rendered in-process.`,
 		SilenceErrors: true,
 		SilenceUsage:  false,
 		Run:           watchdogCommand,
 	}
 
+	diffCmd = &cobra.Command{
+		Use:           "diff <old-version> <new-version>",
+		Short:         "Compare the manifests of two game versions.",
+		Long:          `Reports added, removed and modified files per fragment. Each argument is either a cached manifest.json file or a game version like 3.0.12.13 or 'latest'.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           diffCommand,
+		Args:          cobra.ExactArgs(2),
+	}
+
+	diffDataCmd = &cobra.Command{
+		Use:           "diff-data <old-dir> <new-dir>",
+		Short:         "Compare the mapped game data of two output directories.",
+		Long:          `Matches the entities of the MAPPED_*.json files by ankama ID and writes a changelog JSON and Markdown patch notes.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           diffDataCommand,
+		Args:          cobra.ExactArgs(2),
+	}
+
+	runCmd = &cobra.Command{
+		Use:           "run",
+		Short:         "Run the pipeline declared in a config file.",
+		Long:          `Downloads, unpacks and maps every release and platform of a doduda.yaml. The config declares the file selection rules, the image layout, the languages and the mapping targets, see the README for all keys.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           runCommand,
+		Args:          cobra.NoArgs,
+	}
+
+	mapsCmd = &cobra.Command{
+		Use:           "maps <input-dir>",
+		Short:         "Export the Dofus 2 maps as JSON.",
+		Long:          `Decodes the .dlm map files in the input directory, loose or inside the maps*.d2p archives of a --full download, and writes one <map id>.json per map to <output>/maps. Maps contain the neighbours, layers, cells with movement and line of sight, fixtures and interactive elements.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           mapsCommand,
+		Args:          cobra.ExactArgs(1),
+	}
+
 	renderCmd = &cobra.Command{
 		Use:           "render <input-dir> <output-dir> <resolution>",
 		Short:         "Renders .swf files to specific resolutions.",
@@ -86,18 +141,28 @@ func main() {
 	viper.AutomaticEnv()
 	parsedLevel, err := log.ParseLevel(viper.GetString("LOG_LEVEL"))
 	if err != nil {
-		log.Fatal(err)
+		exitWithError(newError(KindUsage, "LOG_LEVEL", err))
 	}
 	log.SetLevel(parsedLevel)
 
 	rootCmd.Flags().Bool("version", false, "Print the doduda version.")
 	rootCmd.Flags().Bool("full", false, "Download the full game like the Ankama Launcher.")
-	rootCmd.PersistentFlags().BoolP("cache-ignore", "c", false, "Do not use cached manifest.")
-	//rootCmd.Flags().Bool("incremental", false, "Only download a file if the local version is different.")
+	rootCmd.Flags().BoolP("cache-ignore", "c", false, "Do not use cached manifest.")
+	rootCmd.Flags().String("rules", "", "YAML or JSON file with the rules that select the files to download from the manifest. Empty uses the built-in rules, print them with --print-rules.")
+	rootCmd.Flags().Bool("print-rules", false, "Print the built-in file selection rules as a starting point for --rules and exit.")
+	rootCmd.Flags().Bool("maps", false, "Also download and decode the maps. Dofus 3 additionally writes the worldmaps as XYZ tiles for Leaflet.")
+	rootCmd.Flags().Bool("dry-run", false, "Only load the manifest and print the files, bundles, sizes and bins that would be downloaded.")
+	rootCmd.Flags().Bool("json", false, "Print the --dry-run plan as JSON instead of tables.")
+	rootCmd.Flags().Bool("incremental", false, "Only download a file if its manifest hash changed since the last run in the output directory.")
+	rootCmd.Flags().String("bundle-cache", "", "Directory to keep downloaded bundles for later runs and to resume interrupted downloads. 'default' uses the user cache directory. Empty disables the cache, it is never pruned.")
+	rootCmd.Flags().Int("concurrency", 8, "Number of bundles to download in parallel.")
+	rootCmd.Flags().Int("retries", 4, "Retries with exponential backoff for bundle downloads that fail with server errors or timeouts.")
+	rootCmd.Flags().Duration("timeout", 5*time.Minute, "Timeout for a single bundle request. 0 disables it.")
+	rootCmd.Flags().String("bandwidth-limit", "", "Limit the overall download speed in bytes per second, for example 500K or 10M. Empty is unlimited.")
 	rootCmd.Flags().Int32("bin", 500, "Divide the files into smaller bins of the given size in Megabyte to reduce overall memory usage. Disable binning with -1.")
-	rootCmd.PersistentFlags().StringP("platform", "p", "windows", "For which platform to download the game. Available: 'windows', 'macos', 'linux'.")
+	rootCmd.PersistentFlags().StringP("platform", "p", "windows", "For which platform to download the game. Available: 'windows', 'macos', 'linux'. The download accepts a comma separated list like 'windows,linux'.")
 	rootCmd.PersistentFlags().Bool("headless", false, "Run without a TUI.")
-	rootCmd.PersistentFlags().StringP("release", "r", "dofus3", "Which Game release version type to use. Available: 'main', 'beta', 'dofus3'.")
+	rootCmd.PersistentFlags().StringP("release", "r", "dofus3", "Which Game release version type to use. Available: 'main', 'beta', 'dofus3'. The download accepts a comma separated list like 'dofus3,beta'.")
 	rootCmd.PersistentFlags().StringP("output", "o", "./data", "Working folder for output or input.")
 	rootCmd.PersistentFlags().String("manifest", "", "Manifest file path. Empty will download it if it is not found.")
 	rootCmd.PersistentFlags().StringArrayP("ignore", "i", []string{}, "Ignore downloading specific parts. Available: 'languages', 'data', 'images'.")
@@ -110,7 +175,14 @@ func main() {
 	watchdogCmd.Flags().StringP("hook", "H", "", "Hook URL to send a POST request to when a change is detected.")
 	watchdogCmd.Flags().String("auth-header", "", "Authorization header if required for the POST request. Example 'Bearer 12345'")
 	watchdogCmd.Flags().String("path", "", "Filepath for json version persistence. Defaults to `${dir}/.version.json`.")
-	watchdogCmd.Flags().String("body", "", "Filepath to a custom message body for the hook. Available variables ${release}, ${oldVersion}, ${newVersion}.")
+	watchdogCmd.Flags().String("body", "", "Filepath to a custom message body for the hook. Available variables ${release}, ${platform}, ${oldVersion}, ${newVersion} and with --pipeline ${pipeline}, ${stage}, ${error}.")
+	watchdogCmd.Flags().String("notifiers", "", "YAML or JSON file with notifiers for Discord, Slack, Telegram, Matrix, email or webhooks. Used together with --hook.")
+	watchdogCmd.Flags().String("hook-secret", "", "Secret to sign the hook timestamp and body with HMAC-SHA256 in the X-Doduda-Signature header.")
+	watchdogCmd.Flags().String("outbox", "", "Filepath for notifications that are not delivered yet. Defaults to .doduda-outbox.json next to the version file.")
+	watchdogCmd.Flags().Int("max-attempts", 10, "Delivery attempts of a notification before it is dropped. 0 retries forever.")
+	watchdogCmd.Flags().Duration("retry-backoff", 30*time.Second, "Delay before the first redelivery of a failed notification, doubled for every further attempt.")
+	watchdogCmd.Flags().String("pipeline", "", "doduda.yaml pipeline to run for every detected change, restricted to its release, platform and version. The status is notified like the change.")
+	watchdogCmd.Flags().String("http-addr", "", "Address like ':9090' for an HTTP listener with /healthz, /status and Prometheus /metrics. Empty disables it, needs an interval.")
 	watchdogCmd.Flags().Bool("initial-hook", false, "Notify immediately after checking the version after first timer event, even at first startup.")
 	watchdogCmd.Flags().Bool("volatile", false, "Controls writing the persistence file. Enabling it will trigger the hook every time the trigger fires.")
 	watchdogCmd.Flags().Bool("deadly-hook", false, "End process after first successful notification.")
@@ -118,13 +190,39 @@ func main() {
 	rootCmd.AddCommand(watchdogCmd)
 
 	renderCmd.Flags().String("incremental", "", "Start from the last version and only render missing images. The format must be <owner>/<repo>/<filename>")
+	renderCmd.Flags().String("runtime", "docker", "How to run the render tools. Available: 'docker', 'podman', 'nerdctl', 'local'.")
 	rootCmd.AddCommand(renderCmd)
 
+	diffCmd.Flags().Bool("json", false, "Print the diff as JSON instead of human-readable text.")
+	rootCmd.AddCommand(diffCmd)
+
+	diffDataCmd.Flags().String("changelog-dir", "", "Directory for CHANGELOG.json and PATCH_NOTES.md. Defaults to <new-dir>.")
+	diffDataCmd.Flags().String("lang", "en", "Language used for names in the patch notes.")
+	rootCmd.AddCommand(diffDataCmd)
+
+	runCmd.Flags().StringP("config", "c", "doduda.yaml", "Pipeline config file.")
+	rootCmd.AddCommand(runCmd)
+
+	mapsCmd.Flags().String("key", unpack.DefaultDLMKey, "Key to decrypt encrypted maps.")
+	rootCmd.AddCommand(mapsCmd)
+
 	rootCmd.AddCommand(versionCmd)
 
-	err = rootCmd.Execute()
-	if err != nil && err.Error() != "" {
-		fmt.Fprintln(os.Stderr, err)
+	cdn, err = doduda.NewClient(doduda.DefaultOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = rootCmd.ExecuteContext(ctx)
+	if err != nil {
+		if err.Error() != "" {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		stop()
+		os.Exit(ExitUsage) // cobra only fails on invalid arguments and flags
 	}
 }
 
@@ -134,23 +232,23 @@ func renderCommand(ccmd *cobra.Command, args []string) {
 	inputDir := args[0]
 	inputDir, err = filepath.Abs(inputDir)
 	if err != nil {
-		log.Fatal("Invalid input directory")
+		exitWithError(newError(KindUsage, "render", errors.New("invalid input directory")))
 	}
 
 	outputDir := args[1]
 	outputDir, err = filepath.Abs(outputDir)
 	if err != nil {
-		log.Fatal("Invalid input directory")
+		exitWithError(newError(KindUsage, "render", errors.New("invalid output directory")))
 	}
 
 	err = os.MkdirAll(outputDir, os.ModePerm)
 	if err != nil {
-		log.Fatal(err)
+		exitWithError(newError(KindIO, "render", err))
 	}
 
 	resolution, err := strconv.Atoi(args[2])
 	if err != nil {
-		log.Fatal("Invalid resolution")
+		exitWithError(newError(KindUsage, "render", errors.New("invalid resolution")))
 	}
 
 	headless, err := ccmd.Flags().GetBool("headless")
@@ -168,14 +266,19 @@ func renderCommand(ccmd *cobra.Command, args []string) {
 
 		incrementalParts = strings.Split(incremental, "/")
 		if len(incrementalParts) != 3 {
-			log.Fatal("Invalid incremental format. Expected <owner>/<repo>/<filename>. The filename is the exact name from the latest release without extension, that must be .tar.gz.")
+			exitWithError(newError(KindUsage, "render", errors.New("invalid incremental format. Expected <owner>/<repo>/<filename>. The filename is the exact name from the latest release without extension, that must be .tar.gz")))
 		}
 	}
 
-	err = Render(inputDir, outputDir, incrementalParts, resolution, headless)
+	runtimeName, err := ccmd.Flags().GetString("runtime")
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	err = Render(ccmd.Context(), cdn, inputDir, outputDir, incrementalParts, resolution, runtimeName, headless)
+	if err != nil {
+		exitWithError(err)
+	}
 }
 
 func parseWd(dir string) string {
@@ -183,13 +286,13 @@ func parseWd(dir string) string {
 
 	dir, err = filepath.Abs(dir)
 	if err != nil {
-		log.Fatal(err)
+		exitWithError(newError(KindUsage, "working directory", err))
 	}
 
 	if dir[:1] == "." {
 		dir, err = os.Getwd()
 		if err != nil {
-			log.Fatal(err)
+			exitWithError(newError(KindIO, "working directory", err))
 		}
 	}
 
@@ -197,7 +300,7 @@ func parseWd(dir string) string {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		err = os.MkdirAll(dir, os.ModePerm)
 		if err != nil {
-			log.Fatal(err)
+			exitWithError(newError(KindIO, "working directory", err))
 		}
 	}
 
@@ -211,8 +314,16 @@ func versionCommand(ccmd *cobra.Command, args []string) {
 	}
 
 	if gameRelease != "main" && gameRelease != "beta" && gameRelease != "dofus3" {
-		fmt.Println("Invalid release type")
-		os.Exit(1)
+		exitWithError(newError(KindUsage, "version", fmt.Errorf("invalid release type %s", gameRelease)))
+	}
+
+	platform, err := ccmd.Flags().GetString("platform")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if platform == "macos" {
+		platform = "darwin"
 	}
 
 	headless, err := ccmd.Flags().GetBool("headless")
@@ -229,26 +340,200 @@ func versionCommand(ccmd *cobra.Command, args []string) {
 			ui.Spinner("Manifest", feedbacks, false, headless)
 		}()
 
-		if isChannelClosed(feedbacks) {
-			os.Exit(1)
-		}
 		feedbacks <- "loading"
 	}
 
-	cytrusPrefix := "6.0_"
-	version := GetLatestLauncherVersion(gameRelease)
-	if !strings.HasPrefix(version, cytrusPrefix) {
-		version = fmt.Sprintf("%s%s", cytrusPrefix, version)
-	}
-
-	dofusVersion := strings.TrimPrefix(version, cytrusPrefix)
+	dofusVersion, err := cdn.LatestVersion(ccmd.Context(), gameRelease, platform)
 
 	close(feedbacks)
 	manifestWg.Wait()
 
+	if err != nil {
+		exitWithError(err)
+	}
+
 	fmt.Println(dofusVersion)
 }
 
+func diffCommand(ccmd *cobra.Command, args []string) {
+	gameRelease, err := ccmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	platform, err := ccmd.Flags().GetString("platform")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if platform == "macos" {
+		platform = "darwin"
+	}
+
+	asJson, err := ccmd.Flags().GetBool("json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	indent, err := ccmd.Flags().GetBool("indent")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oldManifest, err := LoadManifest(ccmd.Context(), args[0], gameRelease, platform)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	newManifest, err := LoadManifest(ccmd.Context(), args[1], gameRelease, platform)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	diff := DiffManifests(oldManifest, newManifest)
+
+	if !asJson {
+		diff.WriteText(os.Stdout)
+		return
+	}
+
+	var out []byte
+	if indent {
+		out, err = json.MarshalIndent(diff, "", "  ")
+	} else {
+		out, err = json.Marshal(diff)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
+
+func diffDataCommand(ccmd *cobra.Command, args []string) {
+	oldDir, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(newError(KindUsage, "diff-data", err))
+	}
+
+	newDir, err := filepath.Abs(args[1])
+	if err != nil {
+		exitWithError(newError(KindUsage, "diff-data", err))
+	}
+
+	changelogDir, err := ccmd.Flags().GetString("changelog-dir")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if changelogDir == "" {
+		changelogDir = newDir
+	} else {
+		changelogDir = parseWd(changelogDir)
+	}
+
+	lang, err := ccmd.Flags().GetString("lang")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	indent, err := ccmd.Flags().GetBool("indent")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var indentation string
+	if indent {
+		indentation = "  "
+	}
+
+	changelog, err := DiffMappedData(oldDir, newDir)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	err = marshalSave(changelog, filepath.Join(changelogDir, "CHANGELOG.json"), indentation)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	notes, err := os.Create(filepath.Join(changelogDir, "PATCH_NOTES.md"))
+	if err != nil {
+		exitWithError(newError(KindIO, "patch notes", err))
+	}
+	defer notes.Close()
+
+	changelog.WriteMarkdown(notes, lang)
+}
+
+func runCommand(ccmd *cobra.Command, args []string) {
+	configPath, err := ccmd.Flags().GetString("config")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	headless, err := ccmd.Flags().GetBool("headless")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		exitWithError(newError(KindUsage, "config", err))
+	}
+
+	config, err := LoadPipelineConfig(configPath)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	rules, client, err := config.PipelineSetup(filepath.Dir(configPath))
+	if err != nil {
+		exitWithError(err)
+	}
+
+	err = RunPipeline(ccmd.Context(), config, filepath.Dir(configPath), rules, client, headless)
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+func mapsCommand(ccmd *cobra.Command, args []string) {
+	inPath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(newError(KindUsage, "maps", err))
+	}
+
+	dir, err := ccmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	key, err := ccmd.Flags().GetString("key")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	indent, err := ccmd.Flags().GetBool("indent")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	headless, err := ccmd.Flags().GetBool("headless")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var indentation string
+	if indent {
+		indentation = "  "
+	}
+
+	err = ExportMaps(ccmd.Context(), inPath, filepath.Join(parseWd(dir), "maps"), key, indentation, headless)
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
 func mapCommand(ccmd *cobra.Command, args []string) {
 	dir, err := ccmd.Flags().GetString("output")
 	if err != nil {
@@ -291,7 +576,10 @@ func mapCommand(ccmd *cobra.Command, args []string) {
 	} else {
 		indentation = ""
 	}
-	Map(dir, indentation, persistenceDir, gameRelease, headless)
+	err = Map(ccmd.Context(), cdn, dir, indentation, persistenceDir, gameRelease, nil, headless)
+	if err != nil {
+		exitWithError(err)
+	}
 }
 
 func watchdogCommand(ccmd *cobra.Command, args []string) {
@@ -307,6 +595,21 @@ func watchdogCommand(ccmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 
+	releases := splitList(gameRelease)
+	if len(releases) == 0 {
+		exitWithError(newError(KindUsage, "release", fmt.Errorf("no release given")))
+	}
+
+	platform, err := ccmd.Flags().GetString("platform")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	platforms, err := parsePlatforms(splitList(platform))
+	if err != nil {
+		exitWithError(err)
+	}
+
 	hook, err := ccmd.Flags().GetString("hook")
 	if err != nil {
 		log.Fatal(err)
@@ -351,22 +654,191 @@ func watchdogCommand(ccmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 
-	watchdogEnd := make(chan bool)
+	notifiersPath, err := ccmd.Flags().GetString("notifiers")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hookSecret, err := ccmd.Flags().GetString("hook-secret")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outboxPath, err := ccmd.Flags().GetString("outbox")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if outboxPath == "" {
+		outboxPath = filepath.Join(filepath.Dir(versionFilePath), outboxFileName)
+	}
+
+	maxAttempts, err := ccmd.Flags().GetInt("max-attempts")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	retryBackoff, err := ccmd.Flags().GetDuration("retry-backoff")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if retryBackoff <= 0 {
+		exitWithError(newError(KindUsage, "retry-backoff", errors.New("the delay must be positive")))
+	}
+
+	pipelinePath, err := ccmd.Flags().GetString("pipeline")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	httpAddr, err := ccmd.Flags().GetString("http-addr")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var pipeline *PipelineConfig
+	var pipelineRules *doduda.SelectionRules
+	var pipelineClient *doduda.Client
+	if pipelinePath != "" {
+		pipelinePath, err = filepath.Abs(pipelinePath)
+		if err != nil {
+			exitWithError(newError(KindUsage, "pipeline", err))
+		}
+
+		pipeline, err = LoadPipelineConfig(pipelinePath)
+		if err != nil {
+			exitWithError(err)
+		}
+
+		pipelineRules, pipelineClient, err = pipeline.PipelineSetup(filepath.Dir(pipelinePath))
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+
+	var notifiers []Notifier
+	if hook != "" {
+		notifiers = append(notifiers, withRedactedErrors(&webhookNotifier{name: "hook", url: hook, authHeader: authHeader, bodyPath: customBodyPath, secret: hookSecret}, hook, authHeader, hookSecret))
+	}
+
+	if notifiersPath != "" {
+		fileNotifiers, err := LoadNotifiers(parseWd(notifiersPath))
+		if err != nil {
+			exitWithError(err)
+		}
+		notifiers = append(notifiers, fileNotifiers...)
+	}
+
+	if err := checkNotifierNames(notifiers); err != nil {
+		exitWithError(err)
+	}
+
+	if len(notifiers) == 0 {
+		log.Warn("No --hook or --notifiers given, changes are only logged")
+	}
+
+	if volatile {
+		outboxPath = ""
+	}
+
+	outbox, err := LoadOutbox(outboxPath, maxAttempts, retryBackoff)
+	if err != nil {
+		exitWithError(newError(KindIO, "outbox", err))
+	}
+
+	watchdog := &Watchdog{
+		VersionFilePath: versionFilePath,
+		Volatile:        volatile,
+		InitialHook:     initialHook,
+		Notifiers:       notifiers,
+		Outbox:          outbox,
+		DeadlyHook:      deadlyHook,
+		Pipeline:        pipeline,
+		PipelineDir:     filepath.Dir(pipelinePath),
+		PipelineRules:   pipelineRules,
+		PipelineClient:  pipelineClient,
+	}
+	for _, release := range releases {
+		for _, platform := range platforms {
+			watchdog.Targets = append(watchdog.Targets, watchTarget{release: release, platform: platform})
+		}
+	}
+
+	ctx := ccmd.Context()
 	if interval == 0 {
-		watchdogTick(watchdogEnd, nil, dir, gameRelease, versionFilePath, customBodyPath, volatile, &initialHook, hook, authHeader, deadlyHook)
-		close(watchdogEnd)
-	} else {
-		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
-		go func(initialHook *bool) {
-			for range ticker.C {
-				watchdogTick(watchdogEnd, ticker, dir, gameRelease, versionFilePath, customBodyPath, volatile, initialHook, hook, authHeader, deadlyHook)
+		watchdog.Tick(ctx)
+		watchdog.Wait(ctx)
+		return
+	}
+
+	if httpAddr != "" {
+		watchdog.Monitor = NewMonitor(time.Duration(interval) * time.Minute)
+		watchdog.Outbox.OnAttempt = watchdog.Monitor.Attempt
+		watchdog.Monitor.Pending(len(watchdog.Outbox.Pending))
+		if err := watchdog.Monitor.Listen(ctx, httpAddr); err != nil {
+			exitWithError(newError(KindUsage, "http-addr", err))
+		}
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	defer ticker.Stop()
+
+	fmt.Println(ui.DotStyle.Render("Watchdog started 🐶"))
+	if watchdog.Deliver(ctx) { // left over from the last run
+		return
+	}
+
+	defer watchdog.Wait(ctx)
+	for {
+		var retry <-chan time.Time
+		if next, ok := watchdog.Outbox.NextAttempt(); ok {
+			retry = time.After(time.Until(next))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if watchdog.Tick(ctx) {
+				return
+			}
+		case <-retry:
+			if watchdog.Deliver(ctx) {
+				return
+			}
+		case err := <-watchdog.PipelineDone():
+			if watchdog.FinishPipeline(ctx, err) {
+				return
 			}
-		}(&initialHook)
+		}
+	}
+}
+
+// newCDNClient creates the client for the download flags. An empty
+// bundleCacheDir or "none" disables the bundle cache, "default" uses the
+// user cache directory.
+func newCDNClient(concurrency int, retries int, timeout time.Duration, bandwidthLimit string, bundleCacheDir string) (*doduda.Client, error) {
+	bandwidthRate, err := doduda.ParseByteRate(bandwidthLimit)
+	if err != nil {
+		return nil, newError(KindUsage, "bandwidth-limit", err)
+	}
 
-		fmt.Println(ui.DotStyle.Render("Watchdog started 🐶"))
-		<-watchdogEnd
-		ticker.Stop()
+	if bundleCacheDir == "none" {
+		bundleCacheDir = ""
+	} else if bundleCacheDir == "default" {
+		bundleCacheDir, err = doduda.DefaultBundleCacheDir()
+		if err != nil {
+			return nil, newError(KindIO, "bundle cache", err)
+		}
 	}
+
+	return doduda.NewClient(doduda.Options{
+		BundleCacheDir: bundleCacheDir,
+		Concurrency:    concurrency,
+		Retries:        retries,
+		Timeout:        timeout,
+		BandwidthLimit: bandwidthRate,
+	})
 }
 
 func rootCommand(ccmd *cobra.Command, args []string) {
@@ -382,6 +854,16 @@ func rootCommand(ccmd *cobra.Command, args []string) {
 		return
 	}
 
+	printRules, err := ccmd.Flags().GetBool("print-rules")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if printRules {
+		os.Stdout.Write(doduda.DefaultSelectionRulesYAML())
+		return
+	}
+
 	gameRelease, err := ccmd.Flags().GetString("release")
 	if err != nil {
 		log.Fatal(err)
@@ -397,10 +879,37 @@ func rootCommand(ccmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 
-	/*incremental, err := ccmd.Flags().GetBool("incremental")
+	incremental, err := ccmd.Flags().GetBool("incremental")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	maps, err := ccmd.Flags().GetBool("maps")
 	if err != nil {
 		log.Fatal(err)
-	}*/
+	}
+
+	dryRun, err := ccmd.Flags().GetBool("dry-run")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	asJson, err := ccmd.Flags().GetBool("json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rulesPath, err := ccmd.Flags().GetString("rules")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if rulesPath != "" {
+		selection, err = doduda.LoadSelectionRules(parseWd(rulesPath))
+		if err != nil {
+			exitWithError(err)
+		}
+	}
 
 	platform, err := ccmd.Flags().GetString("platform")
 	if err != nil {
@@ -412,13 +921,14 @@ func rootCommand(ccmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 
-	if platform == "macos" {
-		platform = "darwin"
+	platforms, err := parsePlatforms(splitList(platform))
+	if err != nil {
+		exitWithError(err)
 	}
 
-	supportedPlatforms := []string{"windows", "darwin", "linux"}
-	if !contains(supportedPlatforms, platform) {
-		log.Fatalf("Platform %s is not supported", platform)
+	releases := splitList(gameRelease)
+	if len(releases) == 0 {
+		exitWithError(newError(KindUsage, "release", fmt.Errorf("no release given")))
 	}
 
 	indent, err := ccmd.Flags().GetBool("indent")
@@ -453,14 +963,57 @@ func rootCommand(ccmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 
+	concurrency, err := ccmd.Flags().GetInt("concurrency")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	retries, err := ccmd.Flags().GetInt("retries")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	timeout, err := ccmd.Flags().GetDuration("timeout")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bandwidthLimit, err := ccmd.Flags().GetString("bandwidth-limit")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bundleCacheDir, err := ccmd.Flags().GetString("bundle-cache")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cdn, err = newCDNClient(concurrency, retries, timeout, bandwidthLimit, bundleCacheDir)
+	if err != nil {
+		exitWithError(err)
+	}
+
 	var indentation string
 	if indent {
 		indentation = "  "
 	} else {
 		indentation = ""
 	}
-	err = Download(gameRelease, version, dir, clean, fullGame, platform, int(bin), manifest, ignore, indentation, headless)
-	if err != nil {
-		log.Fatal(err.Error())
+	err = DownloadMatrix(ccmd.Context(), releases, platforms, dir, DownloadParams{
+		Version:     version,
+		CacheIgnore: clean,
+		Manifest:    manifest,
+		Full:        fullGame,
+		Incremental: incremental,
+		Bin:         int(bin),
+		Ignore:      ignore,
+		Maps:        maps,
+		Indent:      indentation,
+		Headless:    headless,
+		DryRun:      dryRun,
+		PlanJSON:    asJson,
+	}, nil)
+	if err != nil {
+		exitWithError(err)
 	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/dofusdude/ankabuffer"
+	"github.com/dofusdude/doduda/pkg/doduda"
+	"github.com/dofusdude/doduda/ui"
+)
+
+type FileChange struct {
+	Name      string `json:"name"`
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+	SizeDelta int64  `json:"size_delta"`
+}
+
+type FragmentDiff struct {
+	Fragment  string       `json:"fragment"`
+	Added     []FileChange `json:"added"`
+	Removed   []FileChange `json:"removed"`
+	Modified  []FileChange `json:"modified"`
+	SizeDelta int64        `json:"size_delta"`
+}
+
+type ManifestDiff struct {
+	OldVersion string         `json:"old_version"`
+	NewVersion string         `json:"new_version"`
+	Fragments  []FragmentDiff `json:"fragments"`
+}
+
+// LoadManifest reads a cached manifest.json if source is an existing file,
+// otherwise source is treated as a game version and fetched from cytrus.
+func LoadManifest(ctx context.Context, source string, release string, platform string) (*ankabuffer.Manifest, error) {
+	if _, err := os.Stat(source); err == nil {
+		raw, err := os.ReadFile(source)
+		if err != nil {
+			return nil, newError(KindIO, "manifest", err)
+		}
+
+		var manifest ankabuffer.Manifest
+		err = json.Unmarshal(raw, &manifest)
+		if err != nil {
+			return nil, newError(KindManifest, "manifest", fmt.Errorf("could not parse manifest %s: %w", source, err))
+		}
+		return &manifest, nil
+	}
+
+	return cdn.FetchManifest(ctx, doduda.ManifestOptions{Release: release, Platform: platform, Version: source})
+}
+
+func DiffManifests(oldManifest *ankabuffer.Manifest, newManifest *ankabuffer.Manifest) ManifestDiff {
+	diff := ManifestDiff{
+		OldVersion: oldManifest.GameVersion,
+		NewVersion: newManifest.GameVersion,
+		Fragments:  []FragmentDiff{},
+	}
+
+	fragmentNames := map[string]bool{}
+	for name := range oldManifest.Fragments {
+		fragmentNames[name] = true
+	}
+	for name := range newManifest.Fragments {
+		fragmentNames[name] = true
+	}
+
+	for name := range fragmentNames {
+		oldFiles := oldManifest.Fragments[name].Files
+		newFiles := newManifest.Fragments[name].Files
+
+		fragmentDiff := FragmentDiff{
+			Fragment: name,
+			Added:    []FileChange{},
+			Removed:  []FileChange{},
+			Modified: []FileChange{},
+		}
+
+		for fileName, newFile := range newFiles {
+			if newFile.Name == "" {
+				continue
+			}
+
+			oldFile, ok := oldFiles[fileName]
+			if !ok || oldFile.Name == "" {
+				fragmentDiff.Added = append(fragmentDiff.Added, FileChange{Name: fileName, NewSize: newFile.Size, SizeDelta: newFile.Size})
+				fragmentDiff.SizeDelta += newFile.Size
+			} else if oldFile.Hash != newFile.Hash {
+				delta := newFile.Size - oldFile.Size
+				fragmentDiff.Modified = append(fragmentDiff.Modified, FileChange{Name: fileName, OldSize: oldFile.Size, NewSize: newFile.Size, SizeDelta: delta})
+				fragmentDiff.SizeDelta += delta
+			}
+		}
+
+		for fileName, oldFile := range oldFiles {
+			if oldFile.Name == "" {
+				continue
+			}
+
+			if newFile, ok := newFiles[fileName]; !ok || newFile.Name == "" {
+				fragmentDiff.Removed = append(fragmentDiff.Removed, FileChange{Name: fileName, OldSize: oldFile.Size, SizeDelta: -oldFile.Size})
+				fragmentDiff.SizeDelta -= oldFile.Size
+			}
+		}
+
+		if len(fragmentDiff.Added)+len(fragmentDiff.Removed)+len(fragmentDiff.Modified) == 0 {
+			continue
+		}
+
+		for _, changes := range [][]FileChange{fragmentDiff.Added, fragmentDiff.Removed, fragmentDiff.Modified} {
+			sort.Slice(changes, func(i, j int) bool {
+				return changes[i].Name < changes[j].Name
+			})
+		}
+
+		diff.Fragments = append(diff.Fragments, fragmentDiff)
+	}
+
+	sort.Slice(diff.Fragments, func(i, j int) bool {
+		return diff.Fragments[i].Fragment < diff.Fragments[j].Fragment
+	})
+
+	return diff
+}
+
+func formatSizeDelta(delta int64) string {
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+	return sign + humanFileSize(float64(delta), false, 1)
+}
+
+func (diff ManifestDiff) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%s -> %s\n", diff.OldVersion, diff.NewVersion)
+	if len(diff.Fragments) == 0 {
+		fmt.Fprintln(w, ui.HelpStyle("no changes"))
+		return
+	}
+
+	for _, fragment := range diff.Fragments {
+		fmt.Fprintf(w, "\n%s %s\n", ui.TitleStyle.Render(fragment.Fragment), ui.HelpStyle(fmt.Sprintf("%d added, %d removed, %d modified, %s", len(fragment.Added), len(fragment.Removed), len(fragment.Modified), formatSizeDelta(fragment.SizeDelta))))
+		for _, file := range fragment.Added {
+			fmt.Fprintf(w, "  + %s (%s)\n", file.Name, humanFileSize(float64(file.NewSize), false, 1))
+		}
+		for _, file := range fragment.Removed {
+			fmt.Fprintf(w, "  - %s (%s)\n", file.Name, humanFileSize(float64(file.OldSize), false, 1))
+		}
+		for _, file := range fragment.Modified {
+			fmt.Fprintf(w, "  ~ %s (%s)\n", file.Name, formatSizeDelta(file.SizeDelta))
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/ankabuffer"
+	"github.com/dofusdude/doduda/pkg/doduda"
+	"github.com/dofusdude/doduda/ui"
+	"github.com/dofusdude/doduda/unpack"
+)
+
+// mapSource is a map file on disk or in a D2P archive.
+type mapSource struct {
+	name string
+	read func() ([]byte, error)
+}
+
+// collectMapSources finds the .dlm files in inPath, loose or inside .d2p
+// archives. The archives stay open until close is called. Linked archives are
+// not followed since they are found by the walk themselves.
+func collectMapSources(inPath string) (sources []mapSource, close func(), err error) {
+	var archives []*os.File
+	close = func() {
+		for _, f := range archives {
+			f.Close()
+		}
+	}
+
+	err = filepath.Walk(inPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".dlm":
+			sources = append(sources, mapSource{
+				name: path,
+				read: func() ([]byte, error) { return os.ReadFile(path) },
+			})
+		case ".d2p":
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			archive, err := unpack.NewD2P(f)
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("%s: %w", filepath.Base(path), err)
+			}
+			archives = append(archives, f)
+			for _, entry := range archive.Entries() {
+				if strings.ToLower(filepath.Ext(entry.Name)) != ".dlm" {
+					continue
+				}
+				entry := entry
+				sources = append(sources, mapSource{
+					name: filepath.Base(path) + ":" + entry.Name,
+					read: entry.Read,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		close()
+		return nil, func() {}, err
+	}
+
+	return sources, close, nil
+}
+
+// ExportMaps decodes the Dofus 2 maps in inPath and writes each one to
+// outPath/<map id>.json.
+func ExportMaps(ctx context.Context, inPath string, outPath string, key string, indent string, headless bool) error {
+	sources, closeSources, err := collectMapSources(inPath)
+	if err != nil {
+		return newError(KindUnpack, "maps", err)
+	}
+	defer closeSources()
+
+	if len(sources) == 0 {
+		return newError(KindUnpack, "maps", fmt.Errorf("no .dlm or .d2p files in %s", inPath))
+	}
+
+	if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
+		return newError(KindIO, "maps", err)
+	}
+
+	updateProgress := make(chan bool, len(sources))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ui.Progress("Export maps", len(sources), updateProgress, 0, true, headless)
+	}()
+
+	for _, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return newError(KindCanceled, "maps", context.Cause(ctx))
+		}
+
+		data, err := source.read()
+		if err != nil {
+			return newError(KindIO, source.name, err)
+		}
+
+		dlm, err := unpack.DecodeDLM(data, key)
+		if err != nil {
+			return newError(KindUnpack, source.name, err)
+		}
+
+		err = marshalSave(dlm, filepath.Join(outPath, fmt.Sprintf("%d.json", dlm.ID)), indent)
+		if err != nil {
+			return err
+		}
+
+		if isChannelClosed(updateProgress) {
+			return errCanceledByUser
+		}
+		updateProgress <- true
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// DownloadMaps downloads the map bundles found in the manifest and decodes
+// them into dir/maps/<map id>.json. For Dofus 3 the worldmaps are written as
+// XYZ tiles to dir/worldmaps.
+func DownloadMaps(ctx context.Context, client *doduda.Client, rules *doduda.SelectionRules, hashJson *ankabuffer.Manifest, bin int, version int, dir string, indent string, headless bool, state *IncrementalState) error {
+	inPath := filepath.Join(dir, "tmp", "maps")
+	mapsPath := filepath.Join(dir, "maps")
+
+	if version == 2 {
+		fileNames, err := selectFiles(rules, hashJson, "maps", version, dir)
+		if err != nil {
+			return err
+		}
+
+		if err := downloadSelection(ctx, client, hashJson, fileNames, doduda.DownloadOptions{Title: "Maps", DestDir: inPath, BinSize: bin, State: state}, headless); err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(inPath); os.IsNotExist(err) {
+			return nil // no map archives or all unchanged in incremental mode
+		}
+
+		return ExportMaps(ctx, inPath, mapsPath, "", indent, headless)
+	}
+
+	stages := []struct {
+		title   string
+		group   string
+		extract func(file string) error
+	}{
+		{"Map Data", "mapdata", func(file string) error {
+			_, err := client.ExtractMapData(ctx, file, mapsPath, indent)
+			return err
+		}},
+		{"Worldmaps", "worldmaps", func(file string) error {
+			return client.ExtractWorldmaps(ctx, file, filepath.Join(dir, "worldmaps"), doduda.DefaultTileSize)
+		}},
+	}
+
+	for _, stage := range stages {
+		fileNames, err := selectFiles(rules, hashJson, stage.group, version, dir)
+		if err != nil {
+			return err
+		}
+		if len(fileNames) == 0 {
+			log.Warn("No bundles in the manifest", "stage", stage.title, "group", stage.group)
+			continue
+		}
+
+		stageDir := filepath.Join(inPath, stage.group)
+		if err := downloadSelection(ctx, client, hashJson, fileNames, doduda.DownloadOptions{Title: stage.title, DestDir: stageDir, BinSize: bin, State: state}, headless); err != nil {
+			return err
+		}
+
+		bundles, err := filepath.Glob(filepath.Join(stageDir, "*.bundle"))
+		if err != nil {
+			return newError(KindIO, stage.title, err)
+		}
+		if len(bundles) == 0 {
+			continue // all unchanged in incremental mode
+		}
+
+		if err := extractWithProgress(stage.title, bundles, stage.extract, headless); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractWithProgress calls extract for every file and shows a progress bar.
+func extractWithProgress(title string, files []string, extract func(file string) error, headless bool) error {
+	updateProgress := make(chan bool, len(files))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ui.Progress("Extract "+title, len(files), updateProgress, 0, true, headless)
+	}()
+
+	for _, file := range files {
+		if err := extract(file); err != nil {
+			return err
+		}
+		if isChannelClosed(updateProgress) {
+			return errCanceledByUser
+		}
+		updateProgress <- true
+	}
+
+	wg.Wait()
+	return nil
+}
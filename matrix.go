@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/ankabuffer"
+)
+
+const platformDiffFileName = "platform-diff.json"
+
+// downloadTarget is one release and platform of a matrix download.
+type downloadTarget struct {
+	release  string
+	platform string
+	dir      string
+}
+
+// downloadTargets returns every combination of the releases and platforms.
+// With more than one each target gets <output>/<release>/<platform>.
+func downloadTargets(releases []string, platforms []string, output string) []downloadTarget {
+	var targets []downloadTarget
+	for _, release := range releases {
+		for _, platform := range platforms {
+			dir := output
+			if len(releases)*len(platforms) > 1 {
+				dir = filepath.Join(output, release, platform)
+			}
+			targets = append(targets, downloadTarget{release: release, platform: platform, dir: dir})
+		}
+	}
+	return targets
+}
+
+// splitList splits comma separated flag values like "windows,linux" and
+// removes duplicates.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parsePlatforms checks the platforms and replaces macos with darwin.
+func parsePlatforms(platforms []string) ([]string, error) {
+	var parsed []string
+	for _, platform := range platforms {
+		if platform == "macos" {
+			platform = "darwin"
+		}
+		if !contains([]string{"windows", "darwin", "linux"}, platform) {
+			return nil, newError(KindUsage, "platform", fmt.Errorf("platform %s is not supported", platform))
+		}
+		if !contains(parsed, platform) {
+			parsed = append(parsed, platform)
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, newError(KindUsage, "platform", fmt.Errorf("no platform given"))
+	}
+	return parsed, nil
+}
+
+// DownloadMatrix runs Download for every release and platform, p.Release,
+// p.Platform and p.Dir are set for each target. Bundles are shared between the
+// targets through the bundle cache, a temporary one in <output>/tmp-bundles is
+// used if the cache is disabled. after is called for each finished target if
+// it is not nil. For releases with several platforms the files that differ
+// between them are written to <output>/<release>/platform-diff.json.
+func DownloadMatrix(ctx context.Context, releases []string, platforms []string, output string, p DownloadParams, after func(target downloadTarget) error) error {
+	targets := downloadTargets(releases, platforms, output)
+	matrix := len(targets) > 1
+	if matrix && p.Manifest != "" {
+		return newError(KindUsage, "manifest", fmt.Errorf("a manifest file can only be used with one release and platform"))
+	}
+
+	if matrix && !p.DryRun && !p.client().HasBundleCache() {
+		bundlesDir := filepath.Join(output, "tmp-bundles")
+		defer os.RemoveAll(bundlesDir)
+
+		sharedClient, err := p.client().WithBundleCache(bundlesDir)
+		if err != nil {
+			return err
+		}
+		p.Client = sharedClient
+	}
+
+	manifestPaths := make(map[downloadTarget]string)
+	for _, target := range targets {
+		if matrix {
+			log.Info("Downloading", "release", target.release, "platform", target.platform, "dir", target.dir)
+		}
+
+		if err := os.MkdirAll(target.dir, os.ModePerm); err != nil {
+			return newError(KindIO, "output", err)
+		}
+
+		targetParams := p
+		targetParams.Release = target.release
+		targetParams.Platform = target.platform
+		targetParams.Dir = target.dir
+		if matrix {
+			targetParams.ManifestPath = filepath.Join(target.dir, "manifest.json")
+		}
+		manifestPaths[target] = targetParams.ManifestPath
+
+		if err := Download(ctx, targetParams); err != nil {
+			return err
+		}
+
+		if after != nil {
+			if err := after(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !matrix || p.DryRun || len(platforms) < 2 {
+		return nil
+	}
+
+	for _, release := range releases {
+		manifests := make(map[string]*ankabuffer.Manifest)
+		for _, target := range targets {
+			if target.release != release {
+				continue
+			}
+			manifest, err := LoadManifest(ctx, manifestPaths[target], target.release, target.platform)
+			if err != nil {
+				return err
+			}
+			manifests[target.platform] = manifest
+		}
+
+		diff := DiffPlatforms(release, manifests)
+		log.Info("Platform differences", "release", release, "files", len(diff.Files))
+		if err := marshalSave(diff, filepath.Join(output, release, platformDiffFileName), "  "); err != nil {
+			return newError(KindIO, platformDiffFileName, err)
+		}
+	}
+
+	return nil
+}
+
+// PlatformFileDiff is a file that is missing on some platforms or differs
+// between them. Platforms without the file have no hash.
+type PlatformFileDiff struct {
+	Name   string            `json:"name"`
+	Hashes map[string]string `json:"hashes"` // platform -> hash
+	Sizes  map[string]int64  `json:"sizes"`  // platform -> size
+}
+
+// PlatformDiff lists the files of a release that are not the same on all
+// platforms.
+type PlatformDiff struct {
+	Release      string             `json:"release"`
+	GameVersions map[string]string  `json:"game_versions"` // platform -> version
+	Files        []PlatformFileDiff `json:"files"`
+}
+
+// DiffPlatforms compares the manifests of a release by platform. Files are
+// compared by their path over all fragments, since the fragments are named
+// differently on each platform.
+func DiffPlatforms(release string, manifests map[string]*ankabuffer.Manifest) PlatformDiff {
+	diff := PlatformDiff{
+		Release:      release,
+		GameVersions: make(map[string]string),
+		Files:        []PlatformFileDiff{},
+	}
+
+	files := make(map[string]*PlatformFileDiff)
+	for platform, manifest := range manifests {
+		diff.GameVersions[platform] = manifest.GameVersion
+		for _, fragment := range manifest.Fragments {
+			for _, file := range fragment.Files {
+				if file.Name == "" {
+					continue
+				}
+				fileDiff, ok := files[file.Name]
+				if !ok {
+					fileDiff = &PlatformFileDiff{Name: file.Name, Hashes: make(map[string]string), Sizes: make(map[string]int64)}
+					files[file.Name] = fileDiff
+				}
+				fileDiff.Hashes[platform] = file.Hash
+				fileDiff.Sizes[platform] = file.Size
+			}
+		}
+	}
+
+	for _, fileDiff := range files {
+		same := len(fileDiff.Hashes) == len(manifests)
+		for _, hash := range fileDiff.Hashes {
+			for _, other := range fileDiff.Hashes {
+				same = same && hash == other
+			}
+		}
+		if !same {
+			diff.Files = append(diff.Files, *fileDiff)
+		}
+	}
+
+	sort.Slice(diff.Files, func(i, j int) bool {
+		return diff.Files[i].Name < diff.Files[j].Name
+	})
+
+	return diff
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The metrics are written in the Prometheus text exposition format. The few
+// types the watchdog needs are implemented here instead of pulling in the
+// Prometheus client. They are not safe for concurrent use, the Monitor
+// guards them.
+
+// labels renders label pairs like {release="main",platform="windows"}.
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+"="+strconv.Quote(pairs[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// counterVec is a counter with labels. Label sets are only written once they
+// were incremented.
+type counterVec struct {
+	name   string
+	help   string
+	values map[string]float64 // rendered labels -> value
+}
+
+func newCounterVec(name string, help string) *counterVec {
+	return &counterVec{name: name, help: help, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Inc(labelPairs ...string) {
+	c.values[labels(labelPairs...)]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64 // upper bounds, ascending
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) Observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels("le", formatFloat(bound)), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels("le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// writeGauge writes a gauge without labels.
+func writeGauge(w io.Writer, name string, help string, value float64) {
+	writeHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+// writeGaugeVec writes a gauge with one sample per label set.
+func writeGaugeVec(w io.Writer, name string, help string, values map[string]float64) {
+	writeHeader(w, name, help, "gauge")
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, key, formatFloat(values[key]))
+	}
+}
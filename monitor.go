@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// HookResult is the last delivery attempt of a notification.
+type HookResult struct {
+	Time      time.Time `json:"time"`
+	Notifier  string    `json:"notifier"`
+	ID        string    `json:"id"`
+	Release   string    `json:"release"`
+	Platform  string    `json:"platform"`
+	Pipeline  string    `json:"pipeline,omitempty"`
+	Attempt   int       `json:"attempt"`
+	Delivered bool      `json:"delivered"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// WatchdogStatus is the state of the watchdog served on /status.
+type WatchdogStatus struct {
+	Started              time.Time                    `json:"started"`
+	Interval             string                       `json:"interval"`
+	LastCheck            *time.Time                   `json:"last_check"`
+	LastCheckError       string                       `json:"last_check_error,omitempty"`
+	ConsecutiveErrors    int                          `json:"consecutive_errors"`
+	Versions             map[string]map[string]string `json:"versions"` // release -> platform -> last seen version
+	LastHook             *HookResult                  `json:"last_hook"`
+	PendingNotifications int                          `json:"pending_notifications"`
+	Pipeline             *VersionEvent                `json:"pipeline,omitempty"` // the running pipeline
+}
+
+// Monitor records what the watchdog does for the /healthz, /status and
+// /metrics endpoints. The watchdog calls it from its loop while the HTTP
+// server reads it, so every access is locked. A nil Monitor records nothing.
+type Monitor struct {
+	mu       sync.Mutex
+	interval time.Duration
+	status   WatchdogStatus
+
+	checks            *counterVec
+	cytrusLatency     *histogram
+	changes           *counterVec
+	notifications     *counterVec
+	hookFailures      *counterVec
+	pipelines         *counterVec
+	pipelineDurations *histogram
+}
+
+// NewMonitor creates the monitor of a watchdog that checks every interval.
+func NewMonitor(interval time.Duration) *Monitor {
+	return &Monitor{
+		interval: interval,
+		status: WatchdogStatus{
+			Started:  time.Now(),
+			Interval: interval.String(),
+			Versions: make(map[string]map[string]string),
+		},
+		checks:            newCounterVec("doduda_watchdog_checks_total", "Version checks by result."),
+		cytrusLatency:     newHistogram("doduda_watchdog_cytrus_request_duration_seconds", "Duration of the cytrus.json requests.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}),
+		changes:           newCounterVec("doduda_watchdog_changes_total", "Detected version changes by release and platform."),
+		notifications:     newCounterVec("doduda_watchdog_notifications_total", "Delivered notifications by notifier."),
+		hookFailures:      newCounterVec("doduda_watchdog_hook_failures_total", "Failed notification attempts by notifier."),
+		pipelines:         newCounterVec("doduda_watchdog_pipelines_total", "Finished pipeline runs by result."),
+		pipelineDurations: newHistogram("doduda_watchdog_pipeline_duration_seconds", "Duration of the pipeline runs.", []float64{60, 300, 600, 1800, 3600, 7200}),
+	}
+}
+
+// CytrusRequest records the duration of a cytrus.json request.
+func (m *Monitor) CytrusRequest(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cytrusLatency.Observe(duration.Seconds())
+}
+
+// Checked records the end of a version check.
+func (m *Monitor) Checked(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.status.LastCheck = &now
+	if err != nil {
+		m.status.LastCheckError = err.Error()
+		m.status.ConsecutiveErrors++
+		m.checks.Inc("result", "error")
+		return
+	}
+
+	m.status.LastCheckError = ""
+	m.status.ConsecutiveErrors = 0
+	m.checks.Inc("result", "ok")
+}
+
+// Seen records the current version of a release on a platform.
+func (m *Monitor) Seen(release string, platform string, version string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.status.Versions[release] == nil {
+		m.status.Versions[release] = make(map[string]string)
+	}
+	m.status.Versions[release][platform] = version
+}
+
+// Changed records a detected version change.
+func (m *Monitor) Changed(event VersionEvent) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.changes.Inc("release", event.Release, "platform", event.Platform)
+}
+
+// Attempt records a delivery attempt of the outbox, see Outbox.OnAttempt.
+func (m *Monitor) Attempt(notification Notification, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := &HookResult{
+		Time:      time.Now(),
+		Notifier:  notification.Notifier,
+		ID:        notification.ID,
+		Release:   notification.Event.Release,
+		Platform:  notification.Event.Platform,
+		Pipeline:  notification.Event.Pipeline,
+		Attempt:   notification.Attempts,
+		Delivered: err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		m.hookFailures.Inc("notifier", notification.Notifier)
+	} else {
+		m.notifications.Inc("notifier", notification.Notifier)
+	}
+	m.status.LastHook = result
+}
+
+// Pending records the number of notifications in the outbox.
+func (m *Monitor) Pending(count int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.PendingNotifications = count
+}
+
+// PipelineStarted records that the pipeline runs for event.
+func (m *Monitor) PipelineStarted(event VersionEvent) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.Pipeline = &event
+}
+
+// PipelineFinished records the end of the running pipeline.
+func (m *Monitor) PipelineFinished(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	m.status.Pipeline = nil
+	m.pipelines.Inc("result", result)
+	m.pipelineDurations.Observe(duration.Seconds())
+}
+
+// Status returns a copy of the current status.
+func (m *Monitor) Status() WatchdogStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.status
+	status.Versions = make(map[string]map[string]string, len(m.status.Versions))
+	for release, platforms := range m.status.Versions {
+		status.Versions[release] = make(map[string]string, len(platforms))
+		for platform, version := range platforms {
+			status.Versions[release][platform] = version
+		}
+	}
+	return status
+}
+
+// Healthy reports whether the watchdog loop is alive: a check finished within
+// two intervals and a minute, or a pipeline is running.
+func (m *Monitor) Healthy() (bool, string) {
+	status := m.Status()
+	if status.Pipeline != nil {
+		return true, "running pipeline"
+	}
+
+	last := status.Started
+	if status.LastCheck != nil {
+		last = *status.LastCheck
+	}
+
+	if since := time.Since(last); since > 2*m.interval+time.Minute {
+		return false, fmt.Sprintf("no check for %s", since.Round(time.Second))
+	}
+	return true, "ok"
+}
+
+// Handler serves /healthz, /status and /metrics.
+func (m *Monitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		healthy, reason := m.Healthy()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, reason)
+	})
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(m.Status())
+	})
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.writeMetrics(w)
+	})
+
+	return mux
+}
+
+// Listen binds addr and serves the Handler in the background until ctx is
+// done.
+func (m *Monitor) Listen(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: m.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("HTTP listener stopped", "err", err)
+		}
+	}()
+
+	log.Info("Serving /healthz, /status and /metrics", "addr", listener.Addr())
+	return nil
+}
+
+func (m *Monitor) writeMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checks.write(w)
+	m.cytrusLatency.write(w)
+	m.changes.write(w)
+	m.notifications.write(w)
+	m.hookFailures.write(w)
+	m.pipelines.write(w)
+	m.pipelineDurations.write(w)
+
+	var lastCheck float64
+	if m.status.LastCheck != nil {
+		lastCheck = float64(m.status.LastCheck.UnixMilli()) / 1000
+	}
+	writeGauge(w, "doduda_watchdog_last_check_timestamp_seconds", "Unix time of the last version check, 0 before the first.", lastCheck)
+	writeGauge(w, "doduda_watchdog_consecutive_errors", "Version checks that failed in a row.", float64(m.status.ConsecutiveErrors))
+	writeGauge(w, "doduda_watchdog_pending_notifications", "Notifications in the outbox that are not delivered yet.", float64(m.status.PendingNotifications))
+
+	versions := make(map[string]float64)
+	for release, platforms := range m.status.Versions {
+		for platform, version := range platforms {
+			versions[labels("release", release, "platform", platform, "version", version)] = 1
+		}
+	}
+	writeGaugeVec(w, "doduda_watchdog_version_info", "Last seen version by release and platform, always 1.", versions)
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// notifyTimeout limits a single delivery attempt, so a receiver that hangs
+// cannot block the watchdog. The outbox retries the notification later.
+const notifyTimeout = 30 * time.Second
+
+// notifyClient sends the requests of the notifiers.
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// Notifier sends a version event to a chat platform or webhook. The name
+// routes the notifications of the outbox, so it must be unique.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NotifierConfig is a notifier of a --notifiers file. Which keys are needed
+// depends on the type, values can use ${ENV} variables for secrets.
+type NotifierConfig struct {
+	Type       string   `mapstructure:"type"` // webhook, discord, slack, telegram, matrix or smtp
+	Name       string   `mapstructure:"name"` // used in logs, defaults to the type
+	URL        string   `mapstructure:"url"`  // webhook, discord and slack URL, telegram API or matrix homeserver
+	AuthHeader string   `mapstructure:"auth_header"`
+	Body       string   `mapstructure:"body"`   // custom webhook body file
+	Secret     string   `mapstructure:"secret"` // signs webhook timestamps and bodies in the X-Doduda-Signature header
+	Token      string   `mapstructure:"token"`
+	ChatID     string   `mapstructure:"chat_id"`
+	Room       string   `mapstructure:"room"`
+	Host       string   `mapstructure:"host"`
+	Port       int      `mapstructure:"port"`
+	Username   string   `mapstructure:"username"`
+	Password   string   `mapstructure:"password"`
+	From       string   `mapstructure:"from"`
+	To         []string `mapstructure:"to"`
+}
+
+// LoadNotifiers reads the notifiers of a YAML or JSON file with a list under
+// the key notifiers.
+func LoadNotifiers(file string) ([]Notifier, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, newError(KindUsage, "notifiers", err)
+	}
+
+	var config struct {
+		Notifiers []NotifierConfig `mapstructure:"notifiers"`
+	}
+	if err := v.UnmarshalExact(&config); err != nil {
+		return nil, newError(KindUsage, "notifiers "+filepath.Base(file), err)
+	}
+
+	var notifiers []Notifier
+	for i, notifierConfig := range config.Notifiers {
+		notifier, err := NewNotifier(notifierConfig)
+		if err != nil {
+			return nil, newError(KindUsage, "notifiers "+filepath.Base(file), fmt.Errorf("notifier %d: %w", i+1, err))
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// checkNotifierNames fails if two notifiers have the same name, the outbox
+// could not tell their notifications apart.
+func checkNotifierNames(notifiers []Notifier) error {
+	names := make(map[string]bool, len(notifiers))
+	for _, notifier := range notifiers {
+		if names[notifier.Name()] {
+			return newError(KindUsage, "notifiers", fmt.Errorf("the name %s is used twice, set a unique name", notifier.Name()))
+		}
+		names[notifier.Name()] = true
+	}
+	return nil
+}
+
+// NewNotifier checks the config and creates its notifier. Its errors never
+// contain the URL, token, password or other secrets of the config, since they
+// are saved in the outbox and shown on /status.
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	notifier, err := newNotifier(config)
+	if err != nil {
+		return nil, err
+	}
+	return withRedactedErrors(notifier, config.URL, config.AuthHeader, config.Secret, config.Token, config.Password), nil
+}
+
+func newNotifier(config NotifierConfig) (Notifier, error) {
+	config.URL = os.ExpandEnv(config.URL)
+	config.AuthHeader = os.ExpandEnv(config.AuthHeader)
+	config.Secret = os.ExpandEnv(config.Secret)
+	config.Token = os.ExpandEnv(config.Token)
+	config.ChatID = os.ExpandEnv(config.ChatID)
+	config.Room = os.ExpandEnv(config.Room)
+	config.Host = os.ExpandEnv(config.Host)
+	config.Username = os.ExpandEnv(config.Username)
+	config.Password = os.ExpandEnv(config.Password)
+	config.From = os.ExpandEnv(config.From)
+	for i := range config.To {
+		config.To[i] = os.ExpandEnv(config.To[i])
+	}
+
+	if config.Name == "" {
+		config.Name = config.Type
+	}
+
+	require := func(keys ...string) error {
+		values := map[string]string{"url": config.URL, "token": config.Token, "chat_id": config.ChatID, "room": config.Room, "host": config.Host, "from": config.From}
+		for _, key := range keys {
+			if values[key] == "" {
+				return fmt.Errorf("%s needs %s", config.Type, key)
+			}
+		}
+		return nil
+	}
+
+	switch config.Type {
+	case "webhook":
+		if err := require("url"); err != nil {
+			return nil, err
+		}
+		return &webhookNotifier{name: config.Name, url: config.URL, authHeader: config.AuthHeader, bodyPath: config.Body, secret: config.Secret}, nil
+	case "discord":
+		if err := require("url"); err != nil {
+			return nil, err
+		}
+		return &discordNotifier{name: config.Name, url: config.URL}, nil
+	case "slack":
+		if err := require("url"); err != nil {
+			return nil, err
+		}
+		return &slackNotifier{name: config.Name, url: config.URL}, nil
+	case "telegram":
+		if err := require("token", "chat_id"); err != nil {
+			return nil, err
+		}
+		if config.URL == "" {
+			config.URL = "https://api.telegram.org"
+		}
+		return &telegramNotifier{name: config.Name, api: config.URL, token: config.Token, chatID: config.ChatID}, nil
+	case "matrix":
+		if err := require("url", "token", "room"); err != nil {
+			return nil, err
+		}
+		return &matrixNotifier{name: config.Name, homeserver: config.URL, token: config.Token, room: config.Room}, nil
+	case "smtp":
+		if err := require("host", "from"); err != nil {
+			return nil, err
+		}
+		if len(config.To) == 0 {
+			return nil, fmt.Errorf("smtp needs to")
+		}
+		if config.Port == 0 {
+			config.Port = 587
+		}
+		return &smtpNotifier{name: config.Name, addr: net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), host: config.Host, username: config.Username, password: config.Password, from: config.From, to: config.To}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", config.Type)
+	}
+}
+
+// redactingNotifier replaces the secrets in the errors of a notifier.
+type redactingNotifier struct {
+	Notifier
+	secrets []string
+}
+
+// withRedactedErrors wraps notifier so its errors do not contain the secrets.
+// Environment variables in the secrets are expanded like in the config.
+func withRedactedErrors(notifier Notifier, secrets ...string) Notifier {
+	redacting := &redactingNotifier{Notifier: notifier}
+	for _, secret := range secrets {
+		secret = os.ExpandEnv(secret)
+		if secret == "" {
+			continue
+		}
+		redacting.secrets = append(redacting.secrets, secret)
+		// the credentials of "Bearer <token>" may also appear on their own
+		if fields := strings.Fields(secret); len(fields) > 1 {
+			redacting.secrets = append(redacting.secrets, fields[len(fields)-1])
+		}
+	}
+
+	// longer secrets first, so a token in a URL does not leave the rest of it
+	sort.Slice(redacting.secrets, func(i, j int) bool {
+		return len(redacting.secrets[i]) > len(redacting.secrets[j])
+	})
+	return redacting
+}
+
+func (n *redactingNotifier) Notify(ctx context.Context, notification Notification) error {
+	err := n.Notifier.Notify(ctx, notification)
+	if err == nil {
+		return nil
+	}
+	return errors.New(redact(err.Error(), n.secrets))
+}
+
+// redact replaces every secret in text.
+func redact(text string, secrets []string) string {
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, "[redacted]")
+	}
+	return text
+}
+
+// postJSON sends payload as JSON and fails on responses other than 2xx.
+func postJSON(ctx context.Context, method string, url string, payload any, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return sendRequest(req)
+}
+
+// sendRequest sends req and fails on responses other than 2xx. Errors only
+// name the host of the request, the path and query can hold tokens.
+func sendRequest(req *http.Request) error {
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		answer, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded with %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
+
+// webhookNotifier posts the JSON of the event or a custom body, like the
+// --hook flag. The notification ID is sent as Idempotency-Key.
+type webhookNotifier struct {
+	name       string
+	url        string
+	authHeader string
+	bodyPath   string // with ${release}, ${platform}, ${oldVersion}, ${newVersion}, ${pipeline}, ${stage} and ${error}
+	secret     string // signs the body if not empty
+}
+
+func (n *webhookNotifier) Name() string { return n.name }
+
+func (n *webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	event := notification.Event
+	var isJson bool
+	var body []byte
+	var err error
+	if n.bodyPath == "" {
+		isJson = true
+		jsonBody := map[string]string{
+			"message":     event.Message(),
+			"old_version": event.OldVersion,
+			"new_version": event.NewVersion,
+			"release":     event.Release,
+			"platform":    event.Platform,
+		}
+		if event.Pipeline != "" {
+			jsonBody["pipeline"] = event.Pipeline
+		}
+		if event.Stage != "" {
+			jsonBody["stage"] = event.Stage
+			jsonBody["error"] = event.Error
+		}
+
+		body, err = json.Marshal(jsonBody)
+		if err != nil {
+			return err
+		}
+	} else {
+		if filepath.Ext(n.bodyPath) == ".json" {
+			isJson = true
+		}
+
+		body, err = os.ReadFile(n.bodyPath)
+		if err != nil {
+			return err
+		}
+
+		body = []byte(os.Expand(string(body), func(key string) string {
+			switch key {
+			case "oldVersion":
+				return event.OldVersion
+			case "newVersion":
+				return event.NewVersion
+			case "release":
+				return event.Release
+			case "platform":
+				return event.Platform
+			case "pipeline":
+				return event.Pipeline
+			case "stage":
+				return event.Stage
+			case "error":
+				return event.Error
+			default:
+				return ""
+			}
+		}))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	if isJson {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+
+	if n.authHeader != "" {
+		req.Header.Set("Authorization", n.authHeader)
+	}
+
+	req.Header.Set("Idempotency-Key", notification.ID)
+	if n.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, signBody(n.secret, timestamp, body))
+	}
+
+	return sendRequest(req)
+}
+
+// eventTitle is the short title of an event for chat messages.
+func eventTitle(event VersionEvent) string {
+	return fmt.Sprintf("Dofus %s %s", event.Release, event.NewVersion)
+}
+
+// eventDetails are the labeled values of an event for chat messages.
+func eventDetails(event VersionEvent) [][2]string {
+	details := [][2]string{
+		{"Release", event.Release},
+		{"Platform", event.Platform},
+		{"Old version", event.OldVersion},
+		{"New version", event.NewVersion},
+	}
+	if event.Pipeline != "" {
+		details = append(details, [2]string{"Pipeline", event.Pipeline})
+	}
+	if event.Stage != "" {
+		details = append(details, [2]string{"Stage", event.Stage})
+	}
+	return details
+}
+
+// discordNotifier posts an embed to a Discord webhook.
+type discordNotifier struct {
+	name string
+	url  string
+}
+
+func (n *discordNotifier) Name() string { return n.name }
+
+func (n *discordNotifier) Notify(ctx context.Context, notification Notification) error {
+	event := notification.Event
+	var fields []map[string]any
+	for _, detail := range eventDetails(event) {
+		fields = append(fields, map[string]any{"name": detail[0], "value": detail[1], "inline": true})
+	}
+
+	return postJSON(ctx, http.MethodPost, n.url, map[string]any{
+		"username": "doduda",
+		"embeds": []map[string]any{{
+			"title":       eventTitle(event),
+			"description": event.Message(),
+			"color":       0xB24652,
+			"fields":      fields,
+			"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		}},
+	}, nil)
+}
+
+// slackNotifier posts blocks to a Slack incoming webhook.
+type slackNotifier struct {
+	name string
+	url  string
+}
+
+func (n *slackNotifier) Name() string { return n.name }
+
+func (n *slackNotifier) Notify(ctx context.Context, notification Notification) error {
+	event := notification.Event
+	var fields []map[string]string
+	for _, detail := range eventDetails(event) {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": "*" + detail[0] + "*\n" + detail[1]})
+	}
+
+	return postJSON(ctx, http.MethodPost, n.url, map[string]any{
+		"text": event.Message(),
+		"blocks": []map[string]any{
+			{"type": "header", "text": map[string]any{"type": "plain_text", "text": event.Message(), "emoji": true}},
+			{"type": "section", "fields": fields},
+		},
+	}, nil)
+}
+
+// telegramNotifier sends a message with the Telegram Bot API.
+type telegramNotifier struct {
+	name   string
+	api    string
+	token  string
+	chatID string
+}
+
+func (n *telegramNotifier) Name() string { return n.name }
+
+func (n *telegramNotifier) Notify(ctx context.Context, notification Notification) error {
+	event := notification.Event
+	text := fmt.Sprintf("<b>%s</b>\n%s\n<code>%s</code> → <code>%s</code> (%s)",
+		html.EscapeString(eventTitle(event)),
+		html.EscapeString(event.Message()),
+		html.EscapeString(event.OldVersion),
+		html.EscapeString(event.NewVersion),
+		html.EscapeString(event.Platform))
+
+	return postJSON(ctx, http.MethodPost, strings.TrimSuffix(n.api, "/")+"/bot"+n.token+"/sendMessage", map[string]any{
+		"chat_id":    n.chatID,
+		"text":       text,
+		"parse_mode": "HTML",
+	}, nil)
+}
+
+// matrixNotifier sends a notice to a Matrix room with the client-server API.
+type matrixNotifier struct {
+	name       string
+	homeserver string
+	token      string
+	room       string
+}
+
+func (n *matrixNotifier) Name() string { return n.name }
+
+func (n *matrixNotifier) Notify(ctx context.Context, notification Notification) error {
+	event := notification.Event
+	// the homeserver ignores repeated transaction IDs, so redeliveries are not shown twice
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/doduda-%s", strings.TrimSuffix(n.homeserver, "/"), url.PathEscape(n.room), notification.ID)
+
+	return postJSON(ctx, http.MethodPut, endpoint, map[string]string{
+		"msgtype":        "m.notice",
+		"body":           fmt.Sprintf("%s (%s → %s, %s)", event.Message(), event.OldVersion, event.NewVersion, event.Platform),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": fmt.Sprintf("<b>%s</b><br>%s → %s (%s)", html.EscapeString(eventTitle(event)), html.EscapeString(event.OldVersion), html.EscapeString(event.NewVersion), html.EscapeString(event.Platform)),
+	}, map[string]string{"Authorization": "Bearer " + n.token})
+}
+
+// smtpNotifier sends a plain text email. The connection uses STARTTLS if the
+// server offers it.
+type smtpNotifier struct {
+	name     string
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (n *smtpNotifier) Name() string { return n.name }
+
+func (n *smtpNotifier) Notify(ctx context.Context, notification Notification) error {
+	event := notification.Event
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", eventTitle(event))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&message, "%s\r\n\r\n", event.Message())
+	for _, detail := range eventDetails(event) {
+		fmt.Fprintf(&message, "%s: %s\r\n", detail[0], detail[1])
+	}
+
+	return n.send(ctx, message.Bytes())
+}
+
+// send is smtp.SendMail with a deadline of notifyTimeout for the whole
+// conversation. Canceling ctx aborts it as well.
+func (n *smtpNotifier) send(ctx context.Context, message []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+
+	if n.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	outboxFileName = ".doduda-outbox.json"
+
+	// SignatureHeader carries the HMAC-SHA256 of the timestamp, a dot and
+	// the webhook body as "sha256=<hex>" when a secret is configured.
+	SignatureHeader = "X-Doduda-Signature"
+	// TimestampHeader carries the Unix time in seconds of the attempt that
+	// is signed, receivers reject old ones to stop replays.
+	TimestampHeader = "X-Doduda-Timestamp"
+
+	maxRetryDelay = 24 * time.Hour
+)
+
+// Notification is a version event for one notifier that was not delivered
+// yet. The ID stays the same for every attempt, so receivers can drop
+// duplicates with it.
+type Notification struct {
+	ID          string       `json:"id"`
+	Notifier    string       `json:"notifier"`
+	Event       VersionEvent `json:"event"`
+	Created     time.Time    `json:"created"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"next_attempt"`
+	LastError   string       `json:"last_error,omitempty"`
+}
+
+// Outbox keeps the notifications until they are delivered and retries failed
+// ones with exponential backoff. It is persisted to its file after every
+// change, an empty path keeps it in memory.
+type Outbox struct {
+	Pending []*Notification `json:"pending"`
+
+	// OnAttempt is called after every delivery attempt if it is not nil.
+	OnAttempt func(notification Notification, err error) `json:"-"`
+
+	path        string
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// LoadOutbox reads the outbox file. Notifications are dropped after
+// maxAttempts failed attempts, 0 retries forever. The delay after the first
+// failure is backoff and doubles with every attempt up to a day, 0 retries at
+// once.
+func LoadOutbox(path string, maxAttempts int, backoff time.Duration) (*Outbox, error) {
+	outbox := &Outbox{path: path, maxAttempts: maxAttempts, backoff: backoff}
+	if path == "" {
+		return outbox, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return outbox, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, outbox); err != nil {
+		return nil, fmt.Errorf("outbox %s: %w", filepath.Base(path), err)
+	}
+	return outbox, nil
+}
+
+func (o *Outbox) save() error {
+	if o.path == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(o.path, raw)
+}
+
+// Add queues the events for every notifier.
+func (o *Outbox) Add(events []VersionEvent, notifiers []Notifier) error {
+	if len(events) == 0 || len(notifiers) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for _, event := range events {
+		for _, notifier := range notifiers {
+			id := make([]byte, 16)
+			if _, err := rand.Read(id); err != nil {
+				return err
+			}
+
+			o.Pending = append(o.Pending, &Notification{
+				ID:          hex.EncodeToString(id),
+				Notifier:    notifier.Name(),
+				Event:       event,
+				Created:     now,
+				NextAttempt: now,
+			})
+		}
+	}
+	return o.save()
+}
+
+// NextAttempt returns when the next notification is due.
+func (o *Outbox) NextAttempt() (time.Time, bool) {
+	if len(o.Pending) == 0 {
+		return time.Time{}, false
+	}
+
+	next := o.Pending[0].NextAttempt
+	for _, notification := range o.Pending[1:] {
+		if notification.NextAttempt.Before(next) {
+			next = notification.NextAttempt
+		}
+	}
+	return next, true
+}
+
+// Deliver sends the due notifications and reports whether at least one was
+// delivered. Notifications of notifiers that are not configured anymore are
+// dropped.
+func (o *Outbox) Deliver(ctx context.Context, notifiers []Notifier) (bool, error) {
+	byName := make(map[string]Notifier, len(notifiers))
+	for _, notifier := range notifiers {
+		byName[notifier.Name()] = notifier
+	}
+
+	sort.SliceStable(o.Pending, func(i, j int) bool {
+		return o.Pending[i].Created.Before(o.Pending[j].Created)
+	})
+
+	delivered := false
+	pending := []*Notification{}
+	for _, notification := range o.Pending {
+		notifier, ok := byName[notification.Notifier]
+		if !ok {
+			log.Warn("Dropping notification of unknown notifier", "notifier", notification.Notifier, "id", notification.ID)
+			continue
+		}
+
+		if ctx.Err() != nil || time.Now().Before(notification.NextAttempt) {
+			pending = append(pending, notification)
+			continue
+		}
+
+		notification.Attempts++
+		err := notifier.Notify(ctx, *notification)
+		if o.OnAttempt != nil {
+			o.OnAttempt(*notification, err)
+		}
+		if err == nil {
+			log.Info("Notified", "notifier", notification.Notifier, "release", notification.Event.Release, "platform", notification.Event.Platform, "id", notification.ID)
+			delivered = true
+			continue
+		}
+
+		notification.LastError = err.Error()
+		if o.maxAttempts > 0 && notification.Attempts >= o.maxAttempts {
+			log.Error("Giving up notification", "notifier", notification.Notifier, "attempts", notification.Attempts, "id", notification.ID, "err", err)
+			continue
+		}
+
+		delay := o.retryDelay(notification.Attempts)
+		notification.NextAttempt = time.Now().Add(delay)
+		log.Error("Notification failed", "notifier", notification.Notifier, "attempt", notification.Attempts, "retry", delay, "err", err)
+		pending = append(pending, notification)
+	}
+
+	o.Pending = pending
+	return delivered, o.save()
+}
+
+// retryDelay returns the delay after the failed attempt with the given
+// number, the backoff doubled for every earlier attempt.
+func (o *Outbox) retryDelay(attempt int) time.Duration {
+	if o.backoff <= 0 {
+		return 0
+	}
+
+	delay := o.backoff
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// signBody returns the value of the SignatureHeader for body sent with the
+// TimestampHeader timestamp.
+func signBody(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// writeFileAtomic writes data to a temporary file next to path first and
+// renames it, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
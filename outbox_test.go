@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// failingNotifier fails every notification and counts the attempts.
+type failingNotifier struct {
+	attempts int
+}
+
+func (n *failingNotifier) Name() string { return "failing" }
+
+func (n *failingNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.attempts++
+	return errors.New("unreachable")
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	outbox := &Outbox{backoff: 30 * time.Second}
+	tests := map[int]time.Duration{
+		1:   30 * time.Second,
+		2:   time.Minute,
+		3:   2 * time.Minute,
+		10:  256 * time.Minute,
+		13:  maxRetryDelay,
+		100: maxRetryDelay, // the shift would overflow
+	}
+	for attempt, want := range tests {
+		if got := outbox.retryDelay(attempt); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	outbox.backoff = 0
+	if got := outbox.retryDelay(3); got != 0 {
+		t.Errorf("retryDelay(3) without backoff = %v, want 0", got)
+	}
+}
+
+func TestOutboxDeliverBackoff(t *testing.T) {
+	notifier := &failingNotifier{}
+	outbox, err := LoadOutbox("", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Add([]VersionEvent{{Release: "main"}}, []Notifier{notifier}); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt, want := range []time.Duration{time.Minute, 2 * time.Minute} {
+		before := time.Now()
+		delivered, err := outbox.Deliver(context.Background(), []Notifier{notifier})
+		if delivered || err != nil {
+			t.Fatalf("attempt %d: Deliver() = %v, %v, want false, nil", attempt+1, delivered, err)
+		}
+
+		next, ok := outbox.NextAttempt()
+		if !ok {
+			t.Fatalf("attempt %d: the notification was dropped", attempt+1)
+		}
+		if delay := next.Sub(before); delay < want || delay > want+time.Second {
+			t.Errorf("attempt %d: retried after %v, want %v", attempt+1, delay, want)
+		}
+
+		// not due yet
+		if _, err := outbox.Deliver(context.Background(), []Notifier{notifier}); err != nil || notifier.attempts != attempt+1 {
+			t.Errorf("attempt %d: delivered before the delay, %d attempts", attempt+1, notifier.attempts)
+		}
+		outbox.Pending[0].NextAttempt = time.Now()
+	}
+
+	if _, err := outbox.Deliver(context.Background(), []Notifier{notifier}); err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox.Pending) != 0 || notifier.attempts != 3 {
+		t.Errorf("%d notifications pending after %d attempts, want none after 3", len(outbox.Pending), notifier.attempts)
+	}
+}
+
+func TestSignBody(t *testing.T) {
+	// printf '1700000000.{"release":"main"}' | openssl dgst -sha256 -hmac doduda-secret
+	want := "sha256=44dcbf9b28b5706205be0a8e6adfc9205efa9a6154b42c469a002e9abcaeed3b"
+	if got := signBody("doduda-secret", "1700000000", []byte(`{"release":"main"}`)); got != want {
+		t.Errorf("signBody() = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	notifier := &webhookNotifier{name: "webhook", url: server.URL, secret: "doduda-secret"}
+	if err := notifier.Notify(context.Background(), Notification{ID: "1", Event: VersionEvent{Release: "main"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := header.Get(TimestampHeader)
+	if timestamp == "" {
+		t.Fatal("the timestamp header is missing")
+	}
+	if got, want := header.Get(SignatureHeader), signBody("doduda-secret", timestamp, body); got != want {
+		t.Errorf("%s = %s, want %s", SignatureHeader, got, want)
+	}
+}
@@ -0,0 +1,157 @@
+package doduda
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// BundleCache stores downloaded Cytrus bundles on disk by their hash. Bundles
+// are shared between versions and releases, so an unchanged bundle is only
+// downloaded once. Interrupted downloads are resumed from their .part file.
+type BundleCache struct {
+	dir string
+}
+
+// DefaultBundleCacheDir returns doduda/bundles in the user cache directory.
+func DefaultBundleCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "doduda", "bundles"), nil
+}
+
+func NewBundleCache(dir string) (*BundleCache, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &BundleCache{dir: dir}, nil
+}
+
+func (c *BundleCache) path(bundleHash string) string {
+	return filepath.Join(c.dir, bundleHash[0:2], bundleHash)
+}
+
+// Get returns a cached bundle. Bundles that do not match their hash anymore
+// are removed.
+func (c *BundleCache) Get(bundleHash string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(bundleHash))
+	if err != nil {
+		return nil, false
+	}
+
+	if verifyBundle(bundleHash, data) != nil {
+		os.Remove(c.path(bundleHash))
+		return nil, false
+	}
+
+	return data, true
+}
+
+// fetchCached returns the bundle from the cache or downloads it into the cache.
+func (c *Client) fetchCached(ctx context.Context, bundleHash string) ([]byte, error) {
+	if data, ok := c.cache.Get(bundleHash); ok {
+		return data, nil
+	}
+
+	bundlePath := c.cache.path(bundleHash)
+	partPath := bundlePath + ".part"
+
+	err := os.MkdirAll(filepath.Dir(bundlePath), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.downloadBundleToFile(ctx, bundleHash, partPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(partPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyBundle(bundleHash, data); err != nil {
+		os.Remove(partPath) // start over next time, resuming a corrupt file would not help
+		return nil, err
+	}
+
+	err = os.Rename(partPath, bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// downloadBundleToFile appends the missing part of the bundle to path. The
+// server may ignore the range and send the whole bundle again.
+func (c *Client) downloadBundleToFile(ctx context.Context, bundleHash string, path string) error {
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bundleURL(bundleHash), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil // the part file is already complete
+	default:
+		return &StatusError{URL: req.URL.String(), Status: resp.StatusCode}
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, c.limitBody(resp.Body))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func bundleURL(bundleHash string) string {
+	return fmt.Sprintf("https://cytrus.cdn.ankama.com/dofus/bundles/%s/%s", bundleHash[0:2], bundleHash)
+}
+
+var errBundleVerification = errors.New("bundle failed verification")
+
+// verifyBundle checks the data against the Cytrus bundle hash, a SHA-1 of its
+// content.
+func verifyBundle(bundleHash string, data []byte) error {
+	sum := sha1.Sum(data)
+	if hex.EncodeToString(sum[:]) != bundleHash {
+		return fmt.Errorf("%s: %w", bundleHash, errBundleVerification)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package doduda
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serverTransport sends every request to a test server instead of the CDN.
+type serverTransport struct {
+	server *httptest.Server
+}
+
+func (t serverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(t.server.URL)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	return t.server.Client().Transport.RoundTrip(req)
+}
+
+// newCacheTestClient returns a client with a bundle cache whose downloads are
+// answered by handler.
+func newCacheTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(Options{
+		HTTPClient:     &http.Client{Transport: serverTransport{server}},
+		BundleCacheDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func testBundle() ([]byte, string) {
+	data := bytes.Repeat([]byte("cytrus bundle "), 10)
+	sum := sha1.Sum(data)
+	return data, hex.EncodeToString(sum[:])
+}
+
+func writePart(t *testing.T, client *Client, bundleHash string, data []byte) string {
+	t.Helper()
+
+	partPath := client.cache.path(bundleHash) + ".part"
+	if err := os.MkdirAll(filepath.Dir(partPath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return partPath
+}
+
+func TestFetchCached(t *testing.T) {
+	bundle, bundleHash := testBundle()
+
+	tests := []struct {
+		name        string
+		part        []byte
+		rangeHeader string // the Range header the server expects
+		status      int
+		body        []byte
+	}{
+		{"download", nil, "", http.StatusOK, bundle},
+		{"resume", bundle[:50], "bytes=50-", http.StatusPartialContent, bundle[50:]},
+		{"range ignored", []byte("garbage"), "bytes=7-", http.StatusOK, bundle},
+		{"part complete", bundle, "bytes=140-", http.StatusRequestedRangeNotSatisfiable, nil},
+	}
+	for _, test := range tests {
+		requests := 0
+		client := newCacheTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path != "/dofus/bundles/"+bundleHash[:2]+"/"+bundleHash {
+				t.Errorf("%s: requested %s", test.name, r.URL.Path)
+			}
+			if got := r.Header.Get("Range"); got != test.rangeHeader {
+				t.Errorf("%s: Range = %q, want %q", test.name, got, test.rangeHeader)
+			}
+			w.WriteHeader(test.status)
+			w.Write(test.body)
+		})
+
+		partPath := client.cache.path(bundleHash) + ".part"
+		if test.part != nil {
+			partPath = writePart(t, client, bundleHash, test.part)
+		}
+
+		data, err := client.fetchCached(context.Background(), bundleHash)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(data, bundle) {
+			t.Errorf("%s: fetched %q, want the bundle", test.name, data)
+		}
+		if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+			t.Errorf("%s: the part file is left over", test.name)
+		}
+
+		// the second fetch is served from the cache
+		data, err = client.fetchCached(context.Background(), bundleHash)
+		if err != nil || !bytes.Equal(data, bundle) || requests != 1 {
+			t.Errorf("%s: cached fetch returned %v after %d requests, want the bundle after 1", test.name, err, requests)
+		}
+	}
+}
+
+func TestFetchCachedVerification(t *testing.T) {
+	bundle, bundleHash := testBundle()
+
+	client := newCacheTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("corrupt"))
+	})
+	partPath := writePart(t, client, bundleHash, bundle[:50])
+
+	_, err := client.fetchCached(context.Background(), bundleHash)
+	if !errors.Is(err, errBundleVerification) {
+		t.Errorf("fetchCached returned %v, want a verification error", err)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Error("the corrupt part file is kept")
+	}
+	if _, ok := client.cache.Get(bundleHash); ok {
+		t.Error("the corrupt bundle is cached")
+	}
+}
+
+func TestFetchCachedStatus(t *testing.T) {
+	_, bundleHash := testBundle()
+
+	client := newCacheTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := client.fetchCached(context.Background(), bundleHash)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusNotFound {
+		t.Errorf("fetchCached returned %v, want a 404 status error", err)
+	}
+}
+
+func TestBundleCacheGetCorrupt(t *testing.T) {
+	_, bundleHash := testBundle()
+
+	cache, err := NewBundleCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cache.path(bundleHash)), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.path(bundleHash), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get(bundleHash); ok {
+		t.Error("Get returned a corrupt bundle")
+	}
+	if _, err := os.Stat(cache.path(bundleHash)); !os.IsNotExist(err) {
+		t.Error("the corrupt bundle is not removed")
+	}
+}
@@ -0,0 +1,242 @@
+// Package doduda downloads, unpacks and maps Dofus game files from the Ankama
+// Cytrus CDN. It is the library behind the doduda command and has no terminal
+// interface, long running calls report their progress through callbacks.
+package doduda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/ankabuffer"
+)
+
+// CytrusPrefix is put in front of game versions by the launcher.
+// ATT: prefix changes with cytrus updates
+const CytrusPrefix = "6.0_"
+
+// Options configures a Client.
+type Options struct {
+	HTTPClient     *http.Client  // nil uses http.DefaultClient
+	BundleCacheDir string        // keeps downloaded bundles on disk, empty keeps them in memory only
+	Concurrency    int           // parallel bundle downloads
+	Retries        int           // retries after the first attempt
+	Timeout        time.Duration // per request, 0 disables it
+	BandwidthLimit int64         // bytes per second over all downloads, 0 is unlimited
+	Logger         *log.Logger   // nil uses the default logger
+}
+
+// DefaultOptions returns the settings of the doduda command without a bundle
+// cache.
+func DefaultOptions() Options {
+	return Options{
+		Concurrency: 8,
+		Retries:     4,
+		Timeout:     5 * time.Minute,
+	}
+}
+
+// Client talks to the Cytrus CDN. It is safe for concurrent use.
+type Client struct {
+	httpClient *http.Client
+	cache      *BundleCache
+	config     DownloadConfig
+	bandwidth  *bandwidthLimiter
+	logger     *log.Logger
+}
+
+func NewClient(opts Options) (*Client, error) {
+	c := &Client{
+		httpClient: opts.HTTPClient,
+		logger:     opts.Logger,
+		config: DownloadConfig{
+			Concurrency:    opts.Concurrency,
+			Retries:        opts.Retries,
+			Timeout:        opts.Timeout,
+			BandwidthLimit: opts.BandwidthLimit,
+		},
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	if c.logger == nil {
+		c.logger = log.Default()
+	}
+	if c.config.Concurrency < 1 {
+		c.config.Concurrency = 1
+	}
+	if c.config.Retries < 0 {
+		c.config.Retries = 0
+	}
+	if c.config.BandwidthLimit > 0 {
+		c.bandwidth = &bandwidthLimiter{rate: c.config.BandwidthLimit}
+	}
+
+	if opts.BundleCacheDir != "" {
+		cache, err := NewBundleCache(opts.BundleCacheDir)
+		if err != nil {
+			return nil, NewError(KindIO, "bundle cache", err)
+		}
+		c.cache = cache
+	}
+
+	return c, nil
+}
+
+// WithBundleCache returns a copy of c that keeps downloaded bundles in dir.
+// The copy shares the HTTP client and the bandwidth limit with c.
+func (c *Client) WithBundleCache(dir string) (*Client, error) {
+	cache, err := NewBundleCache(dir)
+	if err != nil {
+		return nil, NewError(KindIO, "bundle cache", err)
+	}
+
+	cached := *c
+	cached.cache = cache
+	return &cached, nil
+}
+
+// HasBundleCache reports whether c keeps downloaded bundles on disk.
+func (c *Client) HasBundleCache() bool {
+	return c.cache != nil
+}
+
+// GameVersions are the current game versions of cytrus.json by platform and
+// release, without the Cytrus prefix.
+type GameVersions map[string]map[string]string
+
+// Version returns the version of a release on a platform, an empty platform
+// is windows.
+func (v GameVersions) Version(release string, platform string) (string, error) {
+	if platform == "" {
+		platform = "windows"
+	}
+
+	version, ok := v[platform][release]
+	if !ok {
+		return "", NewError(KindVersionNotFound, "cytrus version", fmt.Errorf("no version for release %s on %s", release, platform))
+	}
+	return version, nil
+}
+
+// GameVersions returns the current versions of all releases and platforms
+// with a single request.
+func (c *Client) GameVersions(ctx context.Context) (GameVersions, error) {
+	versionResponse, err := c.Get(ctx, "https://cytrus.cdn.ankama.com/cytrus.json")
+	if err != nil {
+		return nil, NewError(KindNetwork, "cytrus version", err)
+	}
+	defer versionResponse.Body.Close()
+
+	versionBody, err := io.ReadAll(versionResponse.Body)
+	if err != nil {
+		return nil, NewError(KindNetwork, "cytrus version", err)
+	}
+
+	var versionJson struct {
+		Games map[string]struct {
+			Platforms map[string]map[string]string `json:"platforms"`
+		} `json:"games"`
+	}
+	err = json.Unmarshal(versionBody, &versionJson)
+	if err != nil {
+		return nil, NewError(KindManifest, "cytrus version", err)
+	}
+
+	versions := make(GameVersions)
+	for platform, releases := range versionJson.Games["dofus"].Platforms {
+		versions[platform] = make(map[string]string, len(releases))
+		for release, version := range releases {
+			versions[platform][release] = strings.TrimPrefix(version, CytrusPrefix)
+		}
+	}
+	return versions, nil
+}
+
+// LatestVersion returns the current game version of a release like "main",
+// "beta" or "dofus3" on a platform, without the Cytrus prefix. An empty
+// platform is windows.
+func (c *Client) LatestVersion(ctx context.Context, release string, platform string) (string, error) {
+	versions, err := c.GameVersions(ctx)
+	if err != nil {
+		return "", err
+	}
+	return versions.Version(release, platform)
+}
+
+// ManifestOptions selects the manifest to fetch.
+type ManifestOptions struct {
+	Release  string // main, beta or dofus3
+	Platform string // windows, darwin or linux
+	Version  string // game version, empty or "latest" for the current one
+}
+
+// FetchManifest downloads and parses the manifest of a game version. A version
+// that does not exist for the release and platform is a KindVersionNotFound
+// error.
+func (c *Client) FetchManifest(ctx context.Context, opts ManifestOptions) (*ankabuffer.Manifest, error) {
+	version := strings.TrimPrefix(opts.Version, CytrusPrefix)
+	if version == "" || version == "latest" {
+		var err error
+		version, err = c.LatestVersion(ctx, opts.Release, opts.Platform)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	manifestUrl := fmt.Sprintf("https://cytrus.cdn.ankama.com/dofus/releases/%s/%s/%s%s.manifest", opts.Release, opts.Platform, CytrusPrefix, version)
+	manifestResponse, err := c.Get(ctx, manifestUrl)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && (statusErr.Status == http.StatusNotFound || statusErr.Status == http.StatusForbidden) {
+		return nil, NewError(KindVersionNotFound, "manifest", fmt.Errorf("version %s does not exist for %s on %s", version, opts.Release, opts.Platform))
+	}
+	if err != nil {
+		return nil, NewError(KindNetwork, "manifest", err)
+	}
+	defer manifestResponse.Body.Close()
+
+	rawManifest, err := io.ReadAll(manifestResponse.Body)
+	if err != nil {
+		return nil, NewError(KindNetwork, "manifest", err)
+	}
+
+	return ParseManifest(rawManifest, version)
+}
+
+// ParseManifest decodes a raw Cytrus manifest. The flatbuffer parser panics
+// on malformed input, that is returned as a KindManifest error.
+func ParseManifest(rawManifest []byte, version string) (manifest *ankabuffer.Manifest, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewError(KindManifest, "parse manifest", fmt.Errorf("%v", r))
+		}
+	}()
+	return ankabuffer.ParseManifest(rawManifest, version), nil
+}
+
+// Get requests url with ctx through the HTTP client of c. Responses other than
+// 200 OK are returned as *StatusError.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{URL: url, Status: resp.StatusCode}
+	}
+	return resp, nil
+}
@@ -0,0 +1,179 @@
+package doduda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// DownloadConfig controls how bundles are fetched from the CDN.
+type DownloadConfig struct {
+	Concurrency    int           // parallel bundle downloads
+	Retries        int           // retries after the first attempt
+	Timeout        time.Duration // per request, 0 disables it
+	BandwidthLimit int64         // bytes per second over all downloads, 0 is unlimited
+}
+
+// isRetryable reports whether another attempt could succeed: server errors,
+// rate limiting, timeouts, dropped connections and corrupt data.
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 500 || statusErr.Status == http.StatusTooManyRequests || statusErr.Status == http.StatusRequestTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, errBundleVerification)
+}
+
+// withRetries runs fn until it succeeds, fails with an error that is not
+// retryable or runs out of retries. Waits grow exponentially with jitter.
+// Canceling ctx stops the current attempt and the waiting.
+func withRetries[T any](ctx context.Context, config DownloadConfig, logger *log.Logger, what string, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	var err error
+	for attempt := 0; attempt <= config.Retries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Second << (attempt - 1)
+			if backoff > 30*time.Second {
+				backoff = 30 * time.Second
+			}
+			backoff += time.Duration(rand.Int63n(int64(backoff) / 2))
+			logger.Warn("Retrying", "what", what, "attempt", attempt, "in", backoff.Round(time.Millisecond), "err", err)
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return result, context.Cause(ctx)
+			case <-timer.C:
+			}
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if config.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, config.Timeout)
+		}
+		result, err = fn(attemptCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			return result, context.Cause(ctx)
+		}
+		if err == nil || !isRetryable(err) {
+			return result, err
+		}
+	}
+	return result, err
+}
+
+// bandwidthLimiter spreads reads over time so all downloads together stay
+// under rate bytes per second.
+type bandwidthLimiter struct {
+	rate int64
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (l *bandwidthLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+// limitBody applies the bandwidth limit of the client to a response body.
+func (c *Client) limitBody(body io.Reader) io.Reader {
+	if c.bandwidth == nil {
+		return body
+	}
+	return &limitedReader{r: body, limiter: c.bandwidth}
+}
+
+// ParseByteRate parses a bandwidth like 500K, 10M or 1G (bytes per second).
+// An empty string or 0 means unlimited.
+func ParseByteRate(rate string) (int64, error) {
+	rate = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(rate)), "B")
+	if rate == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch rate[len(rate)-1] {
+	case 'K':
+		multiplier = 1024
+	case 'M':
+		multiplier = 1024 * 1024
+	case 'G':
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		rate = rate[:len(rate)-1]
+	}
+
+	value, err := strconv.ParseFloat(rate, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", rate)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// DownloadError lists the files of a DownloadUnpackFiles call that could not
+// be produced, together with the reasons.
+type DownloadError struct {
+	Title  string
+	Files  []string
+	Causes []error
+}
+
+func (e *DownloadError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d files could not be downloaded: %s", e.Title, len(e.Files), strings.Join(e.Files, ", "))
+	if len(e.Causes) > 0 {
+		b.WriteString("\n")
+		b.WriteString(errors.Join(e.Causes...).Error())
+	}
+	return b.String()
+}
+
+func (e *DownloadError) Unwrap() []error {
+	return e.Causes
+}
@@ -0,0 +1,121 @@
+package doduda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+)
+
+// ErrorKind classifies errors so callers can react to them, for example with
+// an exit code.
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindUsage
+	KindNetwork
+	KindVersionNotFound
+	KindManifest
+	KindUnpack
+	KindContainer
+	KindIO
+	KindCanceled
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindUsage:
+		return "usage"
+	case KindNetwork:
+		return "network"
+	case KindVersionNotFound:
+		return "version not found"
+	case KindManifest:
+		return "manifest"
+	case KindUnpack:
+		return "unpack"
+	case KindContainer:
+		return "container"
+	case KindIO:
+		return "io"
+	case KindCanceled:
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is an error of one step with its kind.
+type Error struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError wraps err with the step that failed. An error that already has a
+// kind keeps it, the innermost step knows best what went wrong.
+func NewError(kind ErrorKind, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var typed *Error
+	if errors.As(err, &typed) {
+		kind = typed.Kind
+	} else if errors.Is(err, context.Canceled) {
+		kind = KindCanceled
+	}
+
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// KindOf returns the kind of err. Untyped errors are classified by their
+// standard library type.
+func KindOf(err error) ErrorKind {
+	var typed *Error
+	var statusErr *StatusError
+	var netErr net.Error
+	var urlErr *url.Error
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+
+	switch {
+	case err == nil:
+		return KindUnknown
+	case errors.Is(err, context.Canceled):
+		return KindCanceled
+	case errors.As(err, &typed):
+		return typed.Kind
+	case errors.As(err, &statusErr), errors.As(err, &netErr), errors.As(err, &urlErr):
+		return KindNetwork
+	case errors.As(err, &pathErr), errors.As(err, &linkErr):
+		return KindIO
+	default:
+		return KindUnknown
+	}
+}
+
+// StatusError is returned for HTTP responses other than 200 OK.
+type StatusError struct {
+	URL    string
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s status %d", e.URL, e.Status)
+}
@@ -0,0 +1,380 @@
+package doduda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dofusdude/ankabuffer"
+)
+
+// HashFile is a file of a manifest fragment and the name it gets on disk.
+type HashFile struct {
+	Hash         string
+	Filename     string
+	FriendlyName string
+}
+
+// DownloadOptions controls DownloadFiles.
+type DownloadOptions struct {
+	Title      string            // used in errors and log messages
+	Fragment   string            // manifest fragment of the files
+	DestDir    string            // files are written to DestDir/FriendlyName
+	Unpack     bool              // unpack the files with UnpackFile and remove the originals
+	Indent     string            // JSON indentation for unpacked files
+	BinSize    int               // split the files into bins of this many megabytes, 0 or less disables it
+	State      *IncrementalState // skip unchanged files and remember the written ones, nil disables it
+	OnProgress ProgressFunc
+}
+
+// DownloadBundle downloads a Cytrus bundle, through the bundle cache if one
+// is configured. Failed attempts are retried according to the client options.
+func (c *Client) DownloadBundle(ctx context.Context, bundleHash string) ([]byte, error) {
+	return withRetries(ctx, c.config, c.logger, "bundle "+bundleHash, func(ctx context.Context) ([]byte, error) {
+		if c.cache != nil {
+			return c.fetchCached(ctx, bundleHash)
+		}
+
+		resp, err := c.Get(ctx, bundleURL(bundleHash))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(c.limitBody(resp.Body))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := verifyBundle(bundleHash, body); err != nil {
+			return nil, err
+		}
+
+		return body, nil
+	})
+}
+
+func convertMBToBytes(mb int) int64 {
+	return int64(mb) * 1024 * 1024
+}
+
+func splitFilesIntoBins(files []ankabuffer.File, maxMBPerBin int) [][]ankabuffer.File {
+	maxBytesPerBin := convertMBToBytes(maxMBPerBin)
+	var bins [][]ankabuffer.File
+	var currentBin []ankabuffer.File
+	var currentBinSize int64
+
+	for _, file := range files {
+		if file.Size > maxBytesPerBin {
+			if len(currentBin) > 0 {
+				bins = append(bins, currentBin)
+				currentBin = nil
+				currentBinSize = 0
+			}
+			bins = append(bins, []ankabuffer.File{file})
+			continue
+		}
+
+		if currentBinSize+file.Size > maxBytesPerBin {
+			bins = append(bins, currentBin)
+			currentBin = []ankabuffer.File{file}
+			currentBinSize = file.Size
+		} else {
+			currentBin = append(currentBin, file)
+			currentBinSize += file.Size
+		}
+	}
+
+	if len(currentBin) > 0 {
+		bins = append(bins, currentBin)
+	}
+
+	return bins
+}
+
+// DownloadFiles downloads files of a manifest fragment and optionally unpacks
+// them. Files that could not be produced are collected in a *DownloadError,
+// the others are kept. Canceling ctx stops the downloads and returns its cause.
+func (c *Client) DownloadFiles(ctx context.Context, manifest *ankabuffer.Manifest, toDownload []HashFile, opts DownloadOptions) error {
+	filesToDownload, friendlyNames, skipped := filterDownload(manifest, toDownload, opts)
+
+	if skipped > 0 {
+		c.logger.Infof("%s: skipping %d unchanged files", opts.Title, skipped)
+	}
+
+	if len(filesToDownload) == 0 {
+		return nil
+	}
+
+	downloadErr := &DownloadError{Title: opts.Title}
+	var downloadErrMu sync.Mutex
+	fail := func(file string, err error) {
+		downloadErrMu.Lock()
+		defer downloadErrMu.Unlock()
+		downloadErr.Files = append(downloadErr.Files, file)
+		downloadErr.Causes = append(downloadErr.Causes, err)
+	}
+	var skippedFiles, skippedImages int
+	skip := func(file string, images int) {
+		downloadErrMu.Lock()
+		defer downloadErrMu.Unlock()
+		skippedFiles++
+		skippedImages += images
+	}
+
+	filebins := splitBins(filesToDownload, opts.BinSize)
+
+	for idx, filesToDownload := range filebins {
+		binProgress := Progress{Bin: idx + 1, Bins: len(filebins)}
+
+		progress := binProgress
+		progress.Stage = StagePrepare
+		progress.Total = len(filesToDownload)
+		opts.OnProgress.report(progress)
+
+		err := c.downloadBin(ctx, manifest, filesToDownload, friendlyNames, opts, binProgress, fail, skip)
+
+		progress = binProgress
+		progress.Stage = StageBinDone
+		opts.OnProgress.report(progress)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if skippedFiles > 0 {
+		c.logger.Warnf("%s: skipped %d images with unsupported texture formats in %d files, they are unpacked again next time", opts.Title, skippedImages, skippedFiles)
+	}
+
+	if err := opts.State.Save(); err != nil {
+		return NewError(KindIO, "incremental state", err)
+	}
+
+	if len(downloadErr.Files) > 0 {
+		return downloadErr
+	}
+	return nil
+}
+
+// downloadBin downloads the bundles of one bin into memory and assembles its
+// files from their chunks. Image bundles with skipped images are reported to
+// skip and kept out of the incremental state.
+func (c *Client) downloadBin(ctx context.Context, manifest *ankabuffer.Manifest, filesToDownload []ankabuffer.File, friendlyNames map[string]string, opts DownloadOptions, binProgress Progress, fail func(file string, err error), skip func(file string, images int)) error {
+	bundles := ankabuffer.GetNeededBundles(filesToDownload)
+
+	if len(bundles) == 0 && len(filesToDownload) > 0 {
+		for _, file := range filesToDownload {
+			c.logger.Warn("Missing bundle for", file.Name)
+		}
+	}
+
+	if len(bundles) == 0 {
+		//log.Warn("No bundles to download") // TODO it seems like the files come out okay even if there are no bundles, maybe warning is not needed
+		return nil
+	}
+
+	bundlesMap := ankabuffer.GetBundleHashMap(manifest)
+
+	type DownloadedBundle struct {
+		BundleHash string
+		Data       []byte
+	}
+
+	bundlesBuffer := make(map[string]DownloadedBundle)
+
+	progress := binProgress
+	progress.Stage = StageDownload
+	progress.Total = len(bundles)
+	opts.OnProgress.report(progress)
+
+	var bundleDownloadWg sync.WaitGroup
+	var bundleDownloadMu sync.Mutex
+	failedBundles := make(map[string]error)
+	downloaded := 0
+
+	bundleQueue := make(chan string)
+	for w := 0; w < c.config.Concurrency; w++ {
+		bundleDownloadWg.Add(1)
+		go func() {
+			defer bundleDownloadWg.Done()
+			for bundle := range bundleQueue {
+				bundleData, err := c.DownloadBundle(ctx, bundle)
+
+				bundleDownloadMu.Lock()
+				if err != nil {
+					if ctx.Err() == nil {
+						c.logger.Errorf("Could not download bundle %s: %s\n", bundle, err)
+					}
+					failedBundles[bundle] = err
+				} else {
+					bundlesBuffer[bundle] = DownloadedBundle{BundleHash: bundle, Data: bundleData}
+				}
+				downloaded++
+				progress := progress
+				progress.Current = downloaded
+				bundleDownloadMu.Unlock()
+
+				if ctx.Err() == nil {
+					opts.OnProgress.report(progress)
+				}
+			}
+		}()
+	}
+
+	for _, bundle := range bundles {
+		bundleQueue <- bundle
+	}
+	close(bundleQueue)
+
+	bundleDownloadWg.Wait()
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	// failedBundleOf returns why a bundle with data of the file could not be
+	// downloaded, if one failed
+	failedBundleOf := func(file ankabuffer.File) error {
+		for bundle, err := range failedBundles {
+			for _, chunk := range bundlesMap[bundle].Chunks {
+				if chunk.Hash == file.Hash {
+					return err
+				}
+				for _, fileChunk := range file.Chunks {
+					if chunk.Hash == fileChunk.Hash {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for _, file := range filesToDownload {
+		wg.Add(1)
+		go func(file ankabuffer.File) {
+			defer wg.Done()
+			var fileData []byte
+
+			if file.Chunks == nil || len(file.Chunks) == 0 { // file is not chunked
+				for _, bundle := range bundlesBuffer {
+					for _, chunk := range bundlesMap[bundle.BundleHash].Chunks {
+						if chunk.Hash == file.Hash {
+							fileData = bundle.Data[chunk.Offset : chunk.Offset+chunk.Size]
+							break
+						}
+					}
+					if fileData != nil {
+						break
+					}
+				}
+			} else { // file is chunked
+				type ChunkData struct {
+					Data   []byte
+					Offset int64
+					Size   int64
+				}
+				var chunksData []ChunkData
+				for _, chunk := range file.Chunks { // all chunks of the file
+					for _, bundle := range bundlesBuffer { // search in downloaded bundles for the chunk
+						foundChunk := false
+						for _, bundleChunk := range bundlesMap[bundle.BundleHash].Chunks { // each chunk of the searched bundle could be a chunk of the file
+							if bundleChunk.Hash == chunk.Hash {
+								foundChunk = true
+								if len(bundle.Data) < int(bundleChunk.Offset+bundleChunk.Size) {
+									err := fmt.Errorf("bundle data is too small. Bundle offset/size: %d/%d, BundleData length: %d, BundleHash: %s, BundleChunkHash: %s", bundleChunk.Offset, bundleChunk.Size, len(bundle.Data), bundle.BundleHash, bundleChunk.Hash)
+									fail(file.Name, err)
+									return
+								}
+
+								chunksData = append(chunksData, ChunkData{Data: bundle.Data[bundleChunk.Offset : bundleChunk.Offset+bundleChunk.Size], Offset: chunk.Offset, Size: chunk.Size})
+							}
+						}
+						if foundChunk {
+							break
+						}
+					}
+				}
+				if len(chunksData) != len(file.Chunks) {
+					err := failedBundleOf(file)
+					if err == nil {
+						err = fmt.Errorf("found %d of %d chunks", len(chunksData), len(file.Chunks))
+					}
+					fail(file.Name, err)
+					return
+				}
+				sort.Slice(chunksData, func(i, j int) bool {
+					return chunksData[i].Offset < chunksData[j].Offset
+				})
+				for _, chunk := range chunksData {
+					fileData = append(fileData, chunk.Data...)
+				}
+			}
+
+			if len(fileData) == 0 {
+				err := failedBundleOf(file)
+				if err == nil {
+					err = fmt.Errorf("file data is empty %s", file.Hash)
+				}
+				fail(file.Name, err)
+				return
+			}
+
+			offlineFilePath := filepath.Join(opts.DestDir, friendlyNames[file.Name])
+
+			err := os.MkdirAll(filepath.Dir(offlineFilePath), os.ModePerm)
+			if err == nil {
+				err = os.WriteFile(offlineFilePath, fileData, os.ModePerm)
+			}
+			if err != nil {
+				os.Remove(offlineFilePath)
+				fail(file.Name, NewError(KindIO, "write "+file.Name, err))
+				return
+			}
+
+			c.logger.Infof("%s ✅", filepath.Base(file.Name))
+
+			unpacked := true
+			if opts.Unpack {
+				err := c.UnpackFile(ctx, offlineFilePath, UnpackOptions{DestDir: opts.DestDir, Indent: opts.Indent})
+				os.Remove(offlineFilePath)
+				var skipped *SkippedImagesError
+				switch {
+				case errors.As(err, &skipped):
+					// the other images are written, the file stays out of the
+					// incremental state so it is unpacked again next time
+					c.logger.Warn("Skipped images", "file", file.Name, "count", skipped.Count, "err", skipped.Err)
+					skip(file.Name, skipped.Count)
+					unpacked = false
+				case err != nil:
+					fail(file.Name, err)
+					return
+				}
+			}
+
+			if unpacked {
+				opts.State.Update(opts.Fragment, file)
+			}
+
+			progress := binProgress
+			progress.Stage = StageFile
+			progress.Name = friendlyNames[file.Name]
+			opts.OnProgress.report(progress)
+		}(file)
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package doduda
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dofusdude/ankabuffer"
+)
+
+const incrementalStateFileName = ".doduda-state.json"
+
+// IncrementalState remembers the manifest hash of every file that was
+// successfully written to the output directory by a previous run.
+type IncrementalState struct {
+	GameVersion string                       `json:"game_version"`
+	Files       map[string]map[string]string `json:"files"` // fragment -> file name -> hash
+
+	path string
+	mu   sync.Mutex
+}
+
+func LoadIncrementalState(dir string) (*IncrementalState, error) {
+	state := &IncrementalState{
+		Files: make(map[string]map[string]string),
+		path:  filepath.Join(dir, incrementalStateFileName),
+	}
+
+	raw, err := os.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(raw, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Files == nil {
+		state.Files = make(map[string]map[string]string)
+	}
+
+	return state, nil
+}
+
+// Unchanged reports whether the file was already produced with the same hash.
+// A nil state disables incremental mode, so nothing is ever unchanged.
+func (s *IncrementalState) Unchanged(fragment string, file ankabuffer.File) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, ok := s.Files[fragment][file.Name]
+	return ok && hash == file.Hash
+}
+
+func (s *IncrementalState) Update(fragment string, file ankabuffer.File) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Files[fragment] == nil {
+		s.Files[fragment] = make(map[string]string)
+	}
+	s.Files[fragment][file.Name] = file.Hash
+}
+
+func (s *IncrementalState) Save() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path, raw, 0644)
+}
@@ -0,0 +1,172 @@
+package doduda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	mapping "github.com/dofusdude/dodumap"
+)
+
+// MapOptions controls Map.
+type MapOptions struct {
+	Dir            string   // unpacked data and languages, the MAPPED_*.json files are written here
+	Indent         string   // JSON indentation, empty for compact JSON
+	PersistenceDir string   // persisted element and item type IDs, empty disables persistence
+	Release        string   // main, beta or dofus3
+	Targets        []string // mapped files to write, see MapTargets. Empty writes all
+	OnProgress     ProgressFunc
+}
+
+// MapTargets are the valid MapOptions.Targets.
+var MapTargets = []string{"items", "mounts", "almanax", "sets", "recipes"}
+
+func saveJSON(data interface{}, path string, indent string) error {
+	var outBytes []byte
+	var err error
+	if indent != "" {
+		outBytes, err = json.MarshalIndent(data, "", indent)
+	} else {
+		outBytes, err = json.Marshal(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path, outBytes, 0644)
+	if err != nil {
+		return NewError(KindIO, "save "+filepath.Base(path), err)
+	}
+	return nil
+}
+
+// DetectRawDataMajorVersion tells from the unpacked areas.json in dir if the
+// data is from Dofus 2 or 3.
+func DetectRawDataMajorVersion(dir string) (int, error) {
+	file, err := os.ReadFile(filepath.Join(dir, "areas.json"))
+	if err != nil {
+		return 0, err
+	}
+	var areasJson interface{}
+	err = json.Unmarshal(file, &areasJson)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := areasJson.(map[string]interface{}); ok {
+		return 3, nil
+	} else if _, ok := areasJson.([]interface{}); ok {
+		return 2, nil
+	}
+
+	return 0, errors.New("Could not detect major version of raw data")
+}
+
+// mappedFile is one MAPPED_*.json output of Map.
+type mappedFile struct {
+	name     string
+	fileName string
+	mapped   func() interface{}
+}
+
+// Map converts the unpacked data of opts.Dir into the MAPPED_*.json files.
+// Canceling ctx stops between the mapping steps.
+func (c *Client) Map(ctx context.Context, opts MapOptions) error {
+	majorVersion, err := DetectRawDataMajorVersion(opts.Dir)
+	if err != nil {
+		return NewError(KindUnpack, "map", err)
+	}
+
+	step := func(stage Stage, name string) error {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		opts.OnProgress.report(Progress{Stage: stage, Name: name})
+		return nil
+	}
+
+	if err := step(StageLoad, "Load persistence"); err != nil {
+		return err
+	}
+	err = mapping.LoadPersistedElements(opts.PersistenceDir, opts.Release, majorVersion)
+	if err != nil {
+		return NewError(KindIO, "load persistence", err)
+	}
+
+	if err := step(StageLoad, "Game data"); err != nil {
+		return err
+	}
+
+	var files []mappedFile
+	if majorVersion == 2 {
+		gameData := mapping.ParseRawData(opts.Dir)
+
+		if err := step(StageLoad, "Languages"); err != nil {
+			return err
+		}
+		languageData := mapping.ParseRawLanguages(opts.Dir)
+
+		files = []mappedFile{
+			{"Items", "MAPPED_ITEMS.json", func() interface{} { return mapping.MapItems(gameData, &languageData) }},
+			{"Mounts", "MAPPED_MOUNTS.json", func() interface{} { return mapping.MapMounts(gameData, &languageData) }},
+			{"Almanax", "MAPPED_ALMANAX.json", func() interface{} { return mapping.MapAlmanax(gameData, &languageData) }},
+			{"Sets", "MAPPED_SETS.json", func() interface{} { return mapping.MapSets(gameData, &languageData) }},
+			{"Recipes", "MAPPED_RECIPES.json", func() interface{} { return mapping.MapRecipes(gameData) }},
+		}
+	} else if majorVersion == 3 {
+		gameData := mapping.ParseRawDataUnity(opts.Dir)
+
+		if err := step(StageLoad, "Languages"); err != nil {
+			return err
+		}
+		languageData := mapping.ParseRawLanguagesUnity(opts.Dir)
+
+		files = []mappedFile{
+			{"Items", "MAPPED_ITEMS.json", func() interface{} { return mapping.MapItemsUnity(gameData, &languageData) }},
+			{"Mounts", "MAPPED_MOUNTS.json", func() interface{} { return mapping.MapMountsUnity(gameData, &languageData) }},
+			{"Almanax", "MAPPED_ALMANAX.json", func() interface{} { return mapping.MapAlmanaxUnity(gameData, &languageData) }},
+			{"Sets", "MAPPED_SETS.json", func() interface{} { return mapping.MapSetsUnity(gameData, &languageData) }},
+			{"Recipes", "MAPPED_RECIPES.json", func() interface{} { return mapping.MapRecipesUnity(gameData) }},
+		}
+	} else {
+		return NewError(KindUnpack, "map", fmt.Errorf("unsupported major version %d of raw data", majorVersion))
+	}
+
+	for _, file := range files {
+		if len(opts.Targets) > 0 && !slices.Contains(opts.Targets, strings.ToLower(file.name)) {
+			continue
+		}
+		if err := step(StageMap, file.name); err != nil {
+			return err
+		}
+		if err := saveJSON(file.mapped(), filepath.Join(opts.Dir, file.fileName), opts.Indent); err != nil {
+			return err
+		}
+	}
+
+	if opts.PersistenceDir != "" {
+		if err := step(StageLoad, "Persist"); err != nil {
+			return err
+		}
+		dofus3prefix := ""
+		if majorVersion == 3 {
+			dofus3prefix = ".dofus3"
+		}
+
+		releasePersist := opts.Release
+		if releasePersist == "dofus3" {
+			releasePersist = "main"
+		}
+		err := mapping.PersistElements(filepath.Join(opts.PersistenceDir, fmt.Sprintf("elements%s.%s.json", dofus3prefix, releasePersist)), filepath.Join(opts.PersistenceDir, fmt.Sprintf("item_types%s.%s.json", dofus3prefix, releasePersist)))
+		if err != nil {
+			return NewError(KindIO, "persist", err)
+		}
+	}
+
+	return nil
+}
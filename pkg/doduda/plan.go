@@ -0,0 +1,133 @@
+package doduda
+
+import (
+	"sort"
+
+	"github.com/dofusdude/ankabuffer"
+)
+
+// FilePlan is what DownloadFiles would download for a list of files of a
+// fragment with the same options.
+type FilePlan struct {
+	Fragment         string        `json:"fragment"`
+	Files            []PlannedFile `json:"files"`
+	Unchanged        int           `json:"unchanged"` // skipped by the incremental state
+	Bins             []BinPlan     `json:"bins"`
+	Bundles          int           `json:"bundles"`
+	CompressedSize   int64         `json:"compressed_size"`   // size of the bundles
+	UncompressedSize int64         `json:"uncompressed_size"` // size of the files
+}
+
+// PlannedFile is a file of a FilePlan with the bundles that contain its
+// chunks.
+type PlannedFile struct {
+	Name         string   `json:"name"`
+	FriendlyName string   `json:"friendly_name"`
+	Size         int64    `json:"size"`
+	Bundles      []string `json:"bundles"`
+}
+
+// BinPlan is one bin of a FilePlan. Each bin downloads all of its bundles, so
+// bundles needed by several bins are downloaded more than once without the
+// bundle cache.
+type BinPlan struct {
+	Files            int      `json:"files"`
+	Bundles          []string `json:"bundles"`
+	CompressedSize   int64    `json:"compressed_size"`
+	UncompressedSize int64    `json:"uncompressed_size"`
+}
+
+// BundleSizes returns the size of every bundle of the manifest by its hash.
+func BundleSizes(manifest *ankabuffer.Manifest) map[string]int64 {
+	sizes := make(map[string]int64)
+	for hash, bundle := range ankabuffer.GetBundleHashMap(manifest) {
+		var size int64
+		for _, chunk := range bundle.Chunks {
+			size = max(size, chunk.Offset+chunk.Size)
+		}
+		sizes[hash] = size
+	}
+	return sizes
+}
+
+// PlanFiles returns the plan of DownloadFiles without downloading anything.
+// bundleSizes comes from BundleSizes of the same manifest.
+func PlanFiles(manifest *ankabuffer.Manifest, toDownload []HashFile, opts DownloadOptions, bundleSizes map[string]int64) FilePlan {
+	files, friendlyNames, unchanged := filterDownload(manifest, toDownload, opts)
+
+	plan := FilePlan{
+		Fragment:  opts.Fragment,
+		Files:     []PlannedFile{},
+		Unchanged: unchanged,
+		Bins:      []BinPlan{},
+	}
+
+	for _, file := range files {
+		bundles := ankabuffer.GetNeededBundles([]ankabuffer.File{file})
+		sort.Strings(bundles)
+		plan.Files = append(plan.Files, PlannedFile{
+			Name:         file.Name,
+			FriendlyName: friendlyNames[file.Name],
+			Size:         file.Size,
+			Bundles:      bundles,
+		})
+		plan.UncompressedSize += file.Size
+	}
+
+	if len(files) == 0 {
+		return plan
+	}
+
+	allBundles := make(map[string]bool)
+	for _, bin := range splitBins(files, opts.BinSize) {
+		bundles := ankabuffer.GetNeededBundles(bin)
+		sort.Strings(bundles)
+
+		binPlan := BinPlan{Files: len(bin), Bundles: bundles}
+		for _, file := range bin {
+			binPlan.UncompressedSize += file.Size
+		}
+		for _, bundle := range bundles {
+			binPlan.CompressedSize += bundleSizes[bundle]
+			if !allBundles[bundle] {
+				allBundles[bundle] = true
+				plan.CompressedSize += bundleSizes[bundle]
+			}
+		}
+		plan.Bins = append(plan.Bins, binPlan)
+	}
+	plan.Bundles = len(allBundles)
+
+	return plan
+}
+
+// filterDownload returns the manifest files of toDownload that DownloadFiles
+// downloads, their friendly names and the number of files skipped because
+// they are unchanged.
+func filterDownload(manifest *ankabuffer.Manifest, toDownload []HashFile, opts DownloadOptions) ([]ankabuffer.File, map[string]string, int) {
+	var files []ankabuffer.File
+	friendlyNames := make(map[string]string, len(toDownload))
+	skipped := 0
+	for _, file := range toDownload {
+		manifestFile := manifest.Fragments[opts.Fragment].Files[file.Filename]
+		if manifestFile.Name == "" {
+			continue
+		}
+		if opts.State.Unchanged(opts.Fragment, manifestFile) {
+			skipped++
+			continue
+		}
+		files = append(files, manifestFile)
+		friendlyNames[file.Filename] = file.FriendlyName
+	}
+	return files, friendlyNames, skipped
+}
+
+// splitBins splits the files into bins of binSize megabytes, a binSize of 0
+// or less keeps them in one bin.
+func splitBins(files []ankabuffer.File, binSize int) [][]ankabuffer.File {
+	if binSize > 0 {
+		return splitFilesIntoBins(files, binSize)
+	}
+	return [][]ankabuffer.File{files}
+}
@@ -0,0 +1,40 @@
+package doduda
+
+// Stage tells what a Progress report is about.
+type Stage int
+
+const (
+	// StagePrepare starts bin Bin of Bins in DownloadFiles with Total files.
+	StagePrepare Stage = iota
+	// StageDownload reports Current of Total downloaded bundles of the bin.
+	// Current 0 is sent once before the first download starts.
+	StageDownload
+	// StageFile reports that the file Name was written and unpacked.
+	StageFile
+	// StageBinDone ends the bin Bin of Bins.
+	StageBinDone
+	// StageLoad reports a loading step of Map like "Game data" or "Languages".
+	StageLoad
+	// StageMap reports that Map starts mapping the entity Name, like "Items".
+	StageMap
+)
+
+// Progress is reported to a ProgressFunc by long running calls.
+type Progress struct {
+	Stage   Stage
+	Name    string
+	Bin     int // 1-based
+	Bins    int
+	Current int
+	Total   int
+}
+
+// ProgressFunc receives progress reports. StageDownload and StageFile reports
+// can come from several goroutines at once.
+type ProgressFunc func(p Progress)
+
+func (fn ProgressFunc) report(p Progress) {
+	if fn != nil {
+		fn(p)
+	}
+}
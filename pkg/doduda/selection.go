@@ -0,0 +1,258 @@
+package doduda
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dofusdude/ankabuffer"
+	"gopkg.in/yaml.v3"
+)
+
+const knownBundlesFileName = ".doduda-bundles.json"
+
+//go:embed selection.yaml
+var defaultSelectionRules []byte
+
+// SelectionRule selects files of the manifest for a download stage, see
+// selection.yaml for the built-in rules.
+type SelectionRule struct {
+	Group        string `yaml:"group" json:"group" mapstructure:"group"`
+	Version      int    `yaml:"version,omitempty" json:"version,omitempty" mapstructure:"version"`    // major game version, 0 matches all
+	Fragment     string `yaml:"fragment,omitempty" json:"fragment,omitempty" mapstructure:"fragment"` // empty matches all fragments
+	Glob         string `yaml:"glob,omitempty" json:"glob,omitempty" mapstructure:"glob"`
+	Regex        string `yaml:"regex,omitempty" json:"regex,omitempty" mapstructure:"regex"`
+	FriendlyName string `yaml:"friendly_name,omitempty" json:"friendly_name,omitempty" mapstructure:"friendly_name"` // derived from the file name if empty
+	Exclude      bool   `yaml:"exclude,omitempty" json:"exclude,omitempty" mapstructure:"exclude"`
+
+	regex *regexp.Regexp
+}
+
+// SelectionRules are the rules of all download stages. The first rule of a
+// group that matches a file decides if it is selected.
+type SelectionRules struct {
+	Rules []SelectionRule `yaml:"rules" json:"rules" mapstructure:"rules"`
+}
+
+// DefaultSelectionRules returns the built-in rules.
+func DefaultSelectionRules() *SelectionRules {
+	rules, err := ParseSelectionRules(defaultSelectionRules)
+	if err != nil {
+		panic(fmt.Sprintf("built-in selection rules: %v", err))
+	}
+	return rules
+}
+
+// DefaultSelectionRulesYAML returns the built-in rules as a starting point for
+// a custom rules file.
+func DefaultSelectionRulesYAML() []byte {
+	return append([]byte(nil), defaultSelectionRules...)
+}
+
+// LoadSelectionRules reads rules from a YAML or JSON file.
+func LoadSelectionRules(file string) (*SelectionRules, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, NewError(KindIO, "selection rules", err)
+	}
+
+	rules, err := ParseSelectionRules(raw)
+	if err != nil {
+		return nil, NewError(KindUsage, "selection rules "+filepath.Base(file), err)
+	}
+	return rules, nil
+}
+
+// ParseSelectionRules parses rules in YAML, which includes JSON, and checks
+// their patterns.
+func ParseSelectionRules(raw []byte) (*SelectionRules, error) {
+	rules := &SelectionRules{}
+	if err := yaml.Unmarshal(raw, rules); err != nil {
+		return nil, err
+	}
+
+	if err := rules.Compile(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Compile checks the rules and compiles their regular expressions. Rules
+// that were not parsed by ParseSelectionRules must be compiled before use.
+func (r *SelectionRules) Compile() error {
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Group == "" {
+			return fmt.Errorf("rule %d: group is missing", i+1)
+		}
+		if (rule.Glob == "") == (rule.Regex == "") {
+			return fmt.Errorf("rule %d: needs either glob or regex", i+1)
+		}
+		if rule.Glob != "" {
+			if _, err := path.Match(rule.Glob, ""); err != nil {
+				return fmt.Errorf("rule %d: glob %q: %w", i+1, rule.Glob, err)
+			}
+		} else {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("rule %d: regex: %w", i+1, err)
+			}
+			rule.regex = re
+		}
+	}
+	return nil
+}
+
+// match reports whether the rule matches the file and the name it gets on
+// disk.
+func (r *SelectionRule) match(fragment string, file string) (string, bool) {
+	if r.Fragment != "" && r.Fragment != fragment {
+		return "", false
+	}
+
+	if r.regex != nil {
+		submatches := r.regex.FindStringSubmatchIndex(file)
+		if submatches == nil {
+			return "", false
+		}
+		if r.FriendlyName == "" {
+			return DeriveFriendlyName(file), true
+		}
+		return string(r.regex.ExpandString(nil, r.FriendlyName, file, submatches)), true
+	}
+
+	if ok, _ := path.Match(r.Glob, file); !ok {
+		return "", false
+	}
+	if r.FriendlyName == "" {
+		return DeriveFriendlyName(file), true
+	}
+	return r.FriendlyName, true
+}
+
+// Select returns the files of the manifest that the rules of group select for
+// the major game version, grouped by fragment and sorted by path.
+func (r *SelectionRules) Select(manifest *ankabuffer.Manifest, group string, version int) map[string][]HashFile {
+	var rules []*SelectionRule
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Group == group && (rule.Version == 0 || rule.Version == version) {
+			rules = append(rules, rule)
+		}
+	}
+
+	selected := make(map[string][]HashFile)
+	if len(rules) == 0 {
+		return selected
+	}
+
+	for fragmentName, fragment := range manifest.Fragments {
+		for _, file := range fragment.Files {
+			if file.Name == "" {
+				continue
+			}
+			for _, rule := range rules {
+				friendlyName, ok := rule.match(fragmentName, file.Name)
+				if !ok {
+					continue
+				}
+				if !rule.Exclude {
+					selected[fragmentName] = append(selected[fragmentName], HashFile{
+						Filename:     file.Name,
+						FriendlyName: friendlyName,
+						Hash:         file.Hash,
+					})
+				}
+				break
+			}
+		}
+	}
+
+	for _, files := range selected {
+		sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+	}
+	return selected
+}
+
+// DeriveFriendlyName returns the base name of a manifest file without the
+// data_assets_ prefix and the root suffix of Dofus 3 data bundles, so
+// data_assets_itemsroot.asset.bundle becomes items.asset.bundle. Other names
+// stay as they are.
+func DeriveFriendlyName(file string) string {
+	name := strings.TrimPrefix(path.Base(file), "data_assets_")
+	if dot := strings.Index(name, "."); dot > len("root") && strings.HasSuffix(name[:dot], "root") {
+		name = name[:dot-len("root")] + name[dot:]
+	}
+	return name
+}
+
+// KnownBundles remembers which files each group selected in the last run
+// into an output directory, to report files that were not selected before.
+type KnownBundles struct {
+	Groups map[string][]string `json:"groups"` // group -> manifest paths
+
+	path string
+}
+
+func LoadKnownBundles(dir string) (*KnownBundles, error) {
+	known := &KnownBundles{
+		Groups: make(map[string][]string),
+		path:   filepath.Join(dir, knownBundlesFileName),
+	}
+
+	raw, err := os.ReadFile(known.path)
+	if os.IsNotExist(err) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, known); err != nil {
+		return nil, err
+	}
+	if known.Groups == nil {
+		known.Groups = make(map[string][]string)
+	}
+	return known, nil
+}
+
+// Discover records the selected files of group and returns the ones the
+// last run did not select. Nothing is new for a group seen the first time.
+func (k *KnownBundles) Discover(group string, selected map[string][]HashFile) []HashFile {
+	previous, seen := k.Groups[group]
+	before := make(map[string]bool, len(previous))
+	for _, file := range previous {
+		before[file] = true
+	}
+
+	var discovered []HashFile
+	var current []string
+	for _, files := range selected {
+		for _, file := range files {
+			current = append(current, file.Filename)
+			if seen && !before[file.Filename] {
+				discovered = append(discovered, file)
+			}
+		}
+	}
+
+	sort.Strings(current)
+	sort.Slice(discovered, func(i, j int) bool { return discovered[i].Filename < discovered[j].Filename })
+	k.Groups[group] = current
+	return discovered
+}
+
+func (k *KnownBundles) Save() error {
+	raw, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(k.path, raw, 0644)
+}
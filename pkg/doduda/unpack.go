@@ -0,0 +1,242 @@
+package doduda
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dofusdude/doduda/unpack"
+	jsnan "github.com/xhhuango/json"
+)
+
+func unpackUnityBundle(inputPath string, outputPath string) error {
+	if !strings.HasSuffix(inputPath, ".bundle") {
+		return fmt.Errorf("invalid bundle suffix")
+	}
+
+	if !strings.HasSuffix(outputPath, ".json") {
+		return fmt.Errorf("can only output to json")
+	}
+
+	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
+		return fmt.Errorf("bundle %s does not exist", inputPath)
+	}
+
+	outputTrimmed := strings.TrimSuffix(outputPath, ".asset.json")
+	outputFileName := outputTrimmed + ".json"
+
+	rawBundle, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := unpack.UnityBundleData(rawBundle)
+	if err != nil {
+		return fmt.Errorf("could not unpack %s: %w", filepath.Base(inputPath), err)
+	}
+
+	// always indented, the mapper expects the `"rid": 123` formatting
+	marshalledBytes, err := jsnan.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	marshalledBytes = bytes.Replace(marshalledBytes, []byte("NaN"), []byte("null"), -1)
+
+	return os.WriteFile(outputFileName, marshalledBytes, os.ModePerm)
+}
+
+// SkippedImagesError is returned by UnpackFile when images of a bundle use a
+// texture format that cannot be decoded, like crunched textures. The other
+// images are written.
+type SkippedImagesError struct {
+	Bundle string
+	Count  int
+	Err    error // matches unpack.ErrUnsupportedTextureFormat
+}
+
+func (e *SkippedImagesError) Error() string {
+	return fmt.Sprintf("%s: skipped %d images: %v", e.Bundle, e.Count, e.Err)
+}
+
+func (e *SkippedImagesError) Unwrap() error {
+	return e.Err
+}
+
+// unpackUnityImages extracts the sprites and textures of an image bundle as
+// png files. Assets are placed by their container path, for example
+// Assets/BuiltAssets/items/2x, and name clashes get a _#N suffix.
+func (c *Client) unpackUnityImages(inputPath string, outputDir string) error {
+	rawBundle, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]int)
+	err = unpack.UnityBundleImages(rawBundle, func(img unpack.UnityImage) error {
+		dir := outputDir
+		if img.Container != "" {
+			dir = filepath.Join(outputDir, filepath.FromSlash(path.Dir(img.Container)))
+		}
+
+		imagePath := filepath.Join(dir, img.Name+".png")
+		if count, ok := written[imagePath]; ok {
+			written[imagePath] = count + 1
+			imagePath = filepath.Join(dir, fmt.Sprintf("%s_#%d.png", img.Name, count))
+		} else {
+			written[imagePath] = 1
+		}
+
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+
+		f, err := os.Create(imagePath)
+		if err != nil {
+			return err
+		}
+
+		if err := png.Encode(f, img.Image); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+
+	if errors.Is(err, unpack.ErrUnsupportedTextureFormat) {
+		count := 1
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			count = len(joined.Unwrap())
+		}
+		return &SkippedImagesError{Bundle: filepath.Base(inputPath), Count: count, Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("could not unpack %s: %w", filepath.Base(inputPath), err)
+	}
+
+	return nil
+}
+
+// marshalUnpacked writes data as JSON with NaN values replaced by null.
+func marshalUnpacked(data interface{}, path string, indent string) error {
+	var marshalledBytes []byte
+	var err error
+	if indent != "" {
+		marshalledBytes, err = jsnan.MarshalIndent(data, "", indent)
+	} else {
+		marshalledBytes, err = jsnan.Marshal(data)
+	}
+	if err != nil {
+		return err
+	}
+	marshalledBytes = bytes.Replace(marshalledBytes, []byte("NaN"), []byte("null"), -1)
+
+	return os.WriteFile(path, marshalledBytes, os.ModePerm)
+}
+
+// UnpackOptions controls where and how UnpackFile writes its output.
+type UnpackOptions struct {
+	DestDir string // defaults to the directory of the file
+	Indent  string // indentation of the JSON output, empty for compact JSON
+}
+
+// UnpackFile converts a downloaded game file to JSON or, for image bundles, to
+// png files in opts.DestDir. Supported are .d2o, .d2i, .bin (Dofus 3 i18n),
+// .bundle (Unity data) and .imagebundle (Unity images). Errors are of
+// KindUnpack unless reading or writing failed.
+func (c *Client) UnpackFile(ctx context.Context, file string, opts UnpackOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	destDir := opts.DestDir
+	if destDir == "" {
+		destDir = filepath.Dir(file)
+	}
+	indent := opts.Indent
+
+	suffix := strings.TrimPrefix(filepath.Ext(file), ".")
+
+	if suffix == "png" || suffix == "jpg" || suffix == "jpeg" {
+		return nil // no need to unpack images files
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		return NewError(KindIO, "unpack", err)
+	}
+
+	fileNoExt := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	absOutPath := filepath.Join(destDir, fileNoExt+".json")
+	op := "unpack " + filepath.Base(file)
+
+	switch suffix {
+	case "d2o":
+		f, err := os.Open(file)
+		if err != nil {
+			return NewError(KindIO, op, err)
+		}
+		defer f.Close()
+
+		reader, err := unpack.NewD2OReader(f)
+		if err != nil {
+			return NewError(KindUnpack, op, err)
+		}
+
+		objects, err := reader.GetObjects()
+		if err != nil {
+			return NewError(KindUnpack, op, err)
+		}
+
+		if err := marshalUnpacked(objects, absOutPath, indent); err != nil {
+			return NewError(KindIO, op, err)
+		}
+
+	case "d2i":
+		f, err := os.Open(file)
+		if err != nil {
+			return NewError(KindIO, op, err)
+		}
+		defer f.Close()
+
+		data := unpack.NewD2I(f).Read()
+
+		if err := marshalUnpacked(data, absOutPath, indent); err != nil {
+			return NewError(KindIO, op, err)
+		}
+
+	case "imagebundle":
+		if err := c.unpackUnityImages(file, destDir); err != nil {
+			return NewError(KindUnpack, op, err)
+		}
+
+	case "bundle":
+		if err := unpackUnityBundle(file, absOutPath); err != nil {
+			return NewError(KindUnpack, op, err)
+		}
+
+	case "bin":
+		rawData, err := os.ReadFile(file)
+		if err != nil {
+			return NewError(KindIO, op, err)
+		}
+
+		data, err := unpack.NewI18nFile(rawData)
+		if err != nil {
+			return NewError(KindUnpack, op, err)
+		}
+
+		if err := marshalUnpacked(data, absOutPath, indent); err != nil {
+			return NewError(KindIO, op, err)
+		}
+
+	default:
+		c.logger.Warnf("Unsupported file type for unpacking %s", suffix)
+	}
+
+	return nil
+}
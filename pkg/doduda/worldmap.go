@@ -0,0 +1,270 @@
+package doduda
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/dofusdude/doduda/unpack"
+)
+
+// DefaultTileSize is the tile size Leaflet uses by default.
+const DefaultTileSize = 256
+
+// worldmapTileName matches the sprites of a worldmap that is split into
+// tiles, like "worldmap1_3_7" for column 3 and row 7 of "worldmap1".
+var worldmapTileName = regexp.MustCompile(`^(.*?)[_-](\d+)[_-](\d+)$`)
+
+// ExtractMapData writes the cell data of the maps in a Dofus 3 map data
+// bundle to destDir/<map id>.json and returns the number of maps.
+func (c *Client) ExtractMapData(ctx context.Context, bundlePath string, destDir string, indent string) (int, error) {
+	op := "map data " + filepath.Base(bundlePath)
+
+	rawBundle, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return 0, NewError(KindIO, op, err)
+	}
+
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return 0, NewError(KindIO, op, err)
+	}
+
+	count := 0
+	err = unpack.UnityBundleMaps(rawBundle, func(m unpack.UnityMap) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		count++
+		if err := marshalUnpacked(m, filepath.Join(destDir, strconv.FormatInt(m.ID, 10)+".json"), indent); err != nil {
+			return NewError(KindIO, op, err)
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return count, NewError(KindCanceled, op, context.Cause(ctx))
+	}
+	if _, ok := err.(*Error); ok {
+		return count, err
+	}
+	if err != nil {
+		return count, NewError(KindUnpack, op, err)
+	}
+
+	return count, nil
+}
+
+// TilePyramid describes the tiles written by WriteTilePyramid. With Leaflet
+// use CRS.Simple, maxNativeZoom MaxZoom and bounds [[0, 0], [-Height,
+// Width]] scaled by 2^-MaxZoom.
+type TilePyramid struct {
+	Width    int `json:"width"`
+	Height   int `json:"height"`
+	TileSize int `json:"tile_size"`
+	MinZoom  int `json:"min_zoom"`
+	MaxZoom  int `json:"max_zoom"`
+}
+
+// ExtractWorldmaps stitches the sprites of a worldmap bundle into one image
+// per worldmap and writes each as XYZ tiles to destDir/<worldmap>/{z}/{x}/{y}.png
+// with a tiles.json that describes the pyramid. Sprites named
+// <worldmap>_<column>_<row> are placed on a grid, other sprites are a
+// worldmap of their own.
+func (c *Client) ExtractWorldmaps(ctx context.Context, bundlePath string, destDir string, tileSize int) error {
+	op := "worldmap " + filepath.Base(bundlePath)
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	rawBundle, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return NewError(KindIO, op, err)
+	}
+
+	type tile struct {
+		column, row int
+		image       *image.NRGBA
+	}
+	worldmaps := make(map[string][]tile)
+
+	err = unpack.UnityBundleImages(rawBundle, func(img unpack.UnityImage) error {
+		if match := worldmapTileName.FindStringSubmatch(img.Name); match != nil {
+			column, _ := strconv.Atoi(match[2])
+			row, _ := strconv.Atoi(match[3])
+			worldmaps[match[1]] = append(worldmaps[match[1]], tile{column, row, img.Image})
+		} else {
+			worldmaps[img.Name] = append(worldmaps[img.Name], tile{0, 0, img.Image})
+		}
+		return nil
+	})
+	if err != nil {
+		return NewError(KindUnpack, op, err)
+	}
+
+	names := make([]string, 0, len(worldmaps))
+	for name := range worldmaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return NewError(KindCanceled, op, context.Cause(ctx))
+		}
+
+		tiles := worldmaps[name]
+		var cellWidth, cellHeight int
+		for _, t := range tiles {
+			cellWidth = max(cellWidth, t.image.Bounds().Dx())
+			cellHeight = max(cellHeight, t.image.Bounds().Dy())
+		}
+
+		var width, height int
+		for _, t := range tiles {
+			width = max(width, t.column*cellWidth+t.image.Bounds().Dx())
+			height = max(height, t.row*cellHeight+t.image.Bounds().Dy())
+		}
+
+		stitched := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for _, t := range tiles {
+			at := image.Pt(t.column*cellWidth, t.row*cellHeight)
+			draw.Draw(stitched, t.image.Bounds().Sub(t.image.Bounds().Min).Add(at), t.image, t.image.Bounds().Min, draw.Src)
+		}
+		delete(worldmaps, name)
+
+		outDir := filepath.Join(destDir, name)
+		pyramid, err := WriteTilePyramid(ctx, stitched, outDir, tileSize)
+		if err != nil {
+			return NewError(KindIO, op, err)
+		}
+
+		if err := marshalUnpacked(pyramid, filepath.Join(outDir, "tiles.json"), "  "); err != nil {
+			return NewError(KindIO, op, err)
+		}
+	}
+
+	return nil
+}
+
+// WriteTilePyramid writes img as XYZ tiles to dir/{z}/{x}/{y}.png. The
+// highest zoom level shows img at its original size, each lower level halves
+// it until it fits into one tile. Tiles outside of the image or completely
+// transparent are not written.
+func WriteTilePyramid(ctx context.Context, img *image.NRGBA, dir string, tileSize int) (TilePyramid, error) {
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	pyramid := TilePyramid{
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+		TileSize: tileSize,
+	}
+	for side := tileSize; side < max(pyramid.Width, pyramid.Height); side *= 2 {
+		pyramid.MaxZoom++
+	}
+
+	level := img
+	for z := pyramid.MaxZoom; z >= pyramid.MinZoom; z-- {
+		bounds := level.Bounds()
+		for x := 0; x*tileSize < bounds.Dx(); x++ {
+			for y := 0; y*tileSize < bounds.Dy(); y++ {
+				if err := ctx.Err(); err != nil {
+					return pyramid, err
+				}
+
+				rect := image.Rect(x*tileSize, y*tileSize, (x+1)*tileSize, (y+1)*tileSize).Add(bounds.Min)
+				part := level.SubImage(rect).(*image.NRGBA)
+				if isTransparent(part) {
+					continue
+				}
+
+				tile := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+				draw.Draw(tile, part.Bounds().Sub(rect.Min), part, part.Bounds().Min, draw.Src)
+
+				tilePath := filepath.Join(dir, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png")
+				if err := writePNG(tile, tilePath); err != nil {
+					return pyramid, err
+				}
+			}
+		}
+
+		if z > pyramid.MinZoom {
+			level = halveImage(level)
+		}
+	}
+
+	return pyramid, nil
+}
+
+func writePNG(img image.Image, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func isTransparent(img *image.NRGBA) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// halveImage scales img down by 2 by averaging 2x2 pixels, weighted by their
+// alpha so transparent borders do not darken the edges.
+func halveImage(img *image.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, (bounds.Dx()+1)/2, (bounds.Dy()+1)/2))
+
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			var r, g, b, a, n uint32
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					sx, sy := bounds.Min.X+2*x+dx, bounds.Min.Y+2*y+dy
+					if sx >= bounds.Max.X || sy >= bounds.Max.Y {
+						continue
+					}
+					pix := img.Pix[img.PixOffset(sx, sy):]
+					alpha := uint32(pix[3])
+					r += uint32(pix[0]) * alpha
+					g += uint32(pix[1]) * alpha
+					b += uint32(pix[2]) * alpha
+					a += alpha
+					n++
+				}
+			}
+
+			out := dst.Pix[dst.PixOffset(x, y):]
+			if a > 0 {
+				out[0] = uint8(r / a)
+				out[1] = uint8(g / a)
+				out[2] = uint8(b / a)
+			}
+			out[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,128 @@
+package doduda
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func readTile(t *testing.T, dir string, z, x, y int) image.Image {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join(dir, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 256, 256) {
+		t.Fatalf("tile %d/%d/%d is %v, want 256x256", z, x, y, img.Bounds())
+	}
+	return img
+}
+
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+func TestWriteTilePyramid(t *testing.T) {
+	// 600x300 in gray, the top left tile transparent and one red pixel in
+	// the second tile
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			if x >= 256 || y >= 256 {
+				img.SetNRGBA(x, y, color.NRGBA{100, 100, 100, 255})
+			}
+		}
+	}
+	img.SetNRGBA(300, 10, color.NRGBA{255, 0, 0, 255})
+
+	dir := t.TempDir()
+	pyramid, err := WriteTilePyramid(context.Background(), img, dir, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (TilePyramid{Width: 600, Height: 300, TileSize: 256, MinZoom: 0, MaxZoom: 2}); pyramid != want {
+		t.Errorf("pyramid = %+v, want %+v", pyramid, want)
+	}
+
+	tiles := map[string]bool{}
+	err = filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		tiles[filepath.ToSlash(rel)] = true
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2/1/0.png", "2/2/0.png", "2/0/1.png", "2/1/1.png", "2/2/1.png", "1/0/0.png", "1/1/0.png", "0/0/0.png"}
+	if len(tiles) != len(want) {
+		t.Errorf("tiles = %v, want %v", tiles, want)
+	}
+	for _, name := range want {
+		if !tiles[name] {
+			t.Errorf("tile %s is missing", name)
+		}
+	}
+
+	if got := nrgbaAt(readTile(t, dir, 2, 1, 0), 300-256, 10); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("pixel (300, 10) in tile 2/1/0 is %v, want red", got)
+	}
+
+	// the last column is 88 pixels wide and padded with transparency
+	edge := readTile(t, dir, 2, 2, 1)
+	if got := nrgbaAt(edge, 87, 43); got.A != 255 {
+		t.Errorf("pixel (599, 299) in tile 2/2/1 is %v, want opaque", got)
+	}
+	if got := nrgbaAt(edge, 88, 0); got.A != 0 {
+		t.Errorf("pixel right of the image in tile 2/2/1 is %v, want transparent", got)
+	}
+	if got := nrgbaAt(edge, 0, 44); got.A != 0 {
+		t.Errorf("pixel below the image in tile 2/2/1 is %v, want transparent", got)
+	}
+}
+
+func TestWriteTilePyramidSmall(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+
+	pyramid, err := WriteTilePyramid(context.Background(), img, t.TempDir(), 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pyramid.MaxZoom != 0 {
+		t.Errorf("MaxZoom = %d, want 0 for an image smaller than a tile", pyramid.MaxZoom)
+	}
+}
+
+func TestHalveImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 0, 255, 0}) // transparent, its color must not count
+	img.SetNRGBA(2, 2, color.NRGBA{0, 255, 0, 255})
+
+	half := halveImage(img)
+	if half.Bounds() != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("bounds = %v, want 2x2", half.Bounds())
+	}
+	if got, want := half.NRGBAAt(0, 0), (color.NRGBA{255, 0, 0, 63}); got != want {
+		t.Errorf("pixel (0, 0) = %v, want %v", got, want)
+	}
+	// the last row and column only average the pixels inside the image
+	if got, want := half.NRGBAAt(1, 1), (color.NRGBA{0, 255, 0, 255}); got != want {
+		t.Errorf("pixel (1, 1) = %v, want %v", got, want)
+	}
+}
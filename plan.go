@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/dofusdude/ankabuffer"
+	"github.com/dofusdude/doduda/pkg/doduda"
+	"github.com/dofusdude/doduda/ui"
+)
+
+// PlanStage is a stage of a download, like the game data or one language.
+type PlanStage struct {
+	Title     string            `json:"title"`
+	Group     string            `json:"group,omitempty"` // selection rule group
+	Note      string            `json:"note,omitempty"`
+	Fragments []doduda.FilePlan `json:"fragments"`
+}
+
+// DownloadPlan is what Download would do with the same parameters. Bundles
+// needed by several stages are only counted once in the totals.
+type DownloadPlan struct {
+	Release          string      `json:"release"`
+	Platform         string      `json:"platform"`
+	GameVersion      string      `json:"game_version"`
+	BinSize          int         `json:"bin_size"` // megabytes, 0 or less is one bin
+	Stages           []PlanStage `json:"stages"`
+	Files            int         `json:"files"`
+	Unchanged        int         `json:"unchanged"`
+	Bundles          int         `json:"bundles"`
+	CompressedSize   int64       `json:"compressed_size"`
+	UncompressedSize int64       `json:"uncompressed_size"`
+	ContainerImages  []string    `json:"container_images"` // data and images are unpacked natively, only render runs containers
+}
+
+// PlanDownload returns the plan of Download for a resolved manifest without
+// downloading or writing anything.
+func PlanDownload(manifest *ankabuffer.Manifest, p DownloadParams, version int, state *IncrementalState) *DownloadPlan {
+	plan := &DownloadPlan{
+		Release:         p.Release,
+		Platform:        p.Platform,
+		GameVersion:     manifest.GameVersion,
+		BinSize:         p.Bin,
+		Stages:          []PlanStage{},
+		ContainerImages: []string{},
+	}
+	bundleSizes := doduda.BundleSizes(manifest)
+
+	planFiles := func(fragment string, files []HashFile) doduda.FilePlan {
+		return doduda.PlanFiles(manifest, files, doduda.DownloadOptions{Fragment: fragment, BinSize: p.Bin, State: state}, bundleSizes)
+	}
+
+	planGroup := func(title string, group string) {
+		stage := PlanStage{Title: title, Group: group, Fragments: []doduda.FilePlan{}}
+		selected := p.rules().Select(manifest, group, version)
+		for _, fragment := range sortedKeys(selected) {
+			stage.Fragments = append(stage.Fragments, planFiles(fragment, selected[fragment]))
+		}
+		plan.Stages = append(plan.Stages, stage)
+	}
+
+	if p.Full {
+		for _, fragment := range sortedKeys(manifest.Fragments) {
+			if len(p.Fragments) > 0 && !contains(p.Fragments, fragment) {
+				continue
+			}
+
+			var files []HashFile
+			for _, file := range manifest.Fragments[fragment].Files {
+				files = append(files, HashFile{Filename: file.Name, FriendlyName: file.Name, Hash: file.Hash})
+			}
+			sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+
+			plan.Stages = append(plan.Stages, PlanStage{Title: fragment, Fragments: []doduda.FilePlan{planFiles(fragment, files)}})
+		}
+	} else {
+		if !contains(p.Ignore, "languages") {
+			langs := p.Languages
+			if len(langs) == 0 {
+				langs = defaultLanguages(version)
+			}
+
+			for _, lang := range langs {
+				stage := PlanStage{Title: "Language " + lang, Fragments: []doduda.FilePlan{}}
+				fragment, langFile := languageFile(manifest, version, lang)
+				if _, ok := manifest.Fragments[fragment].Files[langFile.Filename]; ok {
+					stage.Fragments = append(stage.Fragments, planFiles(fragment, []HashFile{langFile}))
+				} else if version == 3 {
+					stage.Note = langFile.Filename + " is not in the manifest, the dofusdude language release is downloaded instead"
+				} else {
+					stage.Note = langFile.Filename + " is not in the manifest"
+				}
+				plan.Stages = append(plan.Stages, stage)
+			}
+		}
+
+		if !contains(p.Ignore, "data") {
+			planGroup("Data", "data")
+		}
+
+		if !contains(p.Ignore, "images") {
+			if version == 2 {
+				planGroup("Item Bitmaps", "item-bitmaps")
+				planGroup("Item Vectors", "item-vectors")
+			} else {
+				planGroup("Images", "images")
+				planGroup("UI Images", "ui-images")
+			}
+		}
+
+		if p.Maps {
+			if version == 2 {
+				planGroup("Maps", "maps")
+			} else {
+				planGroup("Map Data", "mapdata")
+				planGroup("Worldmaps", "worldmaps")
+			}
+		}
+	}
+
+	bundles := make(map[string]bool)
+	for _, stage := range plan.Stages {
+		for _, fragment := range stage.Fragments {
+			plan.Files += len(fragment.Files)
+			plan.Unchanged += fragment.Unchanged
+			plan.UncompressedSize += fragment.UncompressedSize
+			for _, file := range fragment.Files {
+				for _, bundle := range file.Bundles {
+					if !bundles[bundle] {
+						bundles[bundle] = true
+						plan.CompressedSize += bundleSizes[bundle]
+					}
+				}
+			}
+		}
+	}
+	plan.Bundles = len(bundles)
+
+	return plan
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatSize(size int64) string {
+	return humanFileSize(float64(size), false, 1)
+}
+
+// WriteJSON writes the plan as JSON, indented if indent is not empty.
+func (plan *DownloadPlan) WriteJSON(w io.Writer, indent string) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", indent)
+	return encoder.Encode(plan)
+}
+
+// WriteText writes the plan as tables, one per stage.
+func (plan *DownloadPlan) WriteText(w io.Writer) error {
+	bins := "one bin"
+	if plan.BinSize > 0 {
+		bins = fmt.Sprintf("bins of %d MB", plan.BinSize)
+	}
+	fmt.Fprintf(w, "%s %s %s, %s\n", plan.Release, plan.Platform, plan.GameVersion, ui.HelpStyle(bins))
+
+	for _, stage := range plan.Stages {
+		title := stage.Title
+		if stage.Group != "" {
+			title += " (" + stage.Group + ")"
+		}
+		fmt.Fprintf(w, "\n%s\n", ui.TitleStyle.Render(title))
+		if stage.Note != "" {
+			fmt.Fprintf(w, "  %s\n", ui.HelpStyle(stage.Note))
+		}
+		if len(stage.Fragments) == 0 && stage.Note == "" {
+			fmt.Fprintf(w, "  %s\n", ui.HelpStyle("no files selected"))
+		}
+
+		for _, fragment := range stage.Fragments {
+			summary := fmt.Sprintf("%d files, %d bundles, %s compressed, %s uncompressed", len(fragment.Files), fragment.Bundles, formatSize(fragment.CompressedSize), formatSize(fragment.UncompressedSize))
+			if fragment.Unchanged > 0 {
+				summary += fmt.Sprintf(", %d unchanged", fragment.Unchanged)
+			}
+			fmt.Fprintf(w, "  %s %s\n", fragment.Fragment, ui.HelpStyle(summary))
+
+			table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			if len(fragment.Files) > 0 {
+				fmt.Fprintln(table, "    FILE\tNAME\tSIZE\tBUNDLES\t")
+			}
+			for _, file := range fragment.Files {
+				fmt.Fprintf(table, "    %s\t%s\t%s\t%d\t\n", file.Name, file.FriendlyName, formatSize(file.Size), len(file.Bundles))
+			}
+			for i, bin := range fragment.Bins {
+				fmt.Fprintf(table, "    bin %d/%d\t%d files\t%s\t%d (%s)\t\n", i+1, len(fragment.Bins), bin.Files, formatSize(bin.UncompressedSize), len(bin.Bundles), formatSize(bin.CompressedSize))
+			}
+			if err := table.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	total := fmt.Sprintf("%d files, %d bundles, %s compressed, %s uncompressed", plan.Files, plan.Bundles, formatSize(plan.CompressedSize), formatSize(plan.UncompressedSize))
+	if plan.Unchanged > 0 {
+		total += fmt.Sprintf(", %d unchanged", plan.Unchanged)
+	}
+	fmt.Fprintf(w, "\n%s %s\n", ui.TitleStyle.Render("Total"), total)
+
+	images := "none"
+	if len(plan.ContainerImages) > 0 {
+		images = fmt.Sprint(plan.ContainerImages)
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", ui.TitleStyle.Render("Container images"), images)
+	return err
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -15,13 +14,14 @@ import (
 	"sync"
 
 	"github.com/charmbracelet/log"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
+	"github.com/dofusdude/doduda/pkg/doduda"
 	"github.com/dofusdude/doduda/ui"
 )
 
-func Render(inputDir string, outputDir string, incrementalParts []string, resolution int, headless bool) error {
+// Render converts the .swf files of inputDir to png files of the given
+// resolution with the swf-to-svg and svg-to-png tools. Errors of the tool
+// runtime are of KindContainer.
+func Render(ctx context.Context, client *doduda.Client, inputDir string, outputDir string, incrementalParts []string, resolution int, runtimeName string, headless bool) error {
 	updateChan := make(chan string)
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -33,33 +33,25 @@ func Render(inputDir string, outputDir string, incrementalParts []string, resolu
 		}
 	}()
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	toolRuntime, err := NewToolRuntime(runtimeName)
 	if err != nil {
-		log.Fatal(err)
+		return newError(KindContainer, "render", err)
 	}
-	defer cli.Close()
+	defer toolRuntime.Close()
 
 	if isChannelClosed(updateChan) {
-		os.Exit(1)
+		return errCanceledByUser
 	}
 	updateChan <- "Pulling image"
 
-	ctx := context.Background()
-	swfToSvg, err := cli.ImagePull(ctx, "stelzo/swf-to-svg", image.PullOptions{})
+	err = toolRuntime.Pull(ctx, []string{"stelzo/swf-to-svg", "stelzo/svg-to-png"})
 	if err != nil {
-		log.Fatal(err)
+		return newError(KindContainer, "pull render tools", err)
 	}
-	swfToSvg.Close()
-
-	svgToPng, err := cli.ImagePull(ctx, "stelzo/svg-to-png", image.PullOptions{})
-	if err != nil {
-		log.Fatal(err)
-	}
-	svgToPng.Close()
 
 	swfFiles, err := os.ReadDir(inputDir)
 	if err != nil {
-		log.Fatal(err)
+		return newError(KindIO, "render", err)
 	}
 
 	if len(incrementalParts) != 0 {
@@ -68,24 +60,25 @@ func Render(inputDir string, outputDir string, incrementalParts []string, resolu
 		filename := incrementalParts[2] + ".tar.gz"
 
 		if isChannelClosed(updateChan) {
-			os.Exit(1)
+			return errCanceledByUser
 		}
 		updateChan <- "Checking latest release"
 
-		releaseApiResponse, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", owner, repo))
+		releaseApiResponse, err := client.Get(ctx, fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", owner, repo))
 		if err != nil {
-			return err
+			return newError(KindNetwork, "incremental release", err)
 		}
+		defer releaseApiResponse.Body.Close()
 
 		releaseApiResponseBody, err := io.ReadAll(releaseApiResponse.Body)
 		if err != nil {
-			return err
+			return newError(KindNetwork, "incremental release", err)
 		}
 
 		var v map[string]interface{}
 		err = json.Unmarshal(releaseApiResponseBody, &v)
 		if err != nil {
-			return err
+			return newError(KindNetwork, "incremental release", err)
 		}
 
 		assets := v["assets"].([]interface{})
@@ -97,24 +90,25 @@ func Render(inputDir string, outputDir string, incrementalParts []string, resolu
 				assetUrl := assetMap["browser_download_url"].(string)
 
 				if isChannelClosed(updateChan) {
-					os.Exit(1)
+					return errCanceledByUser
 				}
 				updateChan <- "loading latest " + filename
 
-				imagesResponse, err := http.Get(assetUrl)
+				imagesResponse, err := client.Get(ctx, assetUrl)
 				if err != nil {
-					log.Fatal(err)
+					return newError(KindNetwork, "incremental release", err)
 				}
 
 				err = ExtractTarGz("", imagesResponse.Body)
+				imagesResponse.Body.Close()
 				if err != nil {
-					return err
+					return newError(KindIO, "incremental release", err)
 				}
 			}
 		}
 
 		if !found {
-			log.Fatal("Could not find the specified file in the latest release")
+			return newError(KindVersionNotFound, "incremental release", fmt.Errorf("%s is not in the latest release of %s/%s", filename, owner, repo))
 		}
 	}
 
@@ -152,69 +146,26 @@ func Render(inputDir string, outputDir string, incrementalParts []string, resolu
 
 		mountPath := filepath.Dir(absInputPath)
 
-		cmd := []string{
-			filepath.Join("data", swfFile.Name()),
-			filepath.Join("data", svgFileName),
-		}
-
-		resp, err := cli.ContainerCreate(ctx, &container.Config{
-			Image: "stelzo/swf-to-svg",
-			Cmd:   cmd,
-			Volumes: map[string]struct{}{
-				"/app/data": {},
-			},
-		}, &container.HostConfig{
-			Binds:      []string{fmt.Sprintf("%s:/app/data", mountPath)},
-			AutoRemove: true,
-		}, nil, nil, "")
+		err = toolRuntime.Run(ctx, ToolJob{
+			Image:   "stelzo/swf-to-svg",
+			DataDir: mountPath,
+			Files:   []string{swfFile.Name(), svgFileName},
+		})
 		if err != nil {
-			log.Fatal(err)
+			os.Remove(filepath.Join(mountPath, svgFileName))
+			return newError(KindContainer, "render "+swfFile.Name(), err)
 		}
 
-		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-			return err
-		}
-
-		statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-		select {
-		case err := <-errCh:
-			if err != nil {
-				return err
-			}
-		case <-statusCh:
-		}
-
-		cmd = []string{
-			filepath.Join("data", svgFileName),
-			filepath.Join("data", resultFileName),
-			strconv.Itoa(resolution),
-		}
-
-		resp, err = cli.ContainerCreate(ctx, &container.Config{
-			Image: "stelzo/svg-to-png",
-			Cmd:   cmd,
-			Volumes: map[string]struct{}{
-				"/app/data": {},
-			},
-		}, &container.HostConfig{
-			Binds:      []string{fmt.Sprintf("%s:/app/data", mountPath)},
-			AutoRemove: true,
-		}, nil, nil, "")
+		err = toolRuntime.Run(ctx, ToolJob{
+			Image:   "stelzo/svg-to-png",
+			DataDir: mountPath,
+			Files:   []string{svgFileName, resultFileName},
+			Args:    []string{strconv.Itoa(resolution)},
+		})
 		if err != nil {
-			log.Fatal(err)
-		}
-
-		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-			return err
-		}
-
-		statusCh, errCh = cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-		select {
-		case err := <-errCh:
-			if err != nil {
-				return err
-			}
-		case <-statusCh:
+			os.Remove(filepath.Join(mountPath, svgFileName))
+			os.Remove(tmpOutputPath)
+			return newError(KindContainer, "render "+swfFile.Name(), err)
 		}
 
 		err = os.Rename(tmpOutputPath, absOutputPath)
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// ToolRuntimes lists the names accepted by NewToolRuntime.
+var ToolRuntimes = []string{"docker", "podman", "nerdctl", "local"}
+
+// ToolJob is one invocation of a helper tool like stelzo/swf-to-svg.
+type ToolJob struct {
+	Image   string
+	DataDir string   // host directory with the input and output files
+	Files   []string // file arguments, relative to DataDir
+	Args    []string // further arguments after the files
+}
+
+// ToolRuntime runs the helper tools that doduda does not implement natively.
+type ToolRuntime interface {
+	// Pull makes the images of the tools available before the first Run.
+	Pull(ctx context.Context, images []string) error
+	Run(ctx context.Context, job ToolJob) error
+	Close() error
+}
+
+// NewToolRuntime creates the runtime with the given name.
+//
+//   - docker: Docker Engine API, configured with DOCKER_HOST and friends.
+//   - podman: Docker compatible API of (rootless) Podman. Uses CONTAINER_HOST
+//     or the socket of the current user.
+//   - nerdctl: the nerdctl command line.
+//   - local: tools installed on the host, named like the image without owner.
+func NewToolRuntime(name string) (ToolRuntime, error) {
+	switch name {
+	case "docker":
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, err
+		}
+		return &apiRuntime{cli: cli}, nil
+	case "podman":
+		host := os.Getenv("CONTAINER_HOST")
+		if host == "" {
+			host = podmanSocket()
+		}
+		cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, err
+		}
+		return &apiRuntime{cli: cli}, nil
+	case "nerdctl":
+		if _, err := exec.LookPath("nerdctl"); err != nil {
+			return nil, err
+		}
+		return &cliRuntime{binary: "nerdctl"}, nil
+	case "local":
+		return &localRuntime{}, nil
+	default:
+		return nil, fmt.Errorf("unknown runtime %s, available: %s", name, strings.Join(ToolRuntimes, ", "))
+	}
+}
+
+func podmanSocket() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+	}
+	if os.Getuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	return fmt.Sprintf("unix:///run/user/%d/podman/podman.sock", os.Getuid())
+}
+
+// containerCmd mounts the data dir to /app/data, the working directory of the
+// tool images is /app.
+func containerCmd(job ToolJob) []string {
+	var cmd []string
+	for _, file := range job.Files {
+		cmd = append(cmd, filepath.Join("data", file))
+	}
+	return append(cmd, job.Args...)
+}
+
+type apiRuntime struct {
+	cli *client.Client
+}
+
+func (r *apiRuntime) Pull(ctx context.Context, images []string) error {
+	for _, imageRef := range images {
+		reader, err := r.cli.ImagePull(ctx, imageRef, image.PullOptions{})
+		if err != nil {
+			return err
+		}
+		// the pull only finishes when the progress stream is consumed
+		_, err = io.Copy(io.Discard, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *apiRuntime) Run(ctx context.Context, job ToolJob) error {
+	resp, err := r.cli.ContainerCreate(ctx, &container.Config{
+		Image: job.Image,
+		Cmd:   containerCmd(job),
+		Volumes: map[string]struct{}{
+			"/app/data": {},
+		},
+	}, &container.HostConfig{
+		Binds:      []string{fmt.Sprintf("%s:/app/data", job.DataDir)},
+		AutoRemove: true,
+	}, nil, nil, "")
+	if err != nil {
+		return err
+	}
+
+	if err := r.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+
+	statusCh, errCh := r.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("%s exited with status %d", job.Image, status.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+func (r *apiRuntime) Close() error {
+	return r.cli.Close()
+}
+
+// cliRuntime runs the tools with a docker compatible command line.
+type cliRuntime struct {
+	binary string
+}
+
+func (r *cliRuntime) Pull(ctx context.Context, images []string) error {
+	for _, imageRef := range images {
+		out, err := exec.CommandContext(ctx, r.binary, "pull", "--quiet", imageRef).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s pull %s: %w: %s", r.binary, imageRef, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+func (r *cliRuntime) Run(ctx context.Context, job ToolJob) error {
+	args := []string{"run", "--rm", "-v", fmt.Sprintf("%s:/app/data", job.DataDir), job.Image}
+	args = append(args, containerCmd(job)...)
+
+	out, err := exec.CommandContext(ctx, r.binary, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", job.Image, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (r *cliRuntime) Close() error {
+	return nil
+}
+
+// localRuntime runs the tools installed on the host inside the data dir.
+type localRuntime struct{}
+
+func localToolName(imageRef string) string {
+	name := path.Base(imageRef)
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func (r *localRuntime) Pull(ctx context.Context, images []string) error {
+	for _, imageRef := range images {
+		if _, err := exec.LookPath(localToolName(imageRef)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *localRuntime) Run(ctx context.Context, job ToolJob) error {
+	cmd := exec.CommandContext(ctx, localToolName(job.Image), append(job.Files, job.Args...)...)
+	cmd.Dir = job.DataDir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", localToolName(job.Image), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (r *localRuntime) Close() error {
+	return nil
+}
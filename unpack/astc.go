@@ -0,0 +1,633 @@
+package unpack
+
+import "math/bits"
+
+// ASTC blocks are decoded to 8 bit LDR colors. HDR endpoints, reserved block
+// modes and other invalid encodings decode to the error color magenta, like
+// GPUs do.
+
+var astcErrorColor = [4]uint8{255, 0, 255, 255}
+
+// astcWeightLevels are the weight ranges by block mode, (R - 2) + 6*H.
+var astcWeightLevels = [12]int{2, 3, 4, 5, 6, 8, 10, 12, 16, 20, 24, 32}
+
+// astcColorLevels are the endpoint ranges, the largest one that fits is used.
+var astcColorLevels = []int{256, 192, 160, 128, 96, 80, 64, 48, 40, 32, 24, 20, 16, 12, 10, 8, 6}
+
+// astcISE returns how values below levels are encoded in the integer
+// sequence encoding: bits low bits plus one trit or one quint.
+func astcISE(levels int) (count int, trits bool, quints bool) {
+	switch {
+	case levels%3 == 0:
+		return bits.Len(uint(levels/3)) - 1, true, false
+	case levels%5 == 0:
+		return bits.Len(uint(levels/5)) - 1, false, true
+	}
+	return bits.Len(uint(levels)) - 1, false, false
+}
+
+// astcISESize returns the number of bits of count values below levels.
+func astcISESize(count int, levels int) int {
+	n, trits, quints := astcISE(levels)
+	switch {
+	case trits:
+		return (8*count+4)/5 + count*n
+	case quints:
+		return (7*count+2)/3 + count*n
+	}
+	return count * n
+}
+
+// astcDecodeISE reads count values below levels starting at bit start. Bits
+// past the encoded sequence read as zero.
+func astcDecodeISE(b *blockBits, start int, count int, levels int) []int {
+	n, trits, quints := astcISE(levels)
+	end := start + astcISESize(count, levels)
+	b.pos = start
+	read := func(size int) int {
+		value := 0
+		for i := 0; i < size; i++ {
+			if b.pos < end {
+				value |= b.at(b.pos, 1) << uint(i)
+			}
+			b.pos++
+		}
+		return value
+	}
+
+	values := make([]int, 0, count+4)
+	for len(values) < count {
+		switch {
+		case trits:
+			var m [5]int
+			var t int
+			m[0] = read(n)
+			t |= read(2)
+			m[1] = read(n)
+			t |= read(2) << 2
+			m[2] = read(n)
+			t |= read(1) << 4
+			m[3] = read(n)
+			t |= read(2) << 5
+			m[4] = read(n)
+			t |= read(1) << 7
+			for i, trit := range astcTrits(t) {
+				values = append(values, trit<<uint(n)|m[i])
+			}
+		case quints:
+			var m [3]int
+			var q int
+			m[0] = read(n)
+			q |= read(3)
+			m[1] = read(n)
+			q |= read(2) << 3
+			m[2] = read(n)
+			q |= read(2) << 5
+			for i, quint := range astcQuints(q) {
+				values = append(values, quint<<uint(n)|m[i])
+			}
+		default:
+			values = append(values, read(n))
+		}
+	}
+	return values[:count]
+}
+
+func bit(value int, i int) int {
+	return value >> uint(i) & 1
+}
+
+// astcTrits unpacks the 8 bit trit block of the integer sequence encoding.
+func astcTrits(t int) [5]int {
+	var c, t3, t4 int
+	if t>>2&7 == 7 {
+		c = t>>5&7<<2 | t&3
+		t4, t3 = 2, 2
+	} else {
+		c = t & 0x1f
+		if t>>5&3 == 3 {
+			t4, t3 = 2, bit(t, 7)
+		} else {
+			t4, t3 = bit(t, 7), t>>5&3
+		}
+	}
+
+	var t0, t1, t2 int
+	switch {
+	case c&3 == 3:
+		t2, t1 = 2, bit(c, 4)
+		t0 = bit(c, 3)<<1 | bit(c, 2)&^bit(c, 3)
+	case c>>2&3 == 3:
+		t2, t1 = 2, 2
+		t0 = c & 3
+	default:
+		t2, t1 = bit(c, 4), c>>2&3
+		t0 = bit(c, 1)<<1 | bit(c, 0)&^bit(c, 1)
+	}
+	return [5]int{t0, t1, t2, t3, t4}
+}
+
+// astcQuints unpacks the 7 bit quint block of the integer sequence encoding.
+func astcQuints(q int) [3]int {
+	if q>>1&3 == 3 && q>>5&3 == 0 {
+		return [3]int{4, 4, bit(q, 0)<<2 | (bit(q, 4)&^bit(q, 0))<<1 | bit(q, 3)&^bit(q, 0)}
+	}
+
+	var c, q2 int
+	if q>>1&3 == 3 {
+		q2 = 4
+		c = q>>3&3<<3 | (^q>>5&3)<<1 | q&1
+	} else {
+		q2 = q >> 5 & 3
+		c = q & 0x1f
+	}
+
+	if c&7 == 5 {
+		return [3]int{c >> 3 & 3, 4, q2}
+	}
+	return [3]int{c & 7, c >> 3 & 3, q2}
+}
+
+// replicateBits scales a value of from bits to to bits by repeating it.
+func replicateBits(value int, from int, to int) int {
+	if from == 0 {
+		return 0
+	}
+	result := 0
+	for shift := to - from; shift > -from; shift -= from {
+		if shift >= 0 {
+			result |= value << uint(shift)
+		} else {
+			result |= value >> uint(-shift)
+		}
+	}
+	return result & (1<<uint(to) - 1)
+}
+
+// astcUnquantizeColor scales an endpoint value below levels to 0..255.
+func astcUnquantizeColor(value int, levels int) int {
+	n, trits, quints := astcISE(levels)
+	if !trits && !quints {
+		return replicateBits(value, n, 8)
+	}
+
+	d := value >> uint(n)
+	m := value & (1<<uint(n) - 1)
+	a := 0
+	if m&1 != 0 {
+		a = 0x1ff
+	}
+	high := m >> 1
+
+	var b, c int
+	switch {
+	case trits && n == 1:
+		c = 204
+	case quints && n == 1:
+		c = 113
+	case trits && n == 2:
+		b, c = high<<8|high<<4|high<<2|high<<1, 93
+	case quints && n == 2:
+		b, c = high<<8|high<<3|high<<2, 54
+	case trits && n == 3:
+		b, c = high<<7|high<<2|high, 44
+	case quints && n == 3:
+		b, c = high<<7|high<<1|high>>1, 26
+	case trits && n == 4:
+		b, c = high<<6|high, 22
+	case quints && n == 4:
+		b, c = high<<6|high>>1, 13
+	case trits && n == 5:
+		b, c = high<<5|high>>2, 11
+	case quints && n == 5:
+		b, c = high<<5|high>>3, 6
+	case trits && n == 6:
+		b, c = high<<4|high>>4, 5
+	}
+
+	t := (d*c + b) ^ a
+	return a&0x80 | t>>2
+}
+
+// astcUnquantizeWeight scales a weight below levels to 0..64.
+func astcUnquantizeWeight(value int, levels int) int {
+	n, trits, quints := astcISE(levels)
+
+	var w int
+	switch {
+	case !trits && !quints:
+		w = replicateBits(value, n, 6)
+	case n == 0 && trits:
+		w = [3]int{0, 32, 63}[value]
+	case n == 0 && quints:
+		w = [5]int{0, 16, 32, 47, 63}[value]
+	default:
+		d := value >> uint(n)
+		m := value & (1<<uint(n) - 1)
+		a := 0
+		if m&1 != 0 {
+			a = 0x7f
+		}
+		high := m >> 1
+
+		var b, c int
+		switch {
+		case trits && n == 1:
+			c = 50
+		case quints && n == 1:
+			c = 28
+		case trits && n == 2:
+			b, c = high<<6|high<<2|high, 23
+		case quints && n == 2:
+			b, c = high<<6|high<<1, 13
+		case trits && n == 3:
+			b, c = high<<5|high, 11
+		}
+
+		t := (d*c + b) ^ a
+		w = a&0x20 | t>>2
+	}
+
+	if w > 32 {
+		w++
+	}
+	return w
+}
+
+// astcBlockMode decodes the 11 bit block mode into the weight grid size, the
+// weight range and whether the block has two weight planes.
+func astcBlockMode(mode int) (gridWidth int, gridHeight int, levels int, dualPlane bool, ok bool) {
+	var r, a, b int
+	high := bit(mode, 9)
+	dual := bit(mode, 10)
+
+	if mode&3 != 0 {
+		r = bit(mode, 4) | (mode&3)<<1
+		a, b = mode>>5&3, mode>>7&3
+		switch mode >> 2 & 3 {
+		case 0:
+			gridWidth, gridHeight = b+4, a+2
+		case 1:
+			gridWidth, gridHeight = b+8, a+2
+		case 2:
+			gridWidth, gridHeight = a+2, b+8
+		case 3:
+			if bit(mode, 8) == 0 {
+				gridWidth, gridHeight = a+2, bit(mode, 7)+6
+			} else {
+				gridWidth, gridHeight = bit(mode, 7)+2, a+2
+			}
+		}
+	} else {
+		if mode&0xf == 0 {
+			return 0, 0, 0, false, false
+		}
+		r = bit(mode, 4) | (mode>>2&3)<<1
+		a, b = mode>>5&3, mode>>9&3
+		switch mode >> 7 & 3 {
+		case 0:
+			gridWidth, gridHeight = 12, a+2
+		case 1:
+			gridWidth, gridHeight = a+2, 12
+		case 2:
+			gridWidth, gridHeight = a+6, b+6
+			high, dual = 0, 0
+		case 3:
+			switch a {
+			case 0:
+				gridWidth, gridHeight = 6, 10
+			case 1:
+				gridWidth, gridHeight = 10, 6
+			default:
+				return 0, 0, 0, false, false
+			}
+		}
+	}
+
+	if r < 2 {
+		return 0, 0, 0, false, false
+	}
+	return gridWidth, gridHeight, astcWeightLevels[r-2+6*high], dual == 1, true
+}
+
+// astcHash52 is the hash of the ASTC partition function.
+func astcHash52(p uint32) uint32 {
+	p ^= p >> 15
+	p -= p << 17
+	p += p << 7
+	p += p << 4
+	p ^= p >> 5
+	p += p << 16
+	p ^= p >> 7
+	p ^= p >> 3
+	p ^= p << 6
+	p ^= p >> 17
+	return p
+}
+
+// astcPartition returns the partition of texel (x, y) for a partition seed.
+func astcPartition(seed int, x int, y int, count int, small bool) int {
+	if count == 1 {
+		return 0
+	}
+	if small {
+		x <<= 1
+		y <<= 1
+	}
+
+	rnum := astcHash52(uint32(seed + (count-1)*1024))
+
+	var seeds [8]int
+	for i := range seeds {
+		s := int(rnum >> uint(4*i) & 0xf)
+		seeds[i] = s * s
+	}
+
+	sh1, sh2 := uint(5), uint(5)
+	if seed&1 != 0 {
+		if seed&2 != 0 {
+			sh1 = 4
+		}
+		if count == 3 {
+			sh2 = 6
+		}
+	} else {
+		if count == 3 {
+			sh1 = 6
+		}
+		if seed&2 != 0 {
+			sh2 = 4
+		}
+	}
+
+	for i := range seeds {
+		if i%2 == 0 {
+			seeds[i] >>= sh1
+		} else {
+			seeds[i] >>= sh2
+		}
+	}
+
+	// the z terms of 3D textures are left out
+	a := (seeds[0]*x + seeds[1]*y + int(rnum>>14)) & 0x3f
+	b := (seeds[2]*x + seeds[3]*y + int(rnum>>10)) & 0x3f
+	c := (seeds[4]*x + seeds[5]*y + int(rnum>>6)) & 0x3f
+	d := (seeds[6]*x + seeds[7]*y + int(rnum>>2)) & 0x3f
+	if count < 4 {
+		d = 0
+	}
+	if count < 3 {
+		c = 0
+	}
+
+	switch {
+	case a >= b && a >= c && a >= d:
+		return 0
+	case b >= c && b >= d:
+		return 1
+	case c >= d:
+		return 2
+	}
+	return 3
+}
+
+// astcBitTransferSigned moves the top bit of b into a and turns b into a
+// signed offset.
+func astcBitTransferSigned(a int, b int) (int, int) {
+	a = a>>1 | b&0x80
+	b = b >> 1 & 0x3f
+	if b&0x20 != 0 {
+		b -= 0x40
+	}
+	return a, b
+}
+
+func astcBlueContract(r int, g int, b int, a int) [4]int {
+	return [4]int{(r + b) >> 1, (g + b) >> 1, b, a}
+}
+
+func clampEndpoint(e [4]int) [4]int {
+	for i := range e {
+		e[i] = int(clampColor(e[i]))
+	}
+	return e
+}
+
+// astcEndpoints decodes the endpoints of a color endpoint mode. HDR modes are
+// not supported.
+func astcEndpoints(mode int, v []int) ([4]int, [4]int, bool) {
+	switch mode {
+	case 0:
+		return [4]int{v[0], v[0], v[0], 255}, [4]int{v[1], v[1], v[1], 255}, true
+	case 1:
+		l0 := v[0]>>2 | v[1]&0xc0
+		l1 := min(l0+v[1]&0x3f, 255)
+		return [4]int{l0, l0, l0, 255}, [4]int{l1, l1, l1, 255}, true
+	case 4:
+		return [4]int{v[0], v[0], v[0], v[2]}, [4]int{v[1], v[1], v[1], v[3]}, true
+	case 5:
+		l, dl := astcBitTransferSigned(v[0], v[1])
+		a, da := astcBitTransferSigned(v[2], v[3])
+		return clampEndpoint([4]int{l, l, l, a}), clampEndpoint([4]int{l + dl, l + dl, l + dl, a + da}), true
+	case 6:
+		return [4]int{v[0] * v[3] >> 8, v[1] * v[3] >> 8, v[2] * v[3] >> 8, 255}, [4]int{v[0], v[1], v[2], 255}, true
+	case 8, 12:
+		a0, a1 := 255, 255
+		if mode == 12 {
+			a0, a1 = v[6], v[7]
+		}
+		if v[1]+v[3]+v[5] >= v[0]+v[2]+v[4] {
+			return [4]int{v[0], v[2], v[4], a0}, [4]int{v[1], v[3], v[5], a1}, true
+		}
+		return astcBlueContract(v[1], v[3], v[5], a1), astcBlueContract(v[0], v[2], v[4], a0), true
+	case 9, 13:
+		r, dr := astcBitTransferSigned(v[0], v[1])
+		g, dg := astcBitTransferSigned(v[2], v[3])
+		b, db := astcBitTransferSigned(v[4], v[5])
+		a, da := 255, 0
+		if mode == 13 {
+			a, da = astcBitTransferSigned(v[6], v[7])
+		}
+		if dr+dg+db >= 0 {
+			return clampEndpoint([4]int{r, g, b, a}), clampEndpoint([4]int{r + dr, g + dg, b + db, a + da}), true
+		}
+		return clampEndpoint(astcBlueContract(r+dr, g+dg, b+db, a+da)), clampEndpoint(astcBlueContract(r, g, b, a)), true
+	case 10:
+		return [4]int{v[0] * v[3] >> 8, v[1] * v[3] >> 8, v[2] * v[3] >> 8, v[4]}, [4]int{v[0], v[1], v[2], v[5]}, true
+	}
+	return [4]int{}, [4]int{}, false
+}
+
+// astcInfillWeights interpolates the weights of a grid to one per texel.
+// stride is 2 for blocks with two weight planes.
+func astcInfillWeights(weights []int, plane int, stride int, gridWidth int, gridHeight int, blockWidth int, blockHeight int) []int {
+	texels := make([]int, blockWidth*blockHeight)
+	ds := (1024 + blockWidth/2) / (blockWidth - 1)
+	dt := (1024 + blockHeight/2) / (blockHeight - 1)
+
+	weight := func(x int, y int) int {
+		if x >= gridWidth || y >= gridHeight {
+			return 0
+		}
+		return weights[(y*gridWidth+x)*stride+plane]
+	}
+
+	for t := 0; t < blockHeight; t++ {
+		for s := 0; s < blockWidth; s++ {
+			gs := (ds*s*(gridWidth-1) + 32) >> 6
+			gt := (dt*t*(gridHeight-1) + 32) >> 6
+			js, fs := gs>>4, gs&0xf
+			jt, ft := gt>>4, gt&0xf
+
+			w11 := (fs*ft + 8) >> 4
+			w10 := ft - w11
+			w01 := fs - w11
+			w00 := 16 - fs - ft + w11
+
+			texels[t*blockWidth+s] = (weight(js, jt)*w00 + weight(js+1, jt)*w01 + weight(js, jt+1)*w10 + weight(js+1, jt+1)*w11 + 8) >> 4
+		}
+	}
+	return texels
+}
+
+// decodeASTCBlock decodes an ASTC block of blockWidth x blockHeight texels.
+func decodeASTCBlock(block []byte, blockWidth int, blockHeight int, out [][4]uint8) {
+	fail := func() {
+		for i := range out {
+			out[i] = astcErrorColor
+		}
+	}
+
+	b := newBlockBits(block)
+	mode := b.at(0, 11)
+
+	if mode&0x1ff == 0x1fc {
+		// void extent, one color for the whole block
+		if bit(mode, 9) != 0 {
+			fail()
+			return
+		}
+		color := [4]uint8{uint8(b.at(64+8, 8)), uint8(b.at(80+8, 8)), uint8(b.at(96+8, 8)), uint8(b.at(112+8, 8))}
+		for i := range out {
+			out[i] = color
+		}
+		return
+	}
+
+	gridWidth, gridHeight, weightLevels, dualPlane, ok := astcBlockMode(mode)
+	if !ok || gridWidth > blockWidth || gridHeight > blockHeight {
+		fail()
+		return
+	}
+
+	partitions := b.at(11, 2) + 1
+	planes := 1
+	if dualPlane {
+		planes = 2
+	}
+	weightCount := gridWidth * gridHeight * planes
+	weightBits := astcISESize(weightCount, weightLevels)
+	if weightCount > 64 || weightBits < 24 || weightBits > 96 || (dualPlane && partitions == 4) {
+		fail()
+		return
+	}
+
+	belowWeights := 128 - weightBits
+	colorStart := 17
+	var endpointModes [4]int
+	partitionSeed := 0
+	if partitions == 1 {
+		endpointModes[0] = b.at(13, 4)
+	} else {
+		colorStart = 29
+		partitionSeed = b.at(13, 10)
+		encoded := b.at(23, 6)
+		if encoded&3 == 0 {
+			for i := 0; i < partitions; i++ {
+				endpointModes[i] = encoded >> 2
+			}
+		} else {
+			extra := 3*partitions - 4
+			belowWeights -= extra
+			encoded |= b.at(belowWeights, extra) << 6
+			base := encoded&3 - 1
+			for i := 0; i < partitions; i++ {
+				endpointModes[i] = (bit(encoded, 2+i)+base)<<2 | encoded>>uint(2+partitions+2*i)&3
+			}
+		}
+	}
+
+	plane2Component := -1
+	if dualPlane {
+		belowWeights -= 2
+		plane2Component = b.at(belowWeights, 2)
+	}
+
+	colorCount := 0
+	for i := 0; i < partitions; i++ {
+		colorCount += (endpointModes[i]>>2 + 1) * 2
+	}
+	if colorCount > 18 {
+		fail()
+		return
+	}
+
+	colorLevels := 0
+	for _, levels := range astcColorLevels {
+		if astcISESize(colorCount, levels) <= belowWeights-colorStart {
+			colorLevels = levels
+			break
+		}
+	}
+	if colorLevels == 0 {
+		fail()
+		return
+	}
+
+	colors := astcDecodeISE(b, colorStart, colorCount, colorLevels)
+	for i := range colors {
+		colors[i] = astcUnquantizeColor(colors[i], colorLevels)
+	}
+
+	var endpoints [4][2][4]int
+	for i := 0; i < partitions; i++ {
+		count := (endpointModes[i]>>2 + 1) * 2
+		e0, e1, ok := astcEndpoints(endpointModes[i], colors[:count])
+		if !ok {
+			fail()
+			return
+		}
+		endpoints[i] = [2][4]int{e0, e1}
+		colors = colors[count:]
+	}
+
+	// the weights are stored backwards from the end of the block
+	reversed := &blockBits{lo: bits.Reverse64(b.hi), hi: bits.Reverse64(b.lo)}
+	weights := astcDecodeISE(reversed, 0, weightCount, weightLevels)
+	for i := range weights {
+		weights[i] = astcUnquantizeWeight(weights[i], weightLevels)
+	}
+
+	plane1 := astcInfillWeights(weights, 0, planes, gridWidth, gridHeight, blockWidth, blockHeight)
+	plane2 := plane1
+	if dualPlane {
+		plane2 = astcInfillWeights(weights, 1, planes, gridWidth, gridHeight, blockWidth, blockHeight)
+	}
+
+	small := blockWidth*blockHeight < 31
+	for y := 0; y < blockHeight; y++ {
+		for x := 0; x < blockWidth; x++ {
+			i := y*blockWidth + x
+			e := endpoints[astcPartition(partitionSeed, x, y, partitions, small)]
+			for channel := 0; channel < 4; channel++ {
+				w := plane1[i]
+				if channel == plane2Component {
+					w = plane2[i]
+				}
+				c0 := e[0][channel]<<8 | e[0][channel]
+				c1 := e[1][channel]<<8 | e[1][channel]
+				out[i][channel] = uint8(((c0*(64-w) + c1*w + 32) >> 6) >> 8)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package unpack
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
@@ -29,8 +30,8 @@ func (bs *BinaryStream) SetPosition(position int64) {
 }
 
 func (bs *BinaryStream) BytesAvailable() int64 {
-	eof, _ := bs.baseStream.Seek(0, io.SeekEnd)
 	position := bs.Position()
+	eof, _ := bs.baseStream.Seek(0, io.SeekEnd)
 	bs.baseStream.Seek(position, io.SeekStart)
 	return eof - position
 }
@@ -214,3 +215,57 @@ func (bs *BinaryStream) ReadShort() int16 {
 	bs.readValue("h", &value)
 	return value
 }
+
+// MemoryStream is an in-memory io.ReadWriteSeeker for BinaryStream, writers
+// use it to patch offsets after the data they point to was written.
+type MemoryStream struct {
+	data     []byte
+	position int64
+}
+
+func NewMemoryStream(data []byte) *MemoryStream {
+	return &MemoryStream{data: data}
+}
+
+// Bytes returns the content of the stream.
+func (ms *MemoryStream) Bytes() []byte {
+	return ms.data
+}
+
+func (ms *MemoryStream) Read(p []byte) (int, error) {
+	if ms.position >= int64(len(ms.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, ms.data[ms.position:])
+	ms.position += int64(n)
+	return n, nil
+}
+
+func (ms *MemoryStream) Write(p []byte) (int, error) {
+	end := ms.position + int64(len(p))
+	if end > int64(len(ms.data)) {
+		ms.data = append(ms.data, make([]byte, end-int64(len(ms.data)))...)
+	}
+	copy(ms.data[ms.position:], p)
+	ms.position = end
+	return len(p), nil
+}
+
+func (ms *MemoryStream) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = ms.position + offset
+	case io.SeekEnd:
+		position = int64(len(ms.data)) + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if position < 0 {
+		return 0, errors.New("negative position")
+	}
+	ms.position = position
+	return position, nil
+}
@@ -74,3 +74,106 @@ func (d *D2I) Read() map[string]map[string]interface{} {
 
 	return d.Obj
 }
+
+// D2IText is a text of a D2I file. UnDiacritical is the text without
+// diacritics for texts that have some, the game searches in it.
+type D2IText struct {
+	ID            int32
+	Text          string
+	UnDiacritical string
+}
+
+// D2INamedText is a text with a name, like "ui.common.ok". It has its own
+// Text if HasText is set, which may be empty, or points to the text with ID.
+type D2INamedText struct {
+	Name    string
+	ID      int32
+	Text    string
+	HasText bool
+}
+
+// D2IEntries is the content of a D2I file in file order. SortedIDs is the
+// sort index of the texts.
+type D2IEntries struct {
+	Texts      []D2IText
+	NamedTexts []D2INamedText
+	SortedIDs  []int32
+}
+
+// ReadEntries reads the texts and indexes of the file in file order, as
+// needed by D2IWriter. Malformed files are returned as error.
+func (d *D2I) ReadEntries() (entries *D2IEntries, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed d2i file: %v", r)
+		}
+	}()
+
+	raw := NewBinaryStream(d.Stream, true)
+	d.Stream.Seek(0, io.SeekStart)
+
+	type textIndex struct {
+		key                  int32
+		pointer              int32
+		unDiacriticalPointer int32
+		diacriticalText      bool
+	}
+
+	indexesPointer := raw.ReadInt32()
+	d.Stream.Seek(int64(indexesPointer), io.SeekStart)
+
+	var indexes []textIndex
+	pointerKeys := make(map[int32]int32)
+	i := 0
+	indexesLength := raw.ReadInt32()
+	for i < int(indexesLength) {
+		index := textIndex{key: raw.ReadInt32(), diacriticalText: raw.ReadBool(), pointer: raw.ReadInt32()}
+		if index.diacriticalText {
+			i += 4
+			index.unDiacriticalPointer = raw.ReadInt32()
+		}
+		indexes = append(indexes, index)
+		pointerKeys[index.pointer] = index.key
+		i += 9
+	}
+
+	entries = &D2IEntries{}
+	var namePointers []int32
+	indexesLength = raw.ReadInt32()
+	for indexesLength > 0 {
+		position := raw.Position()
+		entries.NamedTexts = append(entries.NamedTexts, D2INamedText{Name: raw.ReadString()})
+		namePointers = append(namePointers, raw.ReadInt32())
+		indexesLength -= int32(raw.Position() - position)
+	}
+
+	indexesLength = raw.ReadInt32()
+	for indexesLength > 0 {
+		entries.SortedIDs = append(entries.SortedIDs, raw.ReadInt32())
+		indexesLength -= 4
+	}
+
+	readAt := func(pointer int32) string {
+		raw.SetPosition(int64(pointer))
+		return raw.ReadString()
+	}
+
+	for _, index := range indexes {
+		text := D2IText{ID: index.key, Text: readAt(index.pointer)}
+		if index.diacriticalText {
+			text.UnDiacritical = readAt(index.unDiacriticalPointer)
+		}
+		entries.Texts = append(entries.Texts, text)
+	}
+
+	for i, pointer := range namePointers {
+		if key, ok := pointerKeys[pointer]; ok {
+			entries.NamedTexts[i].ID = key
+		} else {
+			entries.NamedTexts[i].Text = readAt(pointer)
+			entries.NamedTexts[i].HasText = true
+		}
+	}
+
+	return entries, nil
+}
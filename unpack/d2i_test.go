@@ -0,0 +1,91 @@
+package unpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// texts.d2i has a text with and texts without diacritics, a named text with
+// an empty text of its own, one with a text of its own and one that points to
+// a text, and a sort index that is not in text order.
+func readTexts(t *testing.T) (*D2IEntries, []byte) {
+	t.Helper()
+
+	data := readTestdata(t, "texts.d2i")
+	entries, err := NewD2I(NewMemoryStream(append([]byte(nil), data...))).ReadEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries, data
+}
+
+func TestD2IRead(t *testing.T) {
+	data := readTestdata(t, "texts.d2i")
+	goldenJSON(t, "texts.d2i.json", NewD2I(NewMemoryStream(data)).Read())
+}
+
+func TestD2IEntries(t *testing.T) {
+	entries, _ := readTexts(t)
+
+	want := &D2IEntries{
+		Texts: []D2IText{
+			{ID: 1, Text: "Épée", UnDiacritical: "Epee"},
+			{ID: 2, Text: "Bouclier"},
+			{ID: 3, Text: "Anneau"},
+		},
+		NamedTexts: []D2INamedText{
+			{Name: "ui.common.empty", HasText: true},
+			{Name: "ui.common.ok", Text: "Valider", HasText: true},
+			{Name: "item.sword", ID: 1},
+		},
+		SortedIDs: []int32{1, 3, 2},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("ReadEntries() = %+v, want %+v", entries, want)
+	}
+}
+
+func TestD2IRoundTrip(t *testing.T) {
+	entries, data := readTexts(t)
+
+	written, err := NewD2IWriter(*entries).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, data) {
+		t.Errorf("written file differs from texts.d2i (%d vs %d bytes)", len(written), len(data))
+	}
+}
+
+func TestD2IWriterChanges(t *testing.T) {
+	entries, _ := readTexts(t)
+	writer := NewD2IWriter(*entries)
+	writer.SetText(1, "Dague")
+	writer.SetText(4, "Amulette")
+	writer.SortedIDs = nil
+
+	written, err := writer.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := NewD2I(NewMemoryStream(written)).ReadEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := changed.Texts[0], (D2IText{ID: 1, Text: "Dague"}); got != want {
+		t.Errorf("text 1 = %+v, want %+v", got, want)
+	}
+	if got, want := changed.SortedIDs, []int32{4, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SortedIDs = %v, want %v", got, want)
+	}
+	if got := changed.NamedTexts[2]; got.ID != 1 || got.HasText {
+		t.Errorf("item.sword = %+v, want a pointer to text 1", got)
+	}
+
+	writer.NamedTexts = append(writer.NamedTexts, D2INamedText{Name: "item.missing", ID: 9})
+	if _, err := writer.Bytes(); err == nil {
+		t.Error("named text that points to a missing text did not fail")
+	}
+}
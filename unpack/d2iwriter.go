@@ -0,0 +1,149 @@
+package unpack
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// D2IWriter serializes texts into a D2I file. The strings are written in the
+// order of Texts, each followed by its text without diacritics, then the
+// named texts with their own text. The indexes follow in the same order.
+type D2IWriter struct {
+	D2IEntries
+}
+
+func NewD2IWriter(entries D2IEntries) *D2IWriter {
+	return &D2IWriter{D2IEntries: entries}
+}
+
+// NewD2IWriterFromReader creates a writer with the content of a D2I file.
+func NewD2IWriterFromReader(d *D2I) (*D2IWriter, error) {
+	entries, err := d.ReadEntries()
+	if err != nil {
+		return nil, err
+	}
+	return NewD2IWriter(*entries), nil
+}
+
+// SetText replaces the text with id or adds it. A text without diacritics
+// is removed because it would not match anymore. Added texts are not in the
+// sort index until SortedIDs is updated or set to nil.
+func (w *D2IWriter) SetText(id int32, text string) {
+	for i := range w.Texts {
+		if w.Texts[i].ID == id {
+			w.Texts[i].Text = text
+			w.Texts[i].UnDiacritical = ""
+			return
+		}
+	}
+	w.Texts = append(w.Texts, D2IText{ID: id, Text: text})
+}
+
+// sortedIDs returns SortedIDs or, if it is nil, the IDs of the texts sorted
+// case-insensitively by their text without diacritics.
+func (w *D2IWriter) sortedIDs() []int32 {
+	if w.SortedIDs != nil {
+		return w.SortedIDs
+	}
+
+	texts := append([]D2IText(nil), w.Texts...)
+	sortText := func(text D2IText) string {
+		if text.UnDiacritical != "" {
+			return strings.ToLower(text.UnDiacritical)
+		}
+		return strings.ToLower(text.Text)
+	}
+	sort.SliceStable(texts, func(i, j int) bool {
+		return sortText(texts[i]) < sortText(texts[j])
+	})
+
+	ids := make([]int32, len(texts))
+	for i, text := range texts {
+		ids[i] = text.ID
+	}
+	return ids
+}
+
+// WriteTo writes the D2I file to out.
+func (w *D2IWriter) WriteTo(out io.Writer) (int64, error) {
+	data, err := w.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := out.Write(data)
+	return int64(n), err
+}
+
+// Bytes returns the D2I file.
+func (w *D2IWriter) Bytes() ([]byte, error) {
+	memory := NewMemoryStream(nil)
+	stream := NewBinaryStream(memory, true)
+
+	stream.WriteInt32(0) // indexes pointer, set when the strings are written
+
+	pointers := make(map[int32]int32, len(w.Texts))
+	unDiacriticalPointers := make([]int32, len(w.Texts))
+	for i, text := range w.Texts {
+		pointers[text.ID] = int32(stream.Position())
+		stream.WriteString(text.Text)
+		if text.UnDiacritical != "" {
+			unDiacriticalPointers[i] = int32(stream.Position())
+			stream.WriteString(text.UnDiacritical)
+		}
+	}
+
+	namePointers := make([]int32, len(w.NamedTexts))
+	for i, named := range w.NamedTexts {
+		if named.HasText || named.Text != "" {
+			namePointers[i] = int32(stream.Position())
+			stream.WriteString(named.Text)
+			continue
+		}
+		pointer, ok := pointers[named.ID]
+		if !ok {
+			return nil, fmt.Errorf("named text %s points to missing text %d", named.Name, named.ID)
+		}
+		namePointers[i] = pointer
+	}
+
+	indexesPointer := stream.Position()
+
+	indexesLength := 0
+	for _, text := range w.Texts {
+		indexesLength += 9
+		if text.UnDiacritical != "" {
+			indexesLength += 4
+		}
+	}
+	stream.WriteInt32(int32(indexesLength))
+	for i, text := range w.Texts {
+		stream.WriteInt32(text.ID)
+		stream.WriteBool(text.UnDiacritical != "")
+		stream.WriteInt32(pointers[text.ID])
+		if text.UnDiacritical != "" {
+			stream.WriteInt32(unDiacriticalPointers[i])
+		}
+	}
+
+	namesMemory := NewMemoryStream(nil)
+	names := NewBinaryStream(namesMemory, true)
+	for i, named := range w.NamedTexts {
+		names.WriteString(named.Name)
+		names.WriteInt32(namePointers[i])
+	}
+	stream.WriteInt32(int32(len(namesMemory.Bytes())))
+	stream.writeBytes(namesMemory.Bytes())
+
+	sortedIDs := w.sortedIDs()
+	stream.WriteInt32(int32(len(sortedIDs) * 4))
+	for _, id := range sortedIDs {
+		stream.WriteInt32(id)
+	}
+
+	stream.SetPosition(0)
+	stream.WriteInt32(int32(indexesPointer))
+
+	return memory.Bytes(), nil
+}
@@ -3,9 +3,87 @@ package unpack
 import (
 	"fmt"
 	"io"
-	"log"
+	"strings"
 )
 
+// Field types of D2O class definitions. Positive type IDs are class IDs of
+// nested objects.
+const (
+	D2OTypeInt    int32 = -1
+	D2OTypeBool   int32 = -2
+	D2OTypeString int32 = -3
+	D2OTypeNumber int32 = -4
+	D2OTypeI18n   int32 = -5
+	D2OTypeUint   int32 = -6
+	D2OTypeVector int32 = -99
+)
+
+// d2oNullObject marks a nested object that is not set.
+const d2oNullObject int32 = -1431655766
+
+// D2OType is the type of a D2O field. Vectors have the ActionScript type name
+// of the vector and the type of their elements.
+type D2OType struct {
+	ID   int32
+	Name string
+	Elem *D2OType
+}
+
+// D2OField is a field of a D2O class.
+type D2OField struct {
+	Name string
+	Type D2OType
+}
+
+// D2OClass is a class definition of a D2O file.
+type D2OClass struct {
+	ID      int32
+	Name    string
+	Package string
+	Fields  []D2OField
+}
+
+// D2OSearchField is a field of the search index used by D2OReader.Query.
+type D2OSearchField struct {
+	Name string
+	Type int32
+}
+
+// D2OObject is an object of a D2O file with its index ID and class.
+//
+// Fields has the strings stored as "null" as "" and nested objects without
+// their class. NextObject keeps both in NullStrings and ObjectClasses by the
+// path of the value, like "effects[2].description", so D2OWriter writes the
+// object back unchanged.
+type D2OObject struct {
+	ID      int32
+	ClassID int32
+	Fields  map[string]interface{}
+
+	NullStrings   map[string]bool
+	ObjectClasses map[string]int32
+}
+
+// d2oRaw collects what Fields loses while NextObject decodes an object.
+type d2oRaw struct {
+	path          []string
+	nullStrings   map[string]bool
+	objectClasses map[string]int32
+}
+
+func (raw *d2oRaw) push(segment string) {
+	raw.path = append(raw.path, segment)
+}
+
+func (raw *d2oRaw) pop() {
+	raw.path = raw.path[:len(raw.path)-1]
+}
+
+// key is the current path without the leading dot.
+func (raw *d2oRaw) key() string {
+	return strings.TrimPrefix(strings.Join(raw.path, ""), ".")
+}
+
 type GameDataProcess struct {
 	stream           *BinaryStream
 	sortIndex        map[string]int64
@@ -25,7 +103,132 @@ func (process *GameDataProcess) parseStream(D2OFileBinary *BinaryStream) {
 		process.searchFieldIndex[string] = int64(D2OFileBinary.ReadInt32()) + off
 		process.searchFieldType[string] = D2OFileBinary.ReadInt32()
 		process.searchFieldCount[string] = D2OFileBinary.ReadInt32()
-		length = length - (available - D2OFileBinary.BytesAvailable())
+		consumed := available - D2OFileBinary.BytesAvailable()
+		if consumed == 0 { // truncated file
+			break
+		}
+		length = length - consumed
+	}
+}
+
+// d2oSearchEntry is a value of the search index with the IDs of its objects.
+type d2oSearchEntry struct {
+	value interface{}
+	ids   []int32
+}
+
+// entries returns the search index of field in file order.
+func (process *GameDataProcess) entries(field string) ([]d2oSearchEntry, error) {
+	position, ok := process.searchFieldIndex[field]
+	if !ok {
+		return nil, fmt.Errorf("field %s is not queryable", field)
+	}
+
+	process.stream.SetPosition(position)
+	entries := make([]d2oSearchEntry, 0, process.searchFieldCount[field])
+	for i := int32(0); i < process.searchFieldCount[field]; i++ {
+		value, err := process.readSearchValue(process.searchFieldType[field])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+
+		entry := d2oSearchEntry{value: value}
+		length := process.stream.ReadInt32()
+		for j := int32(0); j < length/4; j++ {
+			entry.ids = append(entry.ids, process.stream.ReadInt32())
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (process *GameDataProcess) readSearchValue(fieldType int32) (interface{}, error) {
+	switch fieldType {
+	case -1, -5:
+		return process.stream.ReadInt32(), nil
+	case -2:
+		return process.stream.ReadBool(), nil
+	case -3:
+		return process.stream.ReadString(), nil
+	case -4:
+		return process.stream.ReadFloat64(), nil
+	case -6:
+		return process.stream.ReadUint32(), nil
+	default:
+		return nil, fmt.Errorf("unknown search field type %d", fieldType)
+	}
+}
+
+// query returns the IDs of the objects whose field matches value. Each entry
+// of the search index is a value followed by the byte length and the IDs of
+// its objects.
+func (process *GameDataProcess) query(field string, value interface{}) ([]int32, error) {
+	position, ok := process.searchFieldIndex[field]
+	if !ok {
+		return nil, fmt.Errorf("field %s is not queryable", field)
+	}
+
+	process.stream.SetPosition(position)
+	ids := make([]int32, 0)
+	for i := int32(0); i < process.searchFieldCount[field]; i++ {
+		fieldValue, err := process.readSearchValue(process.searchFieldType[field])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+
+		length := process.stream.ReadInt32()
+		if !searchValueEqual(fieldValue, value) {
+			process.stream.Seek(int64(length), io.SeekCurrent)
+			continue
+		}
+
+		for j := int32(0); j < length/4; j++ {
+			ids = append(ids, process.stream.ReadInt32())
+		}
+	}
+
+	return ids, nil
+}
+
+// searchValueEqual compares a value of the search index with a value given to
+// Query. Numbers are equal if they have the same value, whatever their type.
+func searchValueEqual(fieldValue interface{}, value interface{}) bool {
+	fieldNumber, fieldIsNumber := toFloat64(fieldValue)
+	number, isNumber := toFloat64(value)
+	if fieldIsNumber || isNumber {
+		return fieldIsNumber && isNumber && fieldNumber == number
+	}
+	return fieldValue == value
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
 	}
 }
 
@@ -42,6 +245,7 @@ func NewGameDataProcess(D2OFileBinary *BinaryStream) *GameDataProcess {
 
 type GameDataField struct {
 	name             string
+	typeIDs          []int32
 	innerReadMethods []func(*BinaryStream, int) interface{}
 	innerTypeNames   []string
 	d2oReader        *D2OReader
@@ -57,6 +261,26 @@ func NewGameDataField(name string, d2oReader *D2OReader) *GameDataField {
 	return field
 }
 
+// d2oType rebuilds the type description that readType parsed.
+func (field *GameDataField) d2oType() D2OType {
+	root := D2OType{}
+	current := &root
+	vectorIndex := 0
+	for i, typeID := range field.typeIDs {
+		current.ID = typeID
+		if typeID != D2OTypeVector {
+			break
+		}
+		current.Name = field.innerTypeNames[vectorIndex]
+		vectorIndex += 1
+		if i+1 < len(field.typeIDs) {
+			current.Elem = &D2OType{}
+			current = current.Elem
+		}
+	}
+	return root
+}
+
 func (field *GameDataField) readType(D2OFileBinary *BinaryStream) {
 	readID := D2OFileBinary.ReadInt32()
 	field.readData = field.getReadMethod(readID, D2OFileBinary)
@@ -77,6 +301,9 @@ func (field *GameDataField) readString(D2OFileBinary *BinaryStream, vecIndex int
 	str := D2OFileBinary.ReadString()
 	if str == "null" {
 		str = ""
+		if raw := field.d2oReader.raw; raw != nil {
+			raw.nullStrings[raw.key()] = true
+		}
 	}
 	return str
 }
@@ -100,8 +327,15 @@ func (field *GameDataField) readUnsignedInteger(D2OFileBinary *BinaryStream, vec
 func (field *GameDataField) readVector(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
 	vectorSize := int(D2OFileBinary.ReadInt32())
 	vector := make([]interface{}, vectorSize)
+	raw := field.d2oReader.raw
 	for i := 0; i < vectorSize; i++ {
+		if raw != nil {
+			raw.push(fmt.Sprintf("[%d]", i))
+		}
 		vector[i] = field.innerReadMethods[vecIndex](D2OFileBinary, vecIndex+1)
+		if raw != nil {
+			raw.pop()
+		}
 	}
 	return vector
 }
@@ -109,15 +343,20 @@ func (field *GameDataField) readVector(D2OFileBinary *BinaryStream, vecIndex int
 // readObject
 func (field *GameDataField) readObject(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
 	objectID := D2OFileBinary.ReadInt32()
-	if objectID == -1431655766 {
+	if objectID == d2oNullObject {
 		return nil
 	}
 
+	if raw := field.d2oReader.raw; raw != nil {
+		raw.objectClasses[raw.key()] = objectID
+	}
+
 	obj := field.d2oReader.GetClassDefinition(objectID)
 	return obj.read(D2OFileBinary)
 }
 
 func (field *GameDataField) getReadMethod(readID int32, D2OFileBinary *BinaryStream) func(*BinaryStream, int) interface{} {
+	field.typeIDs = append(field.typeIDs, readID)
 	if readID == -1 {
 		return field.readInteger
 	} else if readID == -2 {
@@ -144,6 +383,8 @@ func (field *GameDataField) getReadMethod(readID int32, D2OFileBinary *BinaryStr
 
 type GameDataClassDefinition struct {
 	class     string
+	name      string
+	pkg       string
 	fields    []*GameDataField
 	d2oReader *D2OReader
 }
@@ -151,15 +392,24 @@ type GameDataClassDefinition struct {
 func NewGameDataClassDefinition(class_pkg string, class_name string, d2oReader *D2OReader) *GameDataClassDefinition {
 	return &GameDataClassDefinition{
 		class:     class_pkg + "." + class_name,
+		name:      class_name,
+		pkg:       class_pkg,
 		fields:    make([]*GameDataField, 0),
 		d2oReader: d2oReader,
 	}
 }
 
-func (classDef *GameDataClassDefinition) read(D2OFileBinary *BinaryStream) interface{} {
+func (classDef *GameDataClassDefinition) read(D2OFileBinary *BinaryStream) map[string]interface{} {
 	obj := make(map[string]interface{})
+	raw := classDef.d2oReader.raw
 	for _, field := range classDef.fields {
+		if raw != nil {
+			raw.push("." + field.name)
+		}
 		obj[field.name] = field.readData(D2OFileBinary, 0)
+		if raw != nil {
+			raw.pop()
+		}
 	}
 	return obj
 }
@@ -170,7 +420,9 @@ func (classDef *GameDataClassDefinition) addField(name string, D2OFileBinary *Bi
 	classDef.fields = append(classDef.fields, field)
 }
 
-// D2OReader represents a reader for D2O files.
+// D2OReader represents a reader for D2O files. Objects are decoded from the
+// stream when they are requested, Next and All go through them in file order,
+// GetObjectByID and Query use the indexes of the file.
 type D2OReader struct {
 	stream            io.Reader
 	streamStartIndex  int64
@@ -178,6 +430,13 @@ type D2OReader struct {
 	counter           int
 	D2OFileBinary     *BinaryStream
 	gameDataProcessor *GameDataProcess
+	classOrder        []int32
+	indexIDs          []int32
+	indexOffsets      map[int32]int64
+	offsetIDs         map[int64]int32
+	nextPosition      int64
+	nextIndex         int
+	raw               *d2oRaw // set while NextObject decodes an object
 }
 
 // NewD2OReader creates a new D2OReader instance with the given stream.
@@ -214,12 +473,15 @@ func NewD2OReader(stream io.ReadWriteSeeker) (*D2OReader, error) {
 	D2OFileBinary.Seek(int64(baseOffset+offset), io.SeekStart)
 	indexNumber := D2OFileBinary.ReadInt32()
 	index := 0
-	indexOffsets := make(map[int32]int32)
+	reader.indexOffsets = make(map[int32]int64)
+	reader.offsetIDs = make(map[int64]int32)
 
 	for int32(index) < indexNumber {
 		indexID := D2OFileBinary.ReadInt32()
 		offset := D2OFileBinary.ReadInt32()
-		indexOffsets[indexID] = baseOffset + offset
+		reader.indexOffsets[indexID] = int64(baseOffset + offset)
+		reader.offsetIDs[int64(baseOffset+offset)] = indexID
+		reader.indexIDs = append(reader.indexIDs, indexID)
 		reader.counter += 1
 		index = index + 8
 	}
@@ -237,32 +499,208 @@ func NewD2OReader(stream io.ReadWriteSeeker) (*D2OReader, error) {
 		reader.gameDataProcessor = NewGameDataProcess(&D2OFileBinary)
 	}
 
+	reader.Reset()
+
 	return reader, nil
 }
 
-func (dr *D2OReader) GetObjects() []interface{} {
+// GetObjects decodes all objects of the file. Use Next or All to go through
+// large files without keeping every object in memory.
+func (dr *D2OReader) GetObjects() ([]interface{}, error) {
 	if dr.counter == 0 {
+		return nil, nil
+	}
+
+	dr.Reset()
+	objects := make([]interface{}, 0, dr.counter)
+	err := dr.All(func(object map[string]interface{}) error {
+		objects = append(objects, object)
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	D2OFileBinary := dr.D2OFileBinary
-	D2OFileBinary.SetPosition(dr.streamStartIndex)
-	objects := make([]interface{}, 0)
-	i := 0
-
-	for i < dr.counter {
-		classId := D2OFileBinary.ReadInt32()
-		class := dr.classes[classId]
-		if class == nil {
-			log.Fatal("class is nil")
+	return objects, nil
+}
+
+// Len returns the number of objects in the file.
+func (dr *D2OReader) Len() int {
+	return dr.counter
+}
+
+// Reset makes Next start again with the first object.
+func (dr *D2OReader) Reset() {
+	dr.nextPosition = dr.streamStartIndex
+	dr.nextIndex = 0
+}
+
+// Next decodes the next object in file order. It returns io.EOF after the
+// last object.
+func (dr *D2OReader) Next() (map[string]interface{}, error) {
+	object, err := dr.next(false)
+	if err != nil {
+		return nil, err
+	}
+	return object.Fields, nil
+}
+
+// NextObject is Next with the ID, class, null strings and nested classes of
+// the object, as needed by D2OWriter.
+func (dr *D2OReader) NextObject() (D2OObject, error) {
+	return dr.next(true)
+}
+
+func (dr *D2OReader) next(keepRaw bool) (D2OObject, error) {
+	if dr.nextIndex >= dr.counter {
+		return D2OObject{}, io.EOF
+	}
+
+	if keepRaw {
+		dr.raw = &d2oRaw{nullStrings: make(map[string]bool), objectClasses: make(map[string]int32)}
+		defer func() { dr.raw = nil }()
+	}
+
+	position := dr.nextPosition
+	classID, fields, err := dr.readObjectAt(position)
+	if err != nil {
+		return D2OObject{}, fmt.Errorf("object %d: %w", dr.nextIndex, err)
+	}
+
+	dr.nextPosition = dr.D2OFileBinary.Position()
+	dr.nextIndex += 1
+
+	object := D2OObject{ID: dr.offsetIDs[position], ClassID: classID, Fields: fields}
+	if keepRaw {
+		object.NullStrings = dr.raw.nullStrings
+		object.ObjectClasses = dr.raw.objectClasses
+	}
+	return object, nil
+}
+
+// All calls fn for every object that Next has not returned yet. It stops at
+// the first error, also one returned by fn.
+func (dr *D2OReader) All(fn func(object map[string]interface{}) error) error {
+	for {
+		object, err := dr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 
-		object := class.read(D2OFileBinary)
-		objects = append(objects, object)
-		i += 1
+		if err := fn(object); err != nil {
+			return err
+		}
+	}
+}
+
+// GetObjectByID decodes the object with the given ID through the index
+// table, without reading the objects before it.
+func (dr *D2OReader) GetObjectByID(id int32) (map[string]interface{}, error) {
+	position, ok := dr.indexOffsets[id]
+	if !ok {
+		return nil, fmt.Errorf("no object with id %d", id)
+	}
+
+	_, object, err := dr.readObjectAt(position)
+	if err != nil {
+		return nil, fmt.Errorf("object with id %d: %w", id, err)
+	}
+	return object, nil
+}
+
+// IDs returns the IDs of all objects in the order of the index table.
+func (dr *D2OReader) IDs() []int32 {
+	return append([]int32(nil), dr.indexIDs...)
+}
+
+// Classes returns the class definitions of the file in file order.
+func (dr *D2OReader) Classes() []D2OClass {
+	classes := make([]D2OClass, 0, len(dr.classOrder))
+	for _, classID := range dr.classOrder {
+		classDef := dr.classes[classID]
+		class := D2OClass{ID: classID, Name: classDef.name, Package: classDef.pkg}
+		for _, field := range classDef.fields {
+			class.Fields = append(class.Fields, D2OField{Name: field.name, Type: field.d2oType()})
+		}
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+// SearchFields returns the fields of the search index with their types, see
+// QueryableFields.
+func (dr *D2OReader) SearchFields() []D2OSearchField {
+	if dr.gameDataProcessor == nil {
+		return nil
+	}
+
+	fields := make([]D2OSearchField, 0, len(dr.gameDataProcessor.queryableField))
+	for _, name := range dr.gameDataProcessor.queryableField {
+		fields = append(fields, D2OSearchField{Name: name, Type: dr.gameDataProcessor.searchFieldType[name]})
+	}
+	return fields
+}
+
+// QueryableFields returns the fields that can be used with Query.
+func (dr *D2OReader) QueryableFields() []string {
+	if dr.gameDataProcessor == nil {
+		return nil
+	}
+	return dr.gameDataProcessor.queryableField
+}
+
+// Query returns the IDs of the objects whose field equals value, using the
+// search index of the file. Only fields listed by QueryableFields can be
+// queried. Pass the IDs to GetObjectByID to decode the objects.
+func (dr *D2OReader) Query(field string, value interface{}) (ids []int32, err error) {
+	if dr.gameDataProcessor == nil {
+		return nil, fmt.Errorf("file has no search index")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("query %s: %v", field, r)
+		}
+	}()
+	return dr.gameDataProcessor.query(field, value)
+}
+
+// searchEntries returns the search index of field in file order.
+func (dr *D2OReader) searchEntries(field string) (entries []d2oSearchEntry, err error) {
+	if dr.gameDataProcessor == nil {
+		return nil, fmt.Errorf("file has no search index")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("search index %s: %v", field, r)
+		}
+	}()
+	return dr.gameDataProcessor.entries(field)
+}
+
+// readObjectAt decodes the object that starts at position. Decoding panics on
+// malformed data, that is returned as error.
+func (dr *D2OReader) readObjectAt(position int64) (classID int32, object map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	D2OFileBinary := dr.D2OFileBinary
+	D2OFileBinary.SetPosition(position)
+
+	classID = D2OFileBinary.ReadInt32()
+	class := dr.classes[classID]
+	if class == nil {
+		return classID, nil, fmt.Errorf("unknown class %d", classID)
 	}
 
-	return objects
+	return classID, class.read(D2OFileBinary), nil
 }
 
 // GetClassDefinition returns the class definition for a given object_id.
@@ -284,4 +722,5 @@ func (dr *D2OReader) readClassDefinition(classID int32, D2OFileBinary *BinaryStr
 	}
 
 	dr.classes[classID] = classDef
+	dr.classOrder = append(dr.classOrder, classID)
 }
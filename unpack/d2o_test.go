@@ -0,0 +1,218 @@
+package unpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with testdata/name, or writes it with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file, run go test -update to see the changes", name)
+	}
+}
+
+// goldenJSON is golden for the indented JSON of value.
+func goldenJSON(t *testing.T, name string, value interface{}) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, name, append(got, '\n'))
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// items.d2o is laid out like the game files: an index table sorted by ID while
+// the objects are not, a search index whose values are not sorted, strings
+// stored as "null" next to empty ones and nested effects of two classes with
+// the same fields.
+func openItems(t *testing.T) (*D2OReader, []byte) {
+	t.Helper()
+
+	data := readTestdata(t, "items.d2o")
+	reader, err := NewD2OReader(NewMemoryStream(append([]byte(nil), data...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader, data
+}
+
+func TestD2OObjects(t *testing.T) {
+	reader, _ := openItems(t)
+
+	objects, err := reader.GetObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	goldenJSON(t, "items.d2o.json", objects)
+
+	if got, want := reader.IDs(), []int32{3, 7, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IDs() = %v, want %v", got, want)
+	}
+
+	object, err := reader.GetObjectByID(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object["nameId"] != int32(4407) {
+		t.Errorf("object 7 has nameId %v, want 4407", object["nameId"])
+	}
+}
+
+func TestD2OQuery(t *testing.T) {
+	reader, _ := openItems(t)
+
+	tests := []struct {
+		field string
+		value interface{}
+		want  []int32
+	}{
+		{"level", 60, []int32{10, 7}},
+		{"level", uint32(1), []int32{3}},
+		{"id", int32(7), []int32{7}},
+		{"name", "Gelano", []int32{10}},
+		{"name", "null", []int32{3}},
+		{"name", "Missing", []int32{}},
+	}
+	for _, test := range tests {
+		got, err := reader.Query(test.field, test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Query(%q, %v) = %v, want %v", test.field, test.value, got, test.want)
+		}
+	}
+
+	if _, err := reader.Query("weight", 2.5); err == nil {
+		t.Error("Query of a field without search index did not fail")
+	}
+}
+
+func TestD2ORoundTrip(t *testing.T) {
+	for _, nullStrings := range []bool{false, true} {
+		reader, data := openItems(t)
+
+		writer, err := NewD2OWriterFromReader(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.NullStrings = nullStrings
+
+		written, err := writer.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(written, data) {
+			t.Errorf("NullStrings %v: written file differs from items.d2o (%d vs %d bytes)", nullStrings, len(written), len(data))
+		}
+	}
+}
+
+func TestD2OWriterChanges(t *testing.T) {
+	reader, _ := openItems(t)
+	writer, err := NewD2OWriterFromReader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer.Objects()[0].Fields["level"] = uint32(1)
+	writer.Add(D2OObject{ID: 5, ClassID: 1, Fields: map[string]interface{}{
+		"id": int32(5), "nameId": int32(4405), "name": "Added", "level": uint32(200), "weight": 0.0, "exotic": false,
+		"possibleEffects": []interface{}{}, "criteria": []interface{}{}, "icon": nil,
+	}})
+
+	written, err := writer.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := NewD2OReader(NewMemoryStream(written))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := changed.IDs(), []int32{3, 7, 10, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IDs() = %v, want %v", got, want)
+	}
+	for value, want := range map[uint32][]int32{60: {7}, 1: {3, 10}, 200: {5}} {
+		got, err := changed.Query("level", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Query(level, %d) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+// TestD2ORoundTripGameFiles rewrites every .d2o of the directory in
+// DODUDA_TEST_D2O_DIR, like data/common of a Dofus 2 download.
+func TestD2ORoundTripGameFiles(t *testing.T) {
+	dir := os.Getenv("DODUDA_TEST_D2O_DIR")
+	if dir == "" {
+		t.Skip("DODUDA_TEST_D2O_DIR is not set")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.d2o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewD2OReader(NewMemoryStream(append([]byte(nil), data...)))
+		if err != nil {
+			t.Errorf("%s: %v", filepath.Base(file), err)
+			continue
+		}
+		writer, err := NewD2OWriterFromReader(reader)
+		if err != nil {
+			t.Errorf("%s: %v", filepath.Base(file), err)
+			continue
+		}
+		written, err := writer.Bytes()
+		if err != nil {
+			t.Errorf("%s: %v", filepath.Base(file), err)
+			continue
+		}
+		if !bytes.Equal(written, data) {
+			t.Errorf("%s: written file differs (%d vs %d bytes)", filepath.Base(file), len(written), len(data))
+		}
+	}
+}
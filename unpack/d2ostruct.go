@@ -0,0 +1,174 @@
+package unpack
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DecodeObject stores a D2O object as returned by D2OReader in the struct
+// pointed to by v. Struct fields are matched by their `d2o` tag, fields
+// without tag by their name with a lowercase first letter, so `NameId` reads
+// the D2O field "nameId". The tag "-" skips a field. D2O fields without a
+// struct field are ignored.
+//
+//	type Item struct {
+//		ID              int32    `d2o:"id"`
+//		NameID          int32    `d2o:"nameId"`
+//		Level           uint32   `d2o:"level"`
+//		PossibleEffects []Effect `d2o:"possibleEffects"`
+//	}
+func DecodeObject(object map[string]interface{}, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %T", v)
+	}
+	return decodeValue(object, value.Elem(), "")
+}
+
+// NextInto decodes the next object in file order into v, see DecodeObject.
+// It returns io.EOF after the last object.
+func (dr *D2OReader) NextInto(v interface{}) error {
+	object, err := dr.Next()
+	if err != nil {
+		return err
+	}
+	return DecodeObject(object, v)
+}
+
+// GetObjectByIDInto decodes the object with the given ID into v, see
+// DecodeObject.
+func (dr *D2OReader) GetObjectByIDInto(id int32, v interface{}) error {
+	object, err := dr.GetObjectByID(id)
+	if err != nil {
+		return err
+	}
+	return DecodeObject(object, v)
+}
+
+func d2oFieldName(field reflect.StructField) string {
+	if tag, ok := field.Tag.Lookup("d2o"); ok {
+		return strings.Split(tag, ",")[0]
+	}
+
+	first, size := utf8.DecodeRuneInString(field.Name)
+	return string(unicode.ToLower(first)) + field.Name[size:]
+}
+
+func decodeValue(data interface{}, target reflect.Value, path string) error {
+	if data == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	wrongType := func() error {
+		return fmt.Errorf("%s: cannot decode %T into %s", strings.TrimPrefix(path, "."), data, target.Type())
+	}
+
+	switch target.Kind() {
+	case reflect.Interface:
+		value := reflect.ValueOf(data)
+		if !value.Type().AssignableTo(target.Type()) {
+			return wrongType()
+		}
+		target.Set(value)
+
+	case reflect.Pointer:
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return decodeValue(data, target.Elem(), path)
+
+	case reflect.Struct:
+		object, ok := data.(map[string]interface{})
+		if !ok {
+			return wrongType()
+		}
+		for i := 0; i < target.NumField(); i++ {
+			field := target.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := d2oFieldName(field)
+			if name == "-" {
+				continue
+			}
+			fieldData, ok := object[name]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(fieldData, target.Field(i), path+"."+name); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		object, ok := data.(map[string]interface{})
+		if !ok || target.Type().Key().Kind() != reflect.String {
+			return wrongType()
+		}
+		target.Set(reflect.MakeMapWithSize(target.Type(), len(object)))
+		for key, elemData := range object {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			if err := decodeValue(elemData, elem, path+"."+key); err != nil {
+				return err
+			}
+			target.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), elem)
+		}
+
+	case reflect.Slice:
+		vector, ok := data.([]interface{})
+		if !ok {
+			return wrongType()
+		}
+		slice := reflect.MakeSlice(target.Type(), len(vector), len(vector))
+		for i, elemData := range vector {
+			if err := decodeValue(elemData, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+
+	case reflect.String:
+		str, ok := data.(string)
+		if !ok {
+			return wrongType()
+		}
+		target.SetString(str)
+
+	case reflect.Bool:
+		b, ok := data.(bool)
+		if !ok {
+			return wrongType()
+		}
+		target.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := toFloat64(data)
+		if !ok || number != float64(int64(number)) || target.OverflowInt(int64(number)) {
+			return wrongType()
+		}
+		target.SetInt(int64(number))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := toFloat64(data)
+		if !ok || number < 0 || number != float64(uint64(number)) || target.OverflowUint(uint64(number)) {
+			return wrongType()
+		}
+		target.SetUint(uint64(number))
+
+	case reflect.Float32, reflect.Float64:
+		number, ok := toFloat64(data)
+		if !ok {
+			return wrongType()
+		}
+		target.SetFloat(number)
+
+	default:
+		return wrongType()
+	}
+
+	return nil
+}
@@ -0,0 +1,433 @@
+package unpack
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// D2OWriter serializes class definitions and objects into a D2O file.
+// Objects are written in the order they were added. The index table and the
+// search index are rebuilt from the objects. A writer created from a reader
+// keeps the order of both from the file, so an unchanged file is written back
+// byte for byte. Otherwise the index table follows the objects and the search
+// index lists the values of each search field in ascending order with the IDs
+// of their objects in object order.
+//
+// Strings and nested objects use the NullStrings and ObjectClasses of their
+// object. Without them, a nested object is written with the declared class of
+// its field if it has exactly its fields, otherwise with the first class that
+// has.
+type D2OWriter struct {
+	// NullStrings writes empty strings of objects without NullStrings as
+	// "null", the way the game files store missing strings. D2OReader reads
+	// both as "".
+	NullStrings bool
+
+	classes      []D2OClass
+	searchFields []D2OSearchField
+	objects      []D2OObject
+
+	indexOrder  []int32                     // object IDs in the order of the index table of the file
+	searchOrder map[string][]d2oSearchEntry // search index of the file by field
+}
+
+func NewD2OWriter(classes []D2OClass, searchFields []D2OSearchField) *D2OWriter {
+	return &D2OWriter{
+		classes:      classes,
+		searchFields: searchFields,
+	}
+}
+
+// NewD2OWriterFromReader creates a writer with the classes and search fields
+// of reader and adds the objects that reader.Next has not returned yet.
+func NewD2OWriterFromReader(reader *D2OReader) (*D2OWriter, error) {
+	writer := NewD2OWriter(reader.Classes(), reader.SearchFields())
+	writer.indexOrder = reader.IDs()
+	writer.searchOrder = make(map[string][]d2oSearchEntry)
+	for _, field := range writer.searchFields {
+		entries, err := reader.searchEntries(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		writer.searchOrder[field.Name] = entries
+	}
+
+	for {
+		object, err := reader.NextObject()
+		if err == io.EOF {
+			return writer, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		writer.Add(object)
+	}
+}
+
+// Add appends an object. A ClassID of 0 picks the class by the fields of the
+// object.
+func (w *D2OWriter) Add(object D2OObject) {
+	w.objects = append(w.objects, object)
+}
+
+// Objects returns the added objects, changes to their fields are written.
+func (w *D2OWriter) Objects() []D2OObject {
+	return w.objects
+}
+
+// WriteTo writes the D2O file to out.
+func (w *D2OWriter) WriteTo(out io.Writer) (int64, error) {
+	data, err := w.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := out.Write(data)
+	return int64(n), err
+}
+
+// Bytes returns the D2O file.
+func (w *D2OWriter) Bytes() ([]byte, error) {
+	memory := NewMemoryStream(nil)
+	stream := NewBinaryStream(memory, true)
+
+	stream.writeBytes([]byte("D2O"))
+	stream.WriteInt32(0) // index pointer, set when the objects are written
+
+	offsets := make(map[int32]int64, len(w.objects))
+	for i := range w.objects {
+		object := &w.objects[i]
+		offsets[object.ID] = stream.Position()
+
+		class, err := w.classFor(object.ClassID, object.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", object.ID, err)
+		}
+		if err := w.writeObject(stream, object, class, object.Fields, ""); err != nil {
+			return nil, fmt.Errorf("object %d: %w", object.ID, err)
+		}
+	}
+
+	indexPointer := stream.Position()
+	stream.WriteInt32(int32(len(offsets) * 8))
+	for _, id := range w.indexIDs() {
+		stream.WriteInt32(id)
+		stream.WriteInt32(int32(offsets[id]))
+	}
+
+	stream.WriteInt32(int32(len(w.classes)))
+	for _, class := range w.classes {
+		stream.WriteInt32(class.ID)
+		stream.WriteString(class.Name)
+		stream.WriteString(class.Package)
+		stream.WriteInt32(int32(len(class.Fields)))
+		for _, field := range class.Fields {
+			stream.WriteString(field.Name)
+			for fieldType := &field.Type; fieldType != nil; fieldType = fieldType.Elem {
+				stream.WriteInt32(fieldType.ID)
+				if fieldType.ID != D2OTypeVector {
+					break
+				}
+				stream.WriteString(fieldType.Name)
+			}
+		}
+	}
+
+	if len(w.searchFields) > 0 {
+		if err := w.writeSearchIndex(stream); err != nil {
+			return nil, err
+		}
+	}
+
+	stream.SetPosition(3)
+	stream.WriteInt32(int32(indexPointer))
+
+	return memory.Bytes(), nil
+}
+
+// indexIDs returns the IDs of the objects in the order of the index table of
+// the file, followed by the IDs of added objects in object order.
+func (w *D2OWriter) indexIDs() []int32 {
+	added := make(map[int32]bool, len(w.objects))
+	for _, object := range w.objects {
+		added[object.ID] = true
+	}
+
+	ids := make([]int32, 0, len(added))
+	for _, id := range w.indexOrder {
+		if added[id] {
+			ids = append(ids, id)
+			delete(added, id)
+		}
+	}
+	for _, object := range w.objects {
+		if added[object.ID] {
+			ids = append(ids, object.ID)
+			delete(added, object.ID)
+		}
+	}
+	return ids
+}
+
+// classFor returns the class to write fields with. A declared class ID is
+// used if the class has exactly the given fields.
+func (w *D2OWriter) classFor(declared int32, fields map[string]interface{}) (*D2OClass, error) {
+	hasFields := func(class *D2OClass) bool {
+		if len(class.Fields) != len(fields) {
+			return false
+		}
+		for _, field := range class.Fields {
+			if _, ok := fields[field.Name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := range w.classes {
+		if w.classes[i].ID == declared && hasFields(&w.classes[i]) {
+			return &w.classes[i], nil
+		}
+	}
+	for i := range w.classes {
+		if hasFields(&w.classes[i]) {
+			return &w.classes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no class has exactly the fields of the object")
+}
+
+// writeObject writes fields of the top level object at path.
+func (w *D2OWriter) writeObject(stream *BinaryStream, object *D2OObject, class *D2OClass, fields map[string]interface{}, path string) error {
+	stream.WriteInt32(class.ID)
+	for _, field := range class.Fields {
+		fieldType := field.Type
+		if err := w.writeValue(stream, object, &fieldType, fields[field.Name], path+"."+field.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeString writes an empty string as "null" if the file had it so, or
+// for objects that do not record it if NullStrings is set.
+func (w *D2OWriter) writeString(stream *BinaryStream, object *D2OObject, str string, key string) {
+	if str == "" && (object.NullStrings[key] || (object.NullStrings == nil && w.NullStrings)) {
+		str = "null"
+	}
+	stream.WriteString(str)
+}
+
+func (w *D2OWriter) writeValue(stream *BinaryStream, object *D2OObject, fieldType *D2OType, value interface{}, path string) error {
+	wrongType := func() error {
+		return fmt.Errorf("%s: cannot write %T as type %d", path[1:], value, fieldType.ID)
+	}
+
+	switch fieldType.ID {
+	case D2OTypeInt, D2OTypeI18n:
+		number, ok := toFloat64(value)
+		if !ok {
+			return wrongType()
+		}
+		stream.WriteInt32(int32(number))
+	case D2OTypeUint:
+		number, ok := toFloat64(value)
+		if !ok {
+			return wrongType()
+		}
+		stream.WriteUint32(uint32(number))
+	case D2OTypeNumber:
+		number, ok := toFloat64(value)
+		if !ok {
+			return wrongType()
+		}
+		stream.WriteFloat64(number)
+	case D2OTypeBool:
+		b, ok := value.(bool)
+		if !ok {
+			return wrongType()
+		}
+		stream.WriteBool(b)
+	case D2OTypeString:
+		str, ok := value.(string)
+		if !ok {
+			return wrongType()
+		}
+		w.writeString(stream, object, str, path[1:])
+	case D2OTypeVector:
+		vector, ok := value.([]interface{})
+		if !ok || fieldType.Elem == nil {
+			return wrongType()
+		}
+		stream.WriteInt32(int32(len(vector)))
+		for i, elem := range vector {
+			if err := w.writeValue(stream, object, fieldType.Elem, elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	default:
+		if fieldType.ID <= 0 {
+			return fmt.Errorf("%s: unknown type %d", path[1:], fieldType.ID)
+		}
+		if value == nil {
+			stream.WriteInt32(d2oNullObject)
+			return nil
+		}
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return wrongType()
+		}
+		declared := fieldType.ID
+		if classID, ok := object.ObjectClasses[path[1:]]; ok {
+			declared = classID
+		}
+		class, err := w.classFor(declared, fields)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path[1:], err)
+		}
+		return w.writeObject(stream, object, class, fields, path)
+	}
+
+	return nil
+}
+
+// searchKey normalizes a field value so equal values of different Go types
+// share one search index entry.
+func searchKey(fieldType int32, value interface{}) (interface{}, bool) {
+	switch fieldType {
+	case D2OTypeInt, D2OTypeI18n, D2OTypeUint:
+		number, ok := toFloat64(value)
+		return int64(number), ok
+	case D2OTypeNumber:
+		return toFloat64(value)
+	case D2OTypeString:
+		str, ok := value.(string)
+		return str, ok
+	case D2OTypeBool:
+		b, ok := value.(bool)
+		return b, ok
+	default:
+		return nil, false
+	}
+}
+
+func searchKeyLess(a interface{}, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		return a < b.(int64)
+	case float64:
+		return a < b.(float64)
+	case string:
+		return a < b.(string)
+	case bool:
+		return !a && b.(bool)
+	}
+	return false
+}
+
+// orderSearchEntries puts the values that were in the search index of the
+// file first, in its order and with their IDs in its order. New values follow
+// in ascending order.
+func (w *D2OWriter) orderSearchEntries(field D2OSearchField, byValue map[interface{}]*d2oSearchEntry) []*d2oSearchEntry {
+	ordered := make([]*d2oSearchEntry, 0, len(byValue))
+	for _, original := range w.searchOrder[field.Name] {
+		key, ok := searchKey(field.Type, original.value)
+		entry := byValue[key]
+		if !ok || entry == nil {
+			continue
+		}
+		delete(byValue, key)
+
+		current := make(map[int32]bool, len(entry.ids))
+		for _, id := range entry.ids {
+			current[id] = true
+		}
+		ids := make([]int32, 0, len(entry.ids))
+		for _, id := range original.ids {
+			if current[id] {
+				ids = append(ids, id)
+				delete(current, id)
+			}
+		}
+		for _, id := range entry.ids {
+			if current[id] {
+				ids = append(ids, id)
+			}
+		}
+		entry.ids = ids
+		ordered = append(ordered, entry)
+	}
+
+	added := make([]*d2oSearchEntry, 0, len(byValue))
+	for _, entry := range byValue {
+		added = append(added, entry)
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return searchKeyLess(added[i].value, added[j].value)
+	})
+	return append(ordered, added...)
+}
+
+// writeSearchIndex writes the field list of the search index followed by the
+// entries of all fields. Field offsets are relative to the first entry.
+func (w *D2OWriter) writeSearchIndex(stream *BinaryStream) error {
+	entriesMemory := NewMemoryStream(nil)
+	entries := NewBinaryStream(entriesMemory, true)
+	listMemory := NewMemoryStream(nil)
+	list := NewBinaryStream(listMemory, true)
+
+	for _, field := range w.searchFields {
+		byValue := make(map[interface{}]*d2oSearchEntry)
+		for _, object := range w.objects {
+			value, ok := object.Fields[field.Name]
+			if !ok {
+				continue
+			}
+			if value == "" && object.NullStrings[field.Name] {
+				value = "null"
+			}
+			key, ok := searchKey(field.Type, value)
+			if !ok {
+				return fmt.Errorf("search field %s: cannot index %T as type %d", field.Name, value, field.Type)
+			}
+			if byValue[key] == nil {
+				byValue[key] = &d2oSearchEntry{value: key}
+			}
+			byValue[key].ids = append(byValue[key].ids, object.ID)
+		}
+
+		sorted := w.orderSearchEntries(field, byValue)
+
+		list.WriteString(field.Name)
+		list.WriteInt32(int32(entries.Position()))
+		list.WriteInt32(field.Type)
+		list.WriteInt32(int32(len(sorted)))
+
+		for _, entry := range sorted {
+			switch value := entry.value.(type) {
+			case int64:
+				if field.Type == D2OTypeUint {
+					entries.WriteUint32(uint32(value))
+				} else {
+					entries.WriteInt32(int32(value))
+				}
+			case float64:
+				entries.WriteFloat64(value)
+			case string:
+				entries.WriteString(value)
+			case bool:
+				entries.WriteBool(value)
+			}
+			entries.WriteInt32(int32(len(entry.ids) * 4))
+			for _, id := range entry.ids {
+				entries.WriteInt32(id)
+			}
+		}
+	}
+
+	stream.WriteInt32(int32(len(listMemory.Bytes())))
+	stream.writeBytes(listMemory.Bytes())
+	stream.WriteInt32(int32(len(entriesMemory.Bytes())))
+	stream.writeBytes(entriesMemory.Bytes())
+	return nil
+}
@@ -1,7 +1,25 @@
 package unpack
 
-import "io"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
 
+// D2PEntry is a file in a D2P archive.
+type D2PEntry struct {
+	Name   string
+	Offset int64 // from the start of the archive file
+	Length int64
+
+	archive *D2P
+}
+
+// D2P reads D2P archives. Only the index and the properties are read when it
+// is created, the contained files are read when they are opened.
 type D2P struct {
 	Stream           io.ReadWriteSeeker
 	baseOffset       uint32
@@ -10,23 +28,35 @@ type D2P struct {
 	numberIndexes    uint32
 	propertiesOffset uint32
 	numberProperties uint32
-	filesPosition    map[string]map[string]uint32
-	files            map[string][]byte
+	entries          []D2PEntry
+	entryByName      map[string]int
 	properties       map[string]string
+	link             *D2P
+	file             *os.File // opened by OpenD2P, closed by Close
 }
 
-func NewD2P(stream io.ReadWriteSeeker) *D2P {
-	d2p := &D2P{
-		Stream:        stream,
-		filesPosition: make(map[string]map[string]uint32),
-		files:         make(map[string][]byte),
-		properties:    make(map[string]string),
+func NewD2P(stream io.ReadWriteSeeker) (d2p *D2P, err error) {
+	d2p = &D2P{
+		Stream:      stream,
+		entryByName: make(map[string]int),
+		properties:  make(map[string]string),
 	}
 
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed d2p file: %v", r)
+		}
+	}()
+
 	d2pFileBinary := NewBinaryStream(stream, true)
+	size := d2pFileBinary.BytesAvailable()
+	if size < 26 {
+		return nil, errors.New("invalid d2p file: too small")
+	}
+
 	bytesHeader := string(d2pFileBinary.ReadBytes(2))
 	if bytesHeader != "\x02\x01" {
-		panic("Invalid D2P file")
+		return nil, errors.New("invalid d2p file: wrong header")
 	}
 
 	stream.Seek(-24, io.SeekEnd)
@@ -37,43 +67,159 @@ func NewD2P(stream io.ReadWriteSeeker) *D2P {
 	d2p.propertiesOffset = d2pFileBinary.ReadUint32()
 	d2p.numberProperties = d2pFileBinary.ReadUint32()
 
+	if int64(d2p.indexesOffset) > size || int64(d2p.propertiesOffset) > size {
+		return nil, errors.New("invalid d2p file: index outside of the file")
+	}
+
 	stream.Seek(int64(d2p.indexesOffset), io.SeekStart)
 	for i := uint32(0); i < d2p.numberIndexes; i++ {
-		fileName := d2pFileBinary.ReadString()
-		offset := d2pFileBinary.ReadUint32()
-		length := d2pFileBinary.ReadUint32()
-		d2p.filesPosition[fileName] = map[string]uint32{
-			"offset": offset + d2p.baseOffset,
-			"length": length,
+		entry := D2PEntry{archive: d2p}
+		entry.Name = d2pFileBinary.ReadString()
+		entry.Offset = int64(d2pFileBinary.ReadUint32()) + int64(d2p.baseOffset)
+		entry.Length = int64(d2pFileBinary.ReadUint32())
+		if entry.Offset+entry.Length > size {
+			return nil, fmt.Errorf("invalid d2p file: %s is outside of the file", entry.Name)
 		}
+		d2p.entryByName[entry.Name] = len(d2p.entries)
+		d2p.entries = append(d2p.entries, entry)
 	}
 
 	stream.Seek(int64(d2p.propertiesOffset), io.SeekStart)
-
 	for i := uint32(0); i < d2p.numberProperties; i++ {
 		pptyType := d2pFileBinary.ReadString()
 		pptyValue := d2pFileBinary.ReadString()
 		d2p.properties[pptyType] = pptyValue
 	}
 
-	for fileName, position := range d2p.filesPosition {
-		d2p.Stream.Seek(int64(position["offset"]), io.SeekStart)
-		file := d2pFileBinary.ReadBytes(int(position["length"]))
-		d2p.files[fileName] = file
-	}
+	return d2p, nil
+}
 
-	return d2p
+// OpenD2P opens the D2P archive at path and the archives it links to with its
+// "link" property, which names a file in the same directory. Close closes all
+// of them.
+func OpenD2P(path string) (*D2P, error) {
+	return openD2P(path, make(map[string]bool))
 }
 
-func (d2p *D2P) GetFiles() map[string]map[string]interface{} {
-	toReturn := make(map[string]map[string]interface{})
-	for fileName, position := range d2p.filesPosition {
-		object_ := make(map[string]interface{})
-		object_["position"] = position
-		if d2p.files != nil {
-			object_["binary"] = d2p.files[fileName]
+func openD2P(path string, visited map[string]bool) (*D2P, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if visited[absPath] {
+		return nil, fmt.Errorf("d2p link cycle at %s", path)
+	}
+	visited[absPath] = true
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d2p, err := NewD2P(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	d2p.file = f
+
+	if link, ok := d2p.properties["link"]; ok && link != "" {
+		d2p.link, err = openD2P(filepath.Join(filepath.Dir(path), link), visited)
+		if err != nil {
+			f.Close()
+			return nil, err
 		}
-		toReturn[fileName] = object_
 	}
-	return toReturn
+
+	return d2p, nil
+}
+
+// Close closes the files opened by OpenD2P.
+func (d2p *D2P) Close() error {
+	var err error
+	if d2p.link != nil {
+		err = d2p.link.Close()
+	}
+	if d2p.file != nil {
+		err = errors.Join(d2p.file.Close(), err)
+		d2p.file = nil
+	}
+	return err
+}
+
+// Properties returns the properties of the archive, like "link".
+func (d2p *D2P) Properties() map[string]string {
+	return d2p.properties
+}
+
+// Link returns the linked archive opened by OpenD2P, nil if there is none.
+func (d2p *D2P) Link() *D2P {
+	return d2p.link
+}
+
+// Entries returns the files of the archive and its linked archives in file
+// order.
+func (d2p *D2P) Entries() []D2PEntry {
+	entries := append([]D2PEntry(nil), d2p.entries...)
+	if d2p.link != nil {
+		entries = append(entries, d2p.link.Entries()...)
+	}
+	return entries
+}
+
+// Entry returns the file called name of the archive or its linked archives.
+func (d2p *D2P) Entry(name string) (D2PEntry, bool) {
+	if i, ok := d2p.entryByName[name]; ok {
+		return d2p.entries[i], true
+	}
+	if d2p.link != nil {
+		return d2p.link.Entry(name)
+	}
+	return D2PEntry{}, false
+}
+
+// Open returns a reader for the file called name. It reads from the archive
+// while it is used if the stream supports io.ReaderAt, like *os.File does.
+func (d2p *D2P) Open(name string) (io.Reader, error) {
+	entry, ok := d2p.Entry(name)
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the d2p archive", name)
+	}
+	return entry.Open()
+}
+
+// ReadFile reads the file called name.
+func (d2p *D2P) ReadFile(name string) ([]byte, error) {
+	entry, ok := d2p.Entry(name)
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the d2p archive", name)
+	}
+	return entry.Read()
+}
+
+// Open returns a reader for the file, see D2P.Open.
+func (entry D2PEntry) Open() (io.Reader, error) {
+	if readerAt, ok := entry.archive.Stream.(io.ReaderAt); ok {
+		return io.NewSectionReader(readerAt, entry.Offset, entry.Length), nil
+	}
+
+	data, err := entry.Read()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// Read reads the file into memory.
+func (entry D2PEntry) Read() ([]byte, error) {
+	stream := entry.archive.Stream
+	if _, err := stream.Seek(entry.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, entry.Length)
+	if _, err := io.ReadFull(stream, data); err != nil {
+		return nil, fmt.Errorf("%s: %w", entry.Name, err)
+	}
+	return data, nil
 }
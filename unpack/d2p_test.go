@@ -0,0 +1,106 @@
+package unpack
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// maps0.d2p holds a compressed map and links to maps1.d2p, which holds an
+// empty file and a file in a sub directory.
+func TestD2PLink(t *testing.T) {
+	archive, err := OpenD2P(filepath.Join("testdata", "maps0.d2p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	var names []string
+	for _, entry := range archive.Entries() {
+		names = append(names, entry.Name)
+	}
+	if want := []string{"8/395788.dlm", "readme.txt", "empty.bin", "second/readme.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Entries() = %v, want %v", names, want)
+	}
+
+	if archive.Link() == nil || archive.Link().Properties()["owner"] != "doduda" {
+		t.Errorf("maps1.d2p is not linked")
+	}
+
+	for name, want := range map[string]string{"readme.txt": "first archive\n", "second/readme.txt": "second archive\n", "empty.bin": ""} {
+		data, err := archive.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("ReadFile(%q) = %q, want %q", name, data, want)
+		}
+
+		reader, err := archive.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("Open(%q) reads %q, want %q", name, data, want)
+		}
+	}
+
+	if _, err := archive.ReadFile("missing.txt"); err == nil {
+		t.Error("reading a missing file did not fail")
+	}
+}
+
+func TestD2PRoundTrip(t *testing.T) {
+	for _, name := range []string{"maps0.d2p", "maps1.d2p"} {
+		data := readTestdata(t, name)
+		archive, err := NewD2P(NewMemoryStream(append([]byte(nil), data...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writer := NewD2PWriter()
+		for _, entry := range archive.Entries() {
+			content, err := entry.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.AddFile(entry.Name, content)
+		}
+		for key, value := range archive.Properties() {
+			writer.SetProperty(key, value)
+		}
+
+		var written bytes.Buffer
+		n, err := writer.WriteTo(&written)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(written.Len()) {
+			t.Errorf("%s: WriteTo returned %d for %d bytes", name, n, written.Len())
+		}
+		if !bytes.Equal(written.Bytes(), data) {
+			t.Errorf("%s: written archive differs (%d vs %d bytes)", name, written.Len(), len(data))
+		}
+	}
+}
+
+func TestD2PMalformed(t *testing.T) {
+	data := readTestdata(t, "maps1.d2p")
+
+	tests := map[string][]byte{
+		"too small":    data[:20],
+		"wrong header": append([]byte{1, 2}, data[2:]...),
+		"truncated":    append(append([]byte(nil), data[:2]...), data[len(data)-24:]...),
+	}
+	for name, data := range tests {
+		if _, err := NewD2P(NewMemoryStream(data)); err == nil {
+			t.Errorf("%s: reading did not fail", name)
+		}
+	}
+}
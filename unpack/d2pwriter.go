@@ -0,0 +1,181 @@
+package unpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type d2pWriterEntry struct {
+	name string
+	data []byte
+	path string // read when writing if data is nil
+}
+
+// D2PWriter packs files into a D2P archive. Files added from disk are only
+// read while the archive is written.
+type D2PWriter struct {
+	entries    []d2pWriterEntry
+	properties [][2]string
+}
+
+func NewD2PWriter() *D2PWriter {
+	return &D2PWriter{}
+}
+
+// AddFile adds a file with the given content. Names use "/" as separator.
+func (w *D2PWriter) AddFile(name string, data []byte) {
+	w.entries = append(w.entries, d2pWriterEntry{name: name, data: data})
+}
+
+// AddDir adds all files below dir, named by their path relative to dir and
+// sorted by name.
+func (w *D2PWriter) AddDir(dir string) error {
+	var entries []d2pWriterEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, d2pWriterEntry{name: filepath.ToSlash(name), path: path})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	w.entries = append(w.entries, entries...)
+	return nil
+}
+
+// SetProperty sets a property of the archive. The "link" property names the
+// next archive of a chain, see OpenD2P.
+func (w *D2PWriter) SetProperty(key string, value string) {
+	for i := range w.properties {
+		if w.properties[i][0] == key {
+			w.properties[i][1] = value
+			return
+		}
+	}
+	w.properties = append(w.properties, [2]string{key, value})
+}
+
+// d2pOutput writes big endian values and counts the written bytes. The first
+// error is kept and stops all further writes.
+type d2pOutput struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (o *d2pOutput) write(data []byte) {
+	if o.err != nil {
+		return
+	}
+	n, err := o.w.Write(data)
+	o.n += int64(n)
+	o.err = err
+}
+
+func (o *d2pOutput) writeUint32(value uint32) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], value)
+	o.write(data[:])
+}
+
+func (o *d2pOutput) writeString(value string) {
+	if len(value) > math.MaxUint16 {
+		if o.err == nil {
+			o.err = fmt.Errorf("string too long for d2p: %d bytes", len(value))
+		}
+		return
+	}
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(value)))
+	o.write(length[:])
+	o.write([]byte(value))
+}
+
+func (o *d2pOutput) writeFile(path string) int64 {
+	if o.err != nil {
+		return 0
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		o.err = err
+		return 0
+	}
+	defer f.Close()
+
+	n, err := io.Copy(o.w, f)
+	o.n += n
+	o.err = err
+	return n
+}
+
+// WriteTo writes the archive to out: the header, the files, their index, the
+// properties and the positions of those parts.
+func (w *D2PWriter) WriteTo(out io.Writer) (int64, error) {
+	output := &d2pOutput{w: bufio.NewWriter(out)}
+
+	output.write([]byte{2, 1})
+	baseOffset := output.n
+
+	offsets := make([]int64, len(w.entries))
+	lengths := make([]int64, len(w.entries))
+	for i, entry := range w.entries {
+		offsets[i] = output.n - baseOffset
+		if entry.data != nil {
+			output.write(entry.data)
+			lengths[i] = int64(len(entry.data))
+		} else {
+			lengths[i] = output.writeFile(entry.path)
+		}
+	}
+	baseLength := output.n - baseOffset
+
+	indexesOffset := output.n
+	for i, entry := range w.entries {
+		output.writeString(entry.name)
+		output.writeUint32(uint32(offsets[i]))
+		output.writeUint32(uint32(lengths[i]))
+	}
+
+	propertiesOffset := output.n
+	for _, property := range w.properties {
+		output.writeString(property[0])
+		output.writeString(property[1])
+	}
+
+	if output.err == nil && output.n > math.MaxUint32 {
+		output.err = errors.New("d2p archive larger than 4 GiB")
+	}
+
+	output.writeUint32(uint32(baseOffset))
+	output.writeUint32(uint32(baseLength))
+	output.writeUint32(uint32(indexesOffset))
+	output.writeUint32(uint32(len(w.entries)))
+	output.writeUint32(uint32(propertiesOffset))
+	output.writeUint32(uint32(len(w.properties)))
+
+	if output.err != nil {
+		return output.n, output.err
+	}
+	return output.n, output.w.Flush()
+}
@@ -0,0 +1,471 @@
+package unpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultDLMKey decrypts the maps of Dofus 2 since map version 7.
+const DefaultDLMKey = "649ae451ca33ec53bbcbcc33becf15f4"
+
+// DLMCellsCount is the number of cells of a Dofus 2 map.
+const DLMCellsCount = 560
+
+const (
+	dlmHeader            = 77
+	dlmElementGraphical  = 2
+	dlmElementSound      = 33
+	dlmEmptyCellFloor    = -1280
+	dlmCellHalfWidth     = 43
+	dlmCellHalfHeight    = 21.5
+	dlmWorldPointMask    = 0x3FFC0000
+	dlmCoordinateMask    = 0x1FF
+	dlmCoordinateNegBit  = 0x100
+	dlmCoordinateAbsMask = 0xFF
+)
+
+// DLMColor is a color of a map, the multiplicators of fixtures and elements
+// are signed.
+type DLMColor struct {
+	Red   int `json:"red"`
+	Green int `json:"green"`
+	Blue  int `json:"blue"`
+	Alpha int `json:"alpha"`
+}
+
+type DLMFixture struct {
+	FixtureID int32    `json:"fixture_id"`
+	OffsetX   int16    `json:"offset_x"`
+	OffsetY   int16    `json:"offset_y"`
+	Rotation  int16    `json:"rotation"`
+	ScaleX    int16    `json:"scale_x"`
+	ScaleY    int16    `json:"scale_y"`
+	Color     DLMColor `json:"color"`
+}
+
+type DLMGraphicalElement struct {
+	ElementID    uint32   `json:"element_id"`
+	Hue          DLMColor `json:"hue"`
+	Shadow       DLMColor `json:"shadow"`
+	OffsetX      float64  `json:"offset_x"`
+	OffsetY      float64  `json:"offset_y"`
+	PixelOffsetX float64  `json:"pixel_offset_x"`
+	PixelOffsetY float64  `json:"pixel_offset_y"`
+	Altitude     int8     `json:"altitude"`
+	Identifier   uint32   `json:"identifier"` // interactive element ID, 0 for decoration
+}
+
+type DLMSoundElement struct {
+	SoundID              int32 `json:"sound_id"`
+	BaseVolume           int16 `json:"base_volume"`
+	FullVolumeDistance   int32 `json:"full_volume_distance"`
+	NullVolumeDistance   int32 `json:"null_volume_distance"`
+	MinDelayBetweenLoops int16 `json:"min_delay_between_loops"`
+	MaxDelayBetweenLoops int16 `json:"max_delay_between_loops"`
+}
+
+// DLMElement is an element of a layer cell, either graphical or a sound.
+type DLMElement struct {
+	Type      int8                 `json:"type"`
+	Graphical *DLMGraphicalElement `json:"graphical,omitempty"`
+	Sound     *DLMSoundElement     `json:"sound,omitempty"`
+}
+
+type DLMLayerCell struct {
+	CellID   int16        `json:"cell_id"`
+	Elements []DLMElement `json:"elements"`
+}
+
+type DLMLayer struct {
+	LayerID int32          `json:"layer_id"`
+	Cells   []DLMLayerCell `json:"cells"`
+}
+
+// DLMCell holds the movement data of a cell. Cells without floor have only
+// their ID and Empty set.
+type DLMCell struct {
+	CellID                 int   `json:"cell_id"`
+	Empty                  bool  `json:"empty,omitempty"`
+	Floor                  int   `json:"floor"`
+	Mov                    bool  `json:"mov"`
+	Los                    bool  `json:"los"`
+	NonWalkableDuringFight bool  `json:"non_walkable_during_fight"`
+	NonWalkableDuringRP    bool  `json:"non_walkable_during_rp"`
+	Visible                bool  `json:"visible"`
+	FarmCell               bool  `json:"farm_cell"`
+	HavenbagCell           bool  `json:"havenbag_cell"`
+	Blue                   bool  `json:"blue"`
+	Red                    bool  `json:"red"`
+	TopArrow               bool  `json:"top_arrow"`
+	BottomArrow            bool  `json:"bottom_arrow"`
+	RightArrow             bool  `json:"right_arrow"`
+	LeftArrow              bool  `json:"left_arrow"`
+	Speed                  int8  `json:"speed"`
+	MapChangeData          uint8 `json:"map_change_data"`
+	MoveZone               uint8 `json:"move_zone"`
+	LinkedZone             uint8 `json:"linked_zone"`
+}
+
+// DLMInteractiveElement is a graphical element with an interactive
+// identifier, like a resource or a zaap.
+type DLMInteractiveElement struct {
+	Identifier uint32 `json:"identifier"`
+	ElementID  uint32 `json:"element_id"`
+	CellID     int16  `json:"cell_id"`
+	LayerID    int32  `json:"layer_id"`
+}
+
+// DLM is a decoded Dofus 2 map. WorldID, X and Y are the world point
+// encoded in the map ID, they can differ from the coordinates the game shows
+// which are in MapPositions.d2o.
+type DLM struct {
+	Version                int8                    `json:"version"`
+	ID                     uint32                  `json:"id"`
+	WorldID                int                     `json:"world_id"`
+	X                      int                     `json:"x"`
+	Y                      int                     `json:"y"`
+	Encrypted              bool                    `json:"encrypted"`
+	EncryptionVersion      int8                    `json:"encryption_version"`
+	RelativeID             uint32                  `json:"relative_id"`
+	MapType                int8                    `json:"map_type"`
+	SubareaID              int32                   `json:"subarea_id"`
+	TopNeighbourID         int32                   `json:"top_neighbour_id"`
+	BottomNeighbourID      int32                   `json:"bottom_neighbour_id"`
+	LeftNeighbourID        int32                   `json:"left_neighbour_id"`
+	RightNeighbourID       int32                   `json:"right_neighbour_id"`
+	ShadowBonusOnEntities  uint32                  `json:"shadow_bonus_on_entities"`
+	BackgroundColor        DLMColor                `json:"background_color"`
+	GridColor              DLMColor                `json:"grid_color"`
+	ZoomScale              float64                 `json:"zoom_scale"`
+	ZoomOffsetX            int16                   `json:"zoom_offset_x"`
+	ZoomOffsetY            int16                   `json:"zoom_offset_y"`
+	TacticalModeTemplateID int32                   `json:"tactical_mode_template_id"`
+	UseLowPassFilter       bool                    `json:"use_low_pass_filter"`
+	UseReverb              bool                    `json:"use_reverb"`
+	PresetID               int32                   `json:"preset_id"`
+	BackgroundFixtures     []DLMFixture            `json:"background_fixtures"`
+	ForegroundFixtures     []DLMFixture            `json:"foreground_fixtures"`
+	GroundCRC              int32                   `json:"ground_crc"`
+	Layers                 []DLMLayer              `json:"layers"`
+	Cells                  []DLMCell               `json:"cells"`
+	InteractiveElements    []DLMInteractiveElement `json:"interactive_elements"`
+}
+
+// DecodeDLM decodes a map file. The data can be zlib compressed, like in the
+// map D2P archives, or already inflated. key decrypts encrypted maps, empty
+// uses DefaultDLMKey.
+func DecodeDLM(data []byte, key string) (dlm *DLM, err error) {
+	if key == "" {
+		key = DefaultDLMKey
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("empty dlm file")
+	}
+	if data[0] != dlmHeader {
+		inflater, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("inflate dlm: %w", err)
+		}
+		data, err = io.ReadAll(inflater)
+		if err != nil {
+			return nil, fmt.Errorf("inflate dlm: %w", err)
+		}
+		if len(data) == 0 || data[0] != dlmHeader {
+			return nil, errors.New("invalid dlm file: wrong header")
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed dlm file: %v", r)
+		}
+	}()
+
+	raw := NewBinaryStream(NewMemoryStream(data), true)
+	raw.ReadChar() // header
+
+	dlm = &DLM{}
+	dlm.Version = raw.ReadChar()
+	dlm.ID = raw.ReadUint32()
+	dlm.WorldID, dlm.X, dlm.Y = WorldPointFromMapID(dlm.ID)
+
+	if dlm.Version >= 7 {
+		dlm.Encrypted = raw.ReadBool()
+		dlm.EncryptionVersion = raw.ReadChar()
+		dataLen := raw.ReadInt32()
+		if dlm.Encrypted {
+			encrypted := raw.ReadBytes(int(dataLen))
+			for i := range encrypted {
+				encrypted[i] ^= key[i%len(key)]
+			}
+			raw = NewBinaryStream(NewMemoryStream(encrypted), true)
+		}
+	}
+
+	dlm.RelativeID = raw.ReadUint32()
+	dlm.MapType = raw.ReadChar()
+	dlm.SubareaID = raw.ReadInt32()
+	dlm.TopNeighbourID = raw.ReadInt32()
+	dlm.BottomNeighbourID = raw.ReadInt32()
+	dlm.LeftNeighbourID = raw.ReadInt32()
+	dlm.RightNeighbourID = raw.ReadInt32()
+	dlm.ShadowBonusOnEntities = raw.ReadUint32()
+
+	if dlm.Version >= 9 {
+		dlm.BackgroundColor = argbColor(raw.ReadUint32())
+		dlm.GridColor = argbColor(raw.ReadUint32())
+	} else if dlm.Version >= 3 {
+		dlm.BackgroundColor = DLMColor{Red: int(raw.ReadChar()), Green: int(raw.ReadChar()), Blue: int(raw.ReadChar())}
+	}
+
+	if dlm.Version >= 4 {
+		dlm.ZoomScale = float64(raw.ReadUint16()) / 100
+		dlm.ZoomOffsetX = raw.ReadInt16()
+		dlm.ZoomOffsetY = raw.ReadInt16()
+		if dlm.ZoomScale < 1 {
+			dlm.ZoomScale = 1
+			dlm.ZoomOffsetX = 0
+			dlm.ZoomOffsetY = 0
+		}
+	}
+
+	if dlm.Version > 10 {
+		dlm.TacticalModeTemplateID = raw.ReadInt32()
+	}
+
+	dlm.UseLowPassFilter = raw.ReadChar() == 1
+	dlm.UseReverb = raw.ReadChar() == 1
+	if dlm.UseReverb {
+		dlm.PresetID = raw.ReadInt32()
+	} else {
+		dlm.PresetID = -1
+	}
+
+	backgroundsCount := int(raw.ReadChar())
+	dlm.BackgroundFixtures = make([]DLMFixture, 0, max(backgroundsCount, 0))
+	for i := 0; i < backgroundsCount; i++ {
+		dlm.BackgroundFixtures = append(dlm.BackgroundFixtures, readDLMFixture(raw))
+	}
+
+	foregroundsCount := int(raw.ReadChar())
+	dlm.ForegroundFixtures = make([]DLMFixture, 0, max(foregroundsCount, 0))
+	for i := 0; i < foregroundsCount; i++ {
+		dlm.ForegroundFixtures = append(dlm.ForegroundFixtures, readDLMFixture(raw))
+	}
+
+	raw.ReadInt32() // unused
+	dlm.GroundCRC = raw.ReadInt32()
+
+	layersCount := int(raw.ReadChar())
+	dlm.Layers = make([]DLMLayer, 0, max(layersCount, 0))
+	for i := 0; i < layersCount; i++ {
+		layer, err := readDLMLayer(raw, dlm.Version)
+		if err != nil {
+			return nil, err
+		}
+		dlm.Layers = append(dlm.Layers, layer)
+	}
+
+	dlm.Cells = make([]DLMCell, DLMCellsCount)
+	for i := range dlm.Cells {
+		// BinaryStream reads zeros past the end, so a truncated file would
+		// otherwise decode into empty cells.
+		if raw.BytesAvailable() <= 0 {
+			return nil, fmt.Errorf("truncated dlm file: %d of %d cells", i, DLMCellsCount)
+		}
+		dlm.Cells[i] = readDLMCell(raw, dlm.Version, i)
+	}
+
+	dlm.InteractiveElements = make([]DLMInteractiveElement, 0)
+	for _, layer := range dlm.Layers {
+		for _, cell := range layer.Cells {
+			for _, element := range cell.Elements {
+				if element.Graphical == nil || element.Graphical.Identifier == 0 {
+					continue
+				}
+				dlm.InteractiveElements = append(dlm.InteractiveElements, DLMInteractiveElement{
+					Identifier: element.Graphical.Identifier,
+					ElementID:  element.Graphical.ElementID,
+					CellID:     cell.CellID,
+					LayerID:    layer.LayerID,
+				})
+			}
+		}
+	}
+
+	return dlm, nil
+}
+
+// WorldPointFromMapID returns the world and coordinates encoded in a map ID.
+func WorldPointFromMapID(id uint32) (worldID int, x int, y int) {
+	worldID = int((id & dlmWorldPointMask) >> 18)
+	x = int(id>>9) & dlmCoordinateMask
+	y = int(id) & dlmCoordinateMask
+	if x&dlmCoordinateNegBit == dlmCoordinateNegBit {
+		x = -(x & dlmCoordinateAbsMask)
+	}
+	if y&dlmCoordinateNegBit == dlmCoordinateNegBit {
+		y = -(y & dlmCoordinateAbsMask)
+	}
+	return worldID, x, y
+}
+
+func argbColor(color uint32) DLMColor {
+	return DLMColor{
+		Alpha: int(color >> 24 & 0xFF),
+		Red:   int(color >> 16 & 0xFF),
+		Green: int(color >> 8 & 0xFF),
+		Blue:  int(color & 0xFF),
+	}
+}
+
+func readDLMFixture(raw *BinaryStream) DLMFixture {
+	fixture := DLMFixture{}
+	fixture.FixtureID = raw.ReadInt32()
+	fixture.OffsetX = raw.ReadInt16()
+	fixture.OffsetY = raw.ReadInt16()
+	fixture.Rotation = raw.ReadInt16()
+	fixture.ScaleX = raw.ReadInt16()
+	fixture.ScaleY = raw.ReadInt16()
+	fixture.Color.Red = int(raw.ReadChar())
+	fixture.Color.Green = int(raw.ReadChar())
+	fixture.Color.Blue = int(raw.ReadChar())
+	fixture.Color.Alpha = int(raw.ReadUchar())
+	return fixture
+}
+
+func readDLMColorMultiplicator(raw *BinaryStream) DLMColor {
+	return DLMColor{Red: int(raw.ReadChar()), Green: int(raw.ReadChar()), Blue: int(raw.ReadChar())}
+}
+
+func readDLMLayer(raw *BinaryStream, version int8) (DLMLayer, error) {
+	layer := DLMLayer{}
+	if version >= 9 {
+		layer.LayerID = int32(raw.ReadChar())
+	} else {
+		layer.LayerID = raw.ReadInt32()
+	}
+
+	cellsCount := int(raw.ReadInt16())
+	layer.Cells = make([]DLMLayerCell, 0, max(cellsCount, 0))
+	for i := 0; i < cellsCount; i++ {
+		cell := DLMLayerCell{CellID: raw.ReadInt16()}
+		elementsCount := int(raw.ReadInt16())
+		cell.Elements = make([]DLMElement, 0, max(elementsCount, 0))
+		for j := 0; j < elementsCount; j++ {
+			element := DLMElement{Type: raw.ReadChar()}
+			switch element.Type {
+			case dlmElementGraphical:
+				element.Graphical = readDLMGraphicalElement(raw, version)
+			case dlmElementSound:
+				element.Sound = &DLMSoundElement{
+					SoundID:              raw.ReadInt32(),
+					BaseVolume:           raw.ReadInt16(),
+					FullVolumeDistance:   raw.ReadInt32(),
+					NullVolumeDistance:   raw.ReadInt32(),
+					MinDelayBetweenLoops: raw.ReadInt16(),
+					MaxDelayBetweenLoops: raw.ReadInt16(),
+				}
+			default:
+				return layer, fmt.Errorf("unknown element type %d in layer %d cell %d", element.Type, layer.LayerID, cell.CellID)
+			}
+			cell.Elements = append(cell.Elements, element)
+		}
+		layer.Cells = append(layer.Cells, cell)
+	}
+
+	return layer, nil
+}
+
+func readDLMGraphicalElement(raw *BinaryStream, version int8) *DLMGraphicalElement {
+	element := &DLMGraphicalElement{}
+	element.ElementID = raw.ReadUint32()
+	element.Hue = readDLMColorMultiplicator(raw)
+	element.Shadow = readDLMColorMultiplicator(raw)
+
+	if version <= 4 {
+		element.OffsetX = float64(raw.ReadChar())
+		element.OffsetY = float64(raw.ReadChar())
+		element.PixelOffsetX = element.OffsetX * dlmCellHalfWidth
+		element.PixelOffsetY = element.OffsetY * dlmCellHalfHeight
+	} else {
+		element.PixelOffsetX = float64(raw.ReadInt16())
+		element.PixelOffsetY = float64(raw.ReadInt16())
+		element.OffsetX = element.PixelOffsetX / dlmCellHalfWidth
+		element.OffsetY = element.PixelOffsetY / dlmCellHalfHeight
+	}
+
+	element.Altitude = raw.ReadChar()
+	element.Identifier = raw.ReadUint32()
+	return element
+}
+
+func readDLMCell(raw *BinaryStream, version int8, cellID int) DLMCell {
+	cell := DLMCell{CellID: cellID}
+	cell.Floor = int(raw.ReadChar()) * 10
+	if cell.Floor == dlmEmptyCellFloor {
+		cell.Empty = true
+		return cell
+	}
+
+	if version >= 9 {
+		flags := raw.ReadInt16()
+		cell.Mov = flags&1 == 0
+		cell.NonWalkableDuringFight = flags&2 != 0
+		cell.NonWalkableDuringRP = flags&4 != 0
+		cell.Los = flags&8 == 0
+		cell.Blue = flags&16 != 0
+		cell.Red = flags&32 != 0
+		cell.Visible = flags&64 != 0
+		cell.FarmCell = flags&128 != 0
+		if version >= 10 {
+			cell.HavenbagCell = flags&256 != 0
+			cell.TopArrow = flags&512 != 0
+			cell.BottomArrow = flags&1024 != 0
+			cell.RightArrow = flags&2048 != 0
+			cell.LeftArrow = flags&4096 != 0
+		} else {
+			cell.TopArrow = flags&256 != 0
+			cell.BottomArrow = flags&512 != 0
+			cell.RightArrow = flags&1024 != 0
+			cell.LeftArrow = flags&2048 != 0
+		}
+	} else {
+		losmov := raw.ReadUchar()
+		cell.Los = losmov&2 != 0
+		cell.Mov = losmov&1 != 0
+		cell.Visible = losmov&64 != 0
+		cell.FarmCell = losmov&32 != 0
+		cell.Blue = losmov&16 != 0
+		cell.Red = losmov&8 != 0
+		cell.NonWalkableDuringRP = losmov&128 != 0
+		cell.NonWalkableDuringFight = losmov&4 != 0
+	}
+
+	cell.Speed = raw.ReadChar()
+	cell.MapChangeData = raw.ReadUchar()
+
+	if version > 5 {
+		cell.MoveZone = raw.ReadUchar()
+	}
+
+	hasLinkedZoneRP := cell.Mov && !cell.FarmCell
+	hasLinkedZoneFight := cell.Mov && !cell.NonWalkableDuringFight && !cell.FarmCell && !cell.HavenbagCell
+	if version > 10 && (hasLinkedZoneRP || hasLinkedZoneFight) {
+		cell.LinkedZone = raw.ReadUchar()
+	}
+
+	if version > 7 && version < 9 {
+		arrows := raw.ReadChar()
+		cell.TopArrow = arrows&1 != 0
+		cell.BottomArrow = arrows&2 != 0
+		cell.RightArrow = arrows&4 != 0
+		cell.LeftArrow = arrows&8 != 0
+	}
+
+	return cell
+}
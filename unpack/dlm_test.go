@@ -0,0 +1,82 @@
+package unpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// readMap returns the map of maps0.d2p: version 11, encrypted and compressed,
+// with fixtures, a layer with a decoration and an interactive element, a
+// layer with a sound and cells with every kind of flags.
+func readMap(t *testing.T) []byte {
+	t.Helper()
+
+	archive, err := OpenD2P(filepath.Join("testdata", "maps0.d2p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	data, err := archive.ReadFile("8/395788.dlm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeDLM(t *testing.T) {
+	dlm, err := DecodeDLM(readMap(t), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dlm.WorldID != 1 || dlm.X != -5 || dlm.Y != 12 {
+		t.Errorf("world point = %d (%d, %d), want 1 (-5, 12)", dlm.WorldID, dlm.X, dlm.Y)
+	}
+
+	empty := 0
+	for _, cell := range dlm.Cells {
+		if cell.Empty {
+			empty++
+		}
+	}
+	if empty != 80 {
+		t.Errorf("%d empty cells, want 80", empty)
+	}
+
+	// the first cells already have every kind of flags
+	dlm.Cells = dlm.Cells[:16]
+	goldenJSON(t, "395788.dlm.json", dlm)
+}
+
+func TestDecodeDLMInflated(t *testing.T) {
+	inflater, err := zlib.NewReader(bytes.NewReader(readMap(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inflated, err := io.ReadAll(inflater)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dlm, err := DecodeDLM(inflated, DefaultDLMKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dlm.SubareaID != 4711 || len(dlm.InteractiveElements) != 1 {
+		t.Errorf("decoded subarea %d with %d interactive elements, want 4711 with 1", dlm.SubareaID, len(dlm.InteractiveElements))
+	}
+
+	if _, err := DecodeDLM(inflated, "00000000000000000000000000000000"); err == nil {
+		t.Error("decoding with the wrong key did not fail")
+	}
+	if _, err := DecodeDLM(inflated[:len(inflated)-200], ""); err == nil {
+		t.Error("decoding a truncated map did not fail")
+	}
+	if _, err := DecodeDLM(nil, ""); err == nil {
+		t.Error("decoding an empty file did not fail")
+	}
+}
@@ -0,0 +1,242 @@
+package unpack
+
+var etc1Modifiers = [8][2]int{
+	{2, 8}, {5, 17}, {9, 29}, {13, 42}, {18, 60}, {24, 80}, {33, 106}, {47, 183},
+}
+
+var etc2Distances = [8]int{3, 6, 11, 16, 23, 32, 41, 64}
+
+var eacModifiers = [16][8]int{
+	{-3, -6, -9, -15, 2, 5, 8, 14},
+	{-3, -7, -10, -13, 2, 6, 9, 12},
+	{-2, -5, -8, -13, 1, 4, 7, 12},
+	{-2, -4, -6, -13, 1, 3, 5, 12},
+	{-3, -6, -8, -12, 2, 5, 7, 11},
+	{-3, -7, -9, -11, 2, 6, 8, 10},
+	{-4, -7, -8, -11, 3, 6, 7, 10},
+	{-3, -5, -8, -11, 2, 4, 7, 10},
+	{-2, -6, -8, -10, 1, 5, 7, 9},
+	{-2, -5, -8, -10, 1, 4, 7, 9},
+	{-2, -4, -8, -10, 1, 3, 7, 9},
+	{-2, -5, -7, -10, 1, 4, 6, 9},
+	{-3, -4, -7, -10, 2, 3, 6, 9},
+	{-1, -2, -3, -10, 0, 1, 2, 9},
+	{-4, -6, -8, -9, 3, 5, 7, 8},
+	{-3, -5, -7, -9, 2, 4, 6, 8},
+}
+
+func bitsAt(block uint64, high int, low int) int {
+	return int(block>>uint(low)) & (1<<uint(high-low+1) - 1)
+}
+
+func extend4(v int) int {
+	return v<<4 | v
+}
+
+func extend5i(v int) int {
+	return v<<3 | v>>2
+}
+
+// etcPixelIndex returns the 2 bit index of pixel (x, y). ETC stores the
+// indices column by column with the most significant bits in the upper half.
+func etcPixelIndex(block uint64, x int, y int) int {
+	i := x*4 + y
+	return int(block>>uint(16+i))&1<<1 | int(block>>uint(i))&1
+}
+
+// decodeETC2Block decodes an ETC1 or ETC2 RGB block (big endian). With
+// punchthrough the differential bit is the opaque flag of ETC2 RGBA1.
+func decodeETC2Block(block uint64, out *[16][4]uint8, etc2 bool, punchthrough bool) {
+	diff := block>>33&1 != 0
+	opaque := true
+	if punchthrough {
+		opaque = diff
+		diff = true
+	}
+
+	if !diff {
+		r1, r2 := extend4(bitsAt(block, 63, 60)), extend4(bitsAt(block, 59, 56))
+		g1, g2 := extend4(bitsAt(block, 55, 52)), extend4(bitsAt(block, 51, 48))
+		b1, b2 := extend4(bitsAt(block, 47, 44)), extend4(bitsAt(block, 43, 40))
+		decodeETCSubblocks(block, out, [2][3]int{{r1, g1, b1}, {r2, g2, b2}}, true)
+		return
+	}
+
+	r := bitsAt(block, 63, 59)
+	g := bitsAt(block, 55, 51)
+	b := bitsAt(block, 47, 43)
+	dr := signExtend3(bitsAt(block, 58, 56))
+	dg := signExtend3(bitsAt(block, 50, 48))
+	db := signExtend3(bitsAt(block, 42, 40))
+
+	if etc2 {
+		switch {
+		case r+dr < 0 || r+dr > 31:
+			decodeETC2TMode(block, out, opaque)
+			return
+		case g+dg < 0 || g+dg > 31:
+			decodeETC2HMode(block, out, opaque)
+			return
+		case b+db < 0 || b+db > 31:
+			decodeETC2PlanarMode(block, out)
+			return
+		}
+	}
+
+	base := [2][3]int{
+		{extend5i(r), extend5i(g), extend5i(b)},
+		{extend5i(r + dr), extend5i(g + dg), extend5i(b + db)},
+	}
+	decodeETCSubblocks(block, out, base, opaque)
+}
+
+func signExtend3(v int) int {
+	if v >= 4 {
+		return v - 8
+	}
+	return v
+}
+
+func decodeETCSubblocks(block uint64, out *[16][4]uint8, base [2][3]int, opaque bool) {
+	flip := block>>32&1 != 0
+	tables := [2]int{bitsAt(block, 39, 37), bitsAt(block, 36, 34)}
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			subblock := 0
+			if (!flip && x >= 2) || (flip && y >= 2) {
+				subblock = 1
+			}
+
+			index := etcPixelIndex(block, x, y)
+			if !opaque && index == 2 {
+				out[y*4+x] = [4]uint8{0, 0, 0, 0}
+				continue
+			}
+
+			modifier := etc1Modifiers[tables[subblock]][index&1]
+			if !opaque && index == 0 {
+				modifier = 0
+			}
+			if index&2 != 0 {
+				modifier = -modifier
+			}
+
+			color := base[subblock]
+			out[y*4+x] = [4]uint8{
+				clampColor(color[0] + modifier),
+				clampColor(color[1] + modifier),
+				clampColor(color[2] + modifier),
+				255,
+			}
+		}
+	}
+}
+
+func writeETCPaintColors(block uint64, out *[16][4]uint8, paint [4][3]int, opaque bool) {
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			index := etcPixelIndex(block, x, y)
+			if !opaque && index == 2 {
+				out[y*4+x] = [4]uint8{0, 0, 0, 0}
+				continue
+			}
+			color := paint[index]
+			out[y*4+x] = [4]uint8{clampColor(color[0]), clampColor(color[1]), clampColor(color[2]), 255}
+		}
+	}
+}
+
+func decodeETC2TMode(block uint64, out *[16][4]uint8, opaque bool) {
+	c1 := [3]int{
+		extend4(bitsAt(block, 60, 59)<<2 | bitsAt(block, 57, 56)),
+		extend4(bitsAt(block, 55, 52)),
+		extend4(bitsAt(block, 51, 48)),
+	}
+	c2 := [3]int{
+		extend4(bitsAt(block, 47, 44)),
+		extend4(bitsAt(block, 43, 40)),
+		extend4(bitsAt(block, 39, 36)),
+	}
+	d := etc2Distances[bitsAt(block, 35, 34)<<1|bitsAt(block, 32, 32)]
+
+	paint := [4][3]int{
+		c1,
+		{c2[0] + d, c2[1] + d, c2[2] + d},
+		c2,
+		{c2[0] - d, c2[1] - d, c2[2] - d},
+	}
+	writeETCPaintColors(block, out, paint, opaque)
+}
+
+func decodeETC2HMode(block uint64, out *[16][4]uint8, opaque bool) {
+	r1 := bitsAt(block, 62, 59)
+	g1 := bitsAt(block, 58, 56)<<1 | bitsAt(block, 52, 52)
+	b1 := bitsAt(block, 51, 51)<<3 | bitsAt(block, 49, 47)
+	r2 := bitsAt(block, 46, 43)
+	g2 := bitsAt(block, 42, 39)
+	b2 := bitsAt(block, 38, 35)
+
+	index := bitsAt(block, 34, 34)<<2 | bitsAt(block, 32, 32)<<1
+	if r1<<8|g1<<4|b1 >= r2<<8|g2<<4|b2 {
+		index |= 1
+	}
+	d := etc2Distances[index]
+
+	c1 := [3]int{extend4(r1), extend4(g1), extend4(b1)}
+	c2 := [3]int{extend4(r2), extend4(g2), extend4(b2)}
+	paint := [4][3]int{
+		{c1[0] + d, c1[1] + d, c1[2] + d},
+		{c1[0] - d, c1[1] - d, c1[2] - d},
+		{c2[0] + d, c2[1] + d, c2[2] + d},
+		{c2[0] - d, c2[1] - d, c2[2] - d},
+	}
+	writeETCPaintColors(block, out, paint, opaque)
+}
+
+func decodeETC2PlanarMode(block uint64, out *[16][4]uint8) {
+	extend6 := func(v int) int { return v<<2 | v>>4 }
+	extend7 := func(v int) int { return v<<1 | v>>6 }
+
+	o := [3]int{
+		extend6(bitsAt(block, 62, 57)),
+		extend7(bitsAt(block, 56, 56)<<6 | bitsAt(block, 54, 49)),
+		extend6(bitsAt(block, 48, 48)<<5 | bitsAt(block, 44, 43)<<3 | bitsAt(block, 41, 39)),
+	}
+	h := [3]int{
+		extend6(bitsAt(block, 38, 34)<<1 | bitsAt(block, 32, 32)),
+		extend7(bitsAt(block, 31, 25)),
+		extend6(bitsAt(block, 24, 19)),
+	}
+	v := [3]int{
+		extend6(bitsAt(block, 18, 13)),
+		extend7(bitsAt(block, 12, 6)),
+		extend6(bitsAt(block, 5, 0)),
+	}
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			var pixel [4]uint8
+			for c := 0; c < 3; c++ {
+				pixel[c] = clampColor((x*(h[c]-o[c]) + y*(v[c]-o[c]) + 4*o[c] + 2) >> 2)
+			}
+			pixel[3] = 255
+			out[y*4+x] = pixel
+		}
+	}
+}
+
+// decodeEACAlphaBlock decodes the EAC alpha half of an ETC2 RGBA8 block.
+func decodeEACAlphaBlock(block uint64, out *[16][4]uint8) {
+	base := bitsAt(block, 63, 56)
+	multiplier := bitsAt(block, 55, 52)
+	table := eacModifiers[bitsAt(block, 51, 48)]
+
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			i := x*4 + y
+			index := bitsAt(block, 47-3*i, 45-3*i)
+			out[y*4+x][3] = clampColor(base + table[index]*multiplier)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package unpack
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// I18nFile is a decoded Dofus 3 language file (Content/I18n/<lang>.bin).
+type I18nFile struct {
+	Entries map[int]string `json:"entries"`
+}
+
+// NewI18nFile decodes a Dofus 3 language file. The layout is little endian:
+//
+//	int32 count
+//	count * (int32 id, int32 offset)
+//	strings, each a 7 bit encoded length followed by UTF-8 bytes
+//
+// Offsets are absolute, several ids may point to the same string.
+func NewI18nFile(data []byte) (file *I18nFile, err error) {
+	defer recoverUnityError(&err)
+
+	r := newUnityReader(data, false)
+	count := int(r.ReadInt32())
+	if count < 0 || count*8 > len(data)-4 {
+		return nil, fmt.Errorf("invalid entry count %d", count)
+	}
+
+	file = &I18nFile{Entries: make(map[int]string, count)}
+	cache := make(map[int32]string)
+
+	for i := 0; i < count; i++ {
+		id := int(r.ReadInt32())
+		offset := r.ReadInt32()
+
+		text, ok := cache[offset]
+		if !ok {
+			if offset < 0 || int(offset) >= len(data) {
+				return nil, fmt.Errorf("entry %d points outside of the file", id)
+			}
+
+			strReader := newUnityReader(data, false)
+			strReader.pos = int(offset)
+			raw := strReader.ReadBytes(read7BitEncodedInt(strReader))
+			if !utf8.Valid(raw) {
+				return nil, fmt.Errorf("entry %d is not valid UTF-8", id)
+			}
+			text = string(raw)
+			cache[offset] = text
+		}
+
+		file.Entries[id] = text
+	}
+
+	return file, nil
+}
+
+// LanguageExtractStrings returns the texts of a Dofus 3 language file ordered
+// by their ID, a text used by several IDs is returned once.
+//
+// Deprecated: Use NewI18nFile, it keeps the IDs of the texts.
+func LanguageExtractStrings(data []byte) ([]string, error) {
+	file, err := NewI18nFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(file.Entries))
+	for id := range file.Entries {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	strings := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if text := file.Entries[id]; !seen[text] {
+			seen[text] = true
+			strings = append(strings, text)
+		}
+	}
+	return strings, nil
+}
+
+// read7BitEncodedInt reads a length prefix as written by .NET's BinaryWriter.
+func read7BitEncodedInt(r *unityReader) int {
+	value := 0
+	for shift := 0; shift < 35; shift += 7 {
+		b := r.ReadUint8()
+		value |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value
+		}
+	}
+	panic(fmt.Errorf("invalid 7 bit encoded length"))
+}
@@ -0,0 +1,61 @@
+package unpack
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// fr.bin has an empty string, a string longer than 127 bytes with a two byte
+// length and two IDs that point to the same string.
+func TestI18nFile(t *testing.T) {
+	file, err := NewI18nFile(readTestdata(t, "fr.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goldenJSON(t, "fr.bin.json", file)
+
+	if file.Entries[10] != file.Entries[14] {
+		t.Errorf("entries 10 and 14 differ: %q and %q", file.Entries[10], file.Entries[14])
+	}
+}
+
+func TestLanguageExtractStrings(t *testing.T) {
+	file, err := NewI18nFile(readTestdata(t, "fr.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LanguageExtractStrings(readTestdata(t, "fr.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{file.Entries[10], "", file.Entries[12], "Valider"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LanguageExtractStrings() = %q, want %q", got, want)
+	}
+}
+
+func TestI18nFileMalformed(t *testing.T) {
+	entry := func(count int32, id int32, offset int32, rest ...byte) []byte {
+		data := binary.LittleEndian.AppendUint32(nil, uint32(count))
+		data = binary.LittleEndian.AppendUint32(data, uint32(id))
+		data = binary.LittleEndian.AppendUint32(data, uint32(offset))
+		return append(data, rest...)
+	}
+
+	tests := map[string][]byte{
+		"negative count":    entry(-1, 1, 12),
+		"too many entries":  entry(2, 1, 12),
+		"offset outside":    entry(1, 1, 64),
+		"invalid UTF-8":     entry(1, 1, 12, 2, 0xc3, 0x28),
+		"truncated string":  entry(1, 1, 12, 5, 'a'),
+		"truncated length":  entry(1, 1, 12, 0x80),
+		"truncated entries": entry(1, 1, 12)[:8],
+	}
+	for name, data := range tests {
+		if _, err := NewI18nFile(data); err == nil {
+			t.Errorf("%s: decoding did not fail", name)
+		}
+	}
+}
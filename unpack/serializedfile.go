@@ -0,0 +1,215 @@
+package unpack
+
+import (
+	"fmt"
+)
+
+const (
+	UnityClassTexture2D     = 28
+	UnityClassMonoBehaviour = 114
+	UnityClassSprite        = 213
+)
+
+type SerializedType struct {
+	ClassID     int32
+	ScriptIndex int16
+	Tree        *TypeTreeNode
+
+	// only set for the reference types of [SerializeReference] fields
+	ClassName string
+	Namespace string
+	Assembly  string
+}
+
+type SerializedObject struct {
+	PathID    int64
+	ClassID   int32
+	ByteStart int64
+	ByteSize  uint32
+	Type      *SerializedType
+}
+
+// SerializedFile is a unity serialized file (the CAB-... entry of a bundle)
+// that holds the objects and the type trees to decode them.
+type SerializedFile struct {
+	Version        uint32
+	UnityVersion   string
+	TargetPlatform int32
+	Types          []*SerializedType
+	RefTypes       []*SerializedType
+	Objects        []*SerializedObject
+	Externals      []string
+
+	data      []byte
+	bigEndian bool
+	typeTree  bool
+}
+
+func NewSerializedFile(data []byte) (file *SerializedFile, err error) {
+	defer recoverUnityError(&err)
+
+	r := newUnityReader(data, true)
+	file = &SerializedFile{data: data}
+
+	r.ReadUint32() // metadata size
+	fileSize := int64(r.ReadUint32())
+	file.Version = r.ReadUint32()
+	dataOffset := int64(r.ReadUint32())
+
+	if file.Version < 17 {
+		return nil, fmt.Errorf("unsupported serialized file version %d", file.Version)
+	}
+
+	file.bigEndian = r.ReadUint8() != 0
+	r.ReadBytes(3) // reserved
+
+	if file.Version >= 22 {
+		r.ReadUint32() // metadata size
+		fileSize = r.ReadInt64()
+		dataOffset = r.ReadInt64()
+		r.ReadInt64() // unknown
+	}
+
+	if fileSize > int64(len(data)) {
+		return nil, fmt.Errorf("serialized file is truncated: %d of %d bytes", len(data), fileSize)
+	}
+
+	r.bigEndian = file.bigEndian
+	file.UnityVersion = r.ReadCString()
+	file.TargetPlatform = r.ReadInt32()
+	file.typeTree = r.ReadBool()
+
+	file.Types = make([]*SerializedType, r.ReadInt32())
+	for i := range file.Types {
+		file.Types[i] = file.readType(r, false)
+	}
+
+	file.Objects = make([]*SerializedObject, r.ReadInt32())
+	for i := range file.Objects {
+		object := &SerializedObject{}
+		r.align(4)
+		object.PathID = r.ReadInt64()
+		if file.Version >= 22 {
+			object.ByteStart = r.ReadInt64()
+		} else {
+			object.ByteStart = int64(r.ReadUint32())
+		}
+		object.ByteStart += dataOffset
+		object.ByteSize = r.ReadUint32()
+
+		typeIndex := int(r.ReadInt32())
+		if typeIndex < 0 || typeIndex >= len(file.Types) {
+			return nil, fmt.Errorf("object %d references unknown type %d", object.PathID, typeIndex)
+		}
+		object.Type = file.Types[typeIndex]
+		object.ClassID = object.Type.ClassID
+		file.Objects[i] = object
+	}
+
+	scriptCount := int(r.ReadInt32())
+	for i := 0; i < scriptCount; i++ {
+		r.ReadInt32() // local serialized file index
+		r.align(4)
+		r.ReadInt64() // local identifier in file
+	}
+
+	externalCount := int(r.ReadInt32())
+	for i := 0; i < externalCount; i++ {
+		r.ReadCString() // empty
+		r.ReadBytes(16) // guid
+		r.ReadInt32()   // type
+		file.Externals = append(file.Externals, r.ReadCString())
+	}
+
+	if file.Version >= 20 {
+		file.RefTypes = make([]*SerializedType, r.ReadInt32())
+		for i := range file.RefTypes {
+			file.RefTypes[i] = file.readType(r, true)
+		}
+	}
+
+	return file, nil
+}
+
+func (file *SerializedFile) readType(r *unityReader, isRefType bool) *SerializedType {
+	serializedType := &SerializedType{ScriptIndex: -1}
+	serializedType.ClassID = r.ReadInt32()
+	r.ReadBool() // stripped
+	serializedType.ScriptIndex = r.ReadInt16()
+
+	if (isRefType && serializedType.ScriptIndex >= 0) || serializedType.ClassID == UnityClassMonoBehaviour {
+		r.ReadBytes(16) // script id
+	}
+	r.ReadBytes(16) // old type hash
+
+	if !file.typeTree {
+		return serializedType
+	}
+
+	serializedType.Tree = readTypeTreeBlob(r, file.Version)
+
+	if file.Version >= 21 {
+		if isRefType {
+			serializedType.ClassName = r.ReadCString()
+			serializedType.Namespace = r.ReadCString()
+			serializedType.Assembly = r.ReadCString()
+		} else {
+			dependencies := int(r.ReadInt32())
+			for i := 0; i < dependencies; i++ {
+				r.ReadInt32()
+			}
+		}
+	}
+
+	return serializedType
+}
+
+func (file *SerializedFile) findRefType(class string, namespace string, assembly string) *SerializedType {
+	if class == "" {
+		return nil
+	}
+
+	for _, refType := range file.RefTypes {
+		if refType.ClassName == class && refType.Namespace == namespace && refType.Assembly == assembly {
+			return refType
+		}
+	}
+	return nil
+}
+
+// ReadObject decodes an object with its type tree into maps, slices and numbers.
+func (file *SerializedFile) ReadObject(object *SerializedObject) (value map[string]interface{}, err error) {
+	defer recoverUnityError(&err)
+
+	if object.Type.Tree == nil {
+		return nil, fmt.Errorf("object %d has no type tree", object.PathID)
+	}
+
+	end := object.ByteStart + int64(object.ByteSize)
+	if object.ByteStart < 0 || end > int64(len(file.data)) {
+		return nil, fmt.Errorf("object %d out of bounds", object.PathID)
+	}
+
+	// the reader spans the whole file so alignment is relative to its start
+	r := newUnityReader(file.data[:end], file.bigEndian)
+	r.pos = int(object.ByteStart)
+
+	tr := typeTreeReader{r: r, file: file}
+	value, ok := tr.read(object.Type.Tree).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("object %d is not a structure", object.PathID)
+	}
+
+	return value, nil
+}
+
+// ObjectsOfClass returns all objects with the given unity class ID.
+func (file *SerializedFile) ObjectsOfClass(classID int32) []*SerializedObject {
+	var objects []*SerializedObject
+	for _, object := range file.Objects {
+		if object.ClassID == classID {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
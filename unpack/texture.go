@@ -0,0 +1,340 @@
+package unpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+var ErrUnsupportedTextureFormat = errors.New("unsupported texture format")
+
+// Unity TextureFormat values that can be decoded.
+const (
+	TextureFormatAlpha8    = 1
+	TextureFormatARGB4444  = 2
+	TextureFormatRGB24     = 3
+	TextureFormatRGBA32    = 4
+	TextureFormatARGB32    = 5
+	TextureFormatRGB565    = 7
+	TextureFormatDXT1      = 10
+	TextureFormatDXT5      = 12
+	TextureFormatRGBA4444  = 13
+	TextureFormatBGRA32    = 14
+	TextureFormatBC7       = 25
+	TextureFormatBC4       = 26
+	TextureFormatETCRGB4   = 34
+	TextureFormatETC2RGB   = 45
+	TextureFormatETC2RGBA1 = 46
+	TextureFormatETC2RGBA8 = 47
+	TextureFormatASTC4x4   = 48
+	TextureFormatASTC5x5   = 49
+	TextureFormatASTC6x6   = 50
+	TextureFormatASTC8x8   = 51
+	TextureFormatASTC10x10 = 52
+	TextureFormatASTC12x12 = 53
+	TextureFormatRG16      = 62
+	TextureFormatR8        = 63
+)
+
+// ASTC_RGBA_4x4 to ASTC_RGBA_12x12 of older Unity versions hold the same
+// blocks as TextureFormatASTC4x4 to TextureFormatASTC12x12.
+const (
+	TextureFormatASTCRGBA4x4   = 54
+	TextureFormatASTCRGBA12x12 = 59
+)
+
+// astcBlockSizes are the block dimensions of the ASTC formats, starting with
+// TextureFormatASTC4x4.
+var astcBlockSizes = [6]int{4, 5, 6, 8, 10, 12}
+
+// astcBlockSize returns the block width and height of an ASTC format.
+func astcBlockSize(format int) (int, int, bool) {
+	switch {
+	case format >= TextureFormatASTC4x4 && format <= TextureFormatASTC12x12:
+		size := astcBlockSizes[format-TextureFormatASTC4x4]
+		return size, size, true
+	case format >= TextureFormatASTCRGBA4x4 && format <= TextureFormatASTCRGBA12x12:
+		size := astcBlockSizes[format-TextureFormatASTCRGBA4x4]
+		return size, size, true
+	}
+	return 0, 0, false
+}
+
+// textureLevelSize returns the number of bytes of the first mip level.
+func textureLevelSize(format int, width int, height int) (int, error) {
+	if blockWidth, blockHeight, ok := astcBlockSize(format); ok {
+		return ((width + blockWidth - 1) / blockWidth) * ((height + blockHeight - 1) / blockHeight) * 16, nil
+	}
+
+	blocks := ((width + 3) / 4) * ((height + 3) / 4)
+	switch format {
+	case TextureFormatAlpha8, TextureFormatR8:
+		return width * height, nil
+	case TextureFormatARGB4444, TextureFormatRGBA4444, TextureFormatRGB565, TextureFormatRG16:
+		return width * height * 2, nil
+	case TextureFormatRGB24:
+		return width * height * 3, nil
+	case TextureFormatRGBA32, TextureFormatARGB32, TextureFormatBGRA32:
+		return width * height * 4, nil
+	case TextureFormatDXT1, TextureFormatBC4, TextureFormatETCRGB4, TextureFormatETC2RGB, TextureFormatETC2RGBA1:
+		return blocks * 8, nil
+	case TextureFormatDXT5, TextureFormatBC7, TextureFormatETC2RGBA8:
+		return blocks * 16, nil
+	default:
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedTextureFormat, format)
+	}
+}
+
+// DecodeTexture decodes the first mip level of raw texture data. Unity stores
+// textures bottom-up, the returned image is already flipped to top-down.
+func DecodeTexture(format int, width int, height int, data []byte) (*image.NRGBA, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid texture size %dx%d", width, height)
+	}
+
+	size, err := textureLevelSize(format, width, height)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("texture data too small: %d < %d", len(data), size)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	if blockWidth, blockHeight, ok := astcBlockSize(format); ok {
+		decodeBlockGrid(width, height, blockWidth, blockHeight, 16, data, img, func(block []byte, out [][4]uint8) {
+			decodeASTCBlock(block, blockWidth, blockHeight, out)
+		})
+		flipVertical(img)
+		return img, nil
+	}
+
+	switch format {
+	case TextureFormatAlpha8, TextureFormatR8, TextureFormatRG16, TextureFormatRGB24, TextureFormatRGBA32,
+		TextureFormatARGB32, TextureFormatBGRA32, TextureFormatARGB4444, TextureFormatRGBA4444, TextureFormatRGB565:
+		decodeUncompressed(format, width, height, data, img)
+	case TextureFormatDXT1:
+		decodeBlocks(width, height, 8, data, img, func(block []byte, out *[16][4]uint8) {
+			decodeBC1Block(block, out, true)
+		})
+	case TextureFormatDXT5:
+		decodeBlocks(width, height, 16, data, img, func(block []byte, out *[16][4]uint8) {
+			decodeBC1Block(block[8:], out, false)
+			decodeBC4Block(block, out, 3)
+		})
+	case TextureFormatBC7:
+		decodeBlocks(width, height, 16, data, img, decodeBC7Block)
+	case TextureFormatBC4:
+		decodeBlocks(width, height, 8, data, img, func(block []byte, out *[16][4]uint8) {
+			decodeBC4Block(block, out, 0)
+			for i := range out {
+				out[i][1], out[i][2], out[i][3] = out[i][0], out[i][0], 255
+			}
+		})
+	case TextureFormatETCRGB4, TextureFormatETC2RGB:
+		decodeBlocks(width, height, 8, data, img, func(block []byte, out *[16][4]uint8) {
+			decodeETC2Block(binary.BigEndian.Uint64(block), out, format == TextureFormatETC2RGB, false)
+		})
+	case TextureFormatETC2RGBA1:
+		decodeBlocks(width, height, 8, data, img, func(block []byte, out *[16][4]uint8) {
+			decodeETC2Block(binary.BigEndian.Uint64(block), out, true, true)
+		})
+	case TextureFormatETC2RGBA8:
+		decodeBlocks(width, height, 16, data, img, func(block []byte, out *[16][4]uint8) {
+			decodeETC2Block(binary.BigEndian.Uint64(block[8:]), out, true, false)
+			decodeEACAlphaBlock(binary.BigEndian.Uint64(block), out)
+		})
+	}
+
+	flipVertical(img)
+	return img, nil
+}
+
+func decodeUncompressed(format int, width int, height int, data []byte, img *image.NRGBA) {
+	for i := 0; i < width*height; i++ {
+		var r, g, b, a uint8
+		switch format {
+		case TextureFormatAlpha8:
+			r, g, b, a = 255, 255, 255, data[i]
+		case TextureFormatR8:
+			r, g, b, a = data[i], 0, 0, 255
+		case TextureFormatRG16:
+			r, g, b, a = data[i*2], data[i*2+1], 0, 255
+		case TextureFormatRGB24:
+			r, g, b, a = data[i*3], data[i*3+1], data[i*3+2], 255
+		case TextureFormatRGBA32:
+			r, g, b, a = data[i*4], data[i*4+1], data[i*4+2], data[i*4+3]
+		case TextureFormatARGB32:
+			a, r, g, b = data[i*4], data[i*4+1], data[i*4+2], data[i*4+3]
+		case TextureFormatBGRA32:
+			b, g, r, a = data[i*4], data[i*4+1], data[i*4+2], data[i*4+3]
+		case TextureFormatARGB4444:
+			v := binary.LittleEndian.Uint16(data[i*2:])
+			a, r, g, b = uint8(v>>12)*17, uint8(v>>8&0xf)*17, uint8(v>>4&0xf)*17, uint8(v&0xf)*17
+		case TextureFormatRGBA4444:
+			v := binary.LittleEndian.Uint16(data[i*2:])
+			r, g, b, a = uint8(v>>12)*17, uint8(v>>8&0xf)*17, uint8(v>>4&0xf)*17, uint8(v&0xf)*17
+		case TextureFormatRGB565:
+			v := binary.LittleEndian.Uint16(data[i*2:])
+			r, g, b, a = extend5(uint8(v>>11)), extend6(uint8(v>>5&0x3f)), extend5(uint8(v&0x1f)), 255
+		}
+		copy(img.Pix[i*4:], []uint8{r, g, b, a})
+	}
+}
+
+// decodeBlocks runs decode on every 4x4 block and copies the pixels into img.
+func decodeBlocks(width int, height int, blockSize int, data []byte, img *image.NRGBA, decode func(block []byte, out *[16][4]uint8)) {
+	decodeBlockGrid(width, height, 4, 4, blockSize, data, img, func(block []byte, out [][4]uint8) {
+		decode(block, (*[16][4]uint8)(out))
+	})
+}
+
+// decodeBlockGrid runs decode on every block of blockWidth x blockHeight
+// pixels, stored row by row in out, and copies the pixels into img.
+func decodeBlockGrid(width int, height int, blockWidth int, blockHeight int, blockSize int, data []byte, img *image.NRGBA, decode func(block []byte, out [][4]uint8)) {
+	blocksX := (width + blockWidth - 1) / blockWidth
+	blocksY := (height + blockHeight - 1) / blockHeight
+	out := make([][4]uint8, blockWidth*blockHeight)
+
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			offset := (by*blocksX + bx) * blockSize
+			decode(data[offset:offset+blockSize], out)
+
+			for py := 0; py < blockHeight; py++ {
+				y := by*blockHeight + py
+				if y >= height {
+					break
+				}
+				for px := 0; px < blockWidth; px++ {
+					x := bx*blockWidth + px
+					if x >= width {
+						break
+					}
+					copy(img.Pix[img.PixOffset(x, y):], out[py*blockWidth+px][:])
+				}
+			}
+		}
+	}
+}
+
+func flipVertical(img *image.NRGBA) {
+	height := img.Rect.Dy()
+	for y := 0; y < height/2; y++ {
+		top := img.Pix[y*img.Stride : (y+1)*img.Stride]
+		bottom := img.Pix[(height-1-y)*img.Stride : (height-y)*img.Stride]
+		for i := range top {
+			top[i], bottom[i] = bottom[i], top[i]
+		}
+	}
+}
+
+// blockBits reads the bits of a 128 bit block, least significant bit first.
+type blockBits struct {
+	lo, hi uint64
+	pos    int
+}
+
+func newBlockBits(block []byte) *blockBits {
+	return &blockBits{lo: binary.LittleEndian.Uint64(block), hi: binary.LittleEndian.Uint64(block[8:])}
+}
+
+// at returns count bits starting at bit pos, without moving the read position.
+func (b *blockBits) at(pos int, count int) int {
+	value := 0
+	for i := 0; i < count; i++ {
+		bit := pos + i
+		var set uint64
+		if bit < 64 {
+			set = b.lo >> uint(bit) & 1
+		} else if bit < 128 {
+			set = b.hi >> uint(bit-64) & 1
+		}
+		value |= int(set) << uint(i)
+	}
+	return value
+}
+
+// read returns the next count bits.
+func (b *blockBits) read(count int) int {
+	value := b.at(b.pos, count)
+	b.pos += count
+	return value
+}
+
+func extend5(v uint8) uint8 {
+	return v<<3 | v>>2
+}
+
+func extend6(v uint8) uint8 {
+	return v<<2 | v>>4
+}
+
+func clampColor(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// decodeBC1Block decodes a DXT1 color block. The 3 color mode with a
+// transparent index only exists for standalone DXT1, not inside DXT5.
+func decodeBC1Block(block []byte, out *[16][4]uint8, allowTransparent bool) {
+	c0 := binary.LittleEndian.Uint16(block)
+	c1 := binary.LittleEndian.Uint16(block[2:])
+	indices := binary.LittleEndian.Uint32(block[4:])
+
+	var colors [4][4]int
+	colors[0] = [4]int{int(extend5(uint8(c0 >> 11))), int(extend6(uint8(c0 >> 5 & 0x3f))), int(extend5(uint8(c0 & 0x1f))), 255}
+	colors[1] = [4]int{int(extend5(uint8(c1 >> 11))), int(extend6(uint8(c1 >> 5 & 0x3f))), int(extend5(uint8(c1 & 0x1f))), 255}
+
+	if c0 > c1 || !allowTransparent {
+		for i := 0; i < 3; i++ {
+			colors[2][i] = (2*colors[0][i] + colors[1][i]) / 3
+			colors[3][i] = (colors[0][i] + 2*colors[1][i]) / 3
+		}
+		colors[2][3], colors[3][3] = 255, 255
+	} else {
+		for i := 0; i < 3; i++ {
+			colors[2][i] = (colors[0][i] + colors[1][i]) / 2
+		}
+		colors[2][3] = 255
+		colors[3] = [4]int{0, 0, 0, 0}
+	}
+
+	for i := 0; i < 16; i++ {
+		color := colors[indices>>(2*i)&3]
+		out[i] = [4]uint8{uint8(color[0]), uint8(color[1]), uint8(color[2]), uint8(color[3])}
+	}
+}
+
+// decodeBC4Block decodes a DXT5 alpha / BC4 block into the given channel.
+func decodeBC4Block(block []byte, out *[16][4]uint8, channel int) {
+	a0, a1 := int(block[0]), int(block[1])
+	var values [8]int
+	values[0], values[1] = a0, a1
+	if a0 > a1 {
+		for i := 1; i < 7; i++ {
+			values[i+1] = ((7-i)*a0 + i*a1) / 7
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			values[i+1] = ((5-i)*a0 + i*a1) / 5
+		}
+		values[6], values[7] = 0, 255
+	}
+
+	var bits uint64
+	for i := 0; i < 6; i++ {
+		bits |= uint64(block[2+i]) << (8 * i)
+	}
+
+	for i := 0; i < 16; i++ {
+		out[i][channel] = uint8(values[bits>>(3*i)&7])
+	}
+}
@@ -0,0 +1,280 @@
+package unpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// blockOf builds a 128 bit block from (value, bit count) fields, least
+// significant bit first.
+func blockOf(fields ...[2]int) []byte {
+	block := make([]byte, 16)
+	pos := 0
+	for _, field := range fields {
+		for i := 0; i < field[1]; i++ {
+			if field[0]>>i&1 != 0 {
+				block[pos/8] |= 1 << (pos % 8)
+			}
+			pos++
+		}
+	}
+	return block
+}
+
+func TestBC7PartitionAnchors(t *testing.T) {
+	for partition := 0; partition < 64; partition++ {
+		if bc7Partitions2[partition][0] != 0 || bc7Partitions3[partition][0] != 0 {
+			t.Errorf("partition %d does not start with subset 0", partition)
+		}
+		if anchor := bc7Anchors2[partition]; bc7Partitions2[partition][anchor] != 1 {
+			t.Errorf("2 subsets, partition %d: anchor %d is not in subset 1", partition, anchor)
+		}
+		if anchor := bc7Anchors3Second[partition]; bc7Partitions3[partition][anchor] != 1 {
+			t.Errorf("3 subsets, partition %d: anchor %d is not in subset 1", partition, anchor)
+		}
+		if anchor := bc7Anchors3Third[partition]; bc7Partitions3[partition][anchor] != 2 {
+			t.Errorf("3 subsets, partition %d: anchor %d is not in subset 2", partition, anchor)
+		}
+	}
+}
+
+func TestDecodeBC7Mode6(t *testing.T) {
+	// red from 0 to 255, green from 254 to 1, blue from 0 to 1 and alpha
+	// from 254 to 255, the p-bits are 0 and 1
+	fields := [][2]int{{1 << 6, 7}, {0, 7}, {127, 7}, {127, 7}, {0, 7}, {0, 7}, {0, 7}, {127, 7}, {127, 7}, {0, 1}, {1, 1}}
+	for i := 0; i < 16; i++ {
+		count := 4
+		if i == 0 {
+			count = 3
+		}
+		fields = append(fields, [2]int{i % 8, count})
+	}
+	fields[len(fields)-1][0] = 15
+
+	var out [16][4]uint8
+	decodeBC7Block(blockOf(fields...), &out)
+
+	want := map[int][4]uint8{
+		0:  {0, 254, 0, 254},
+		5:  {84, 171, 0, 254},
+		7:  {120, 135, 0, 254},
+		15: {255, 1, 1, 255},
+	}
+	for i, color := range want {
+		if out[i] != color {
+			t.Errorf("pixel %d = %v, want %v", i, out[i], color)
+		}
+	}
+}
+
+func TestDecodeBC7ReservedMode(t *testing.T) {
+	out := [16][4]uint8{{1, 2, 3, 4}}
+	decodeBC7Block(make([]byte, 16), &out)
+	if out != [16][4]uint8{} {
+		t.Errorf("reserved mode decoded to %v, want transparent black", out[0])
+	}
+}
+
+// Every combination of trits and quints has an encoding.
+func TestASTCTritsQuints(t *testing.T) {
+	trits := make(map[[5]int]bool)
+	for value := 0; value < 256; value++ {
+		trits[astcTrits(value)] = true
+	}
+	if len(trits) != 243 {
+		t.Errorf("trit blocks decode to %d combinations, want 243", len(trits))
+	}
+
+	quints := make(map[[3]int]bool)
+	for value := 0; value < 128; value++ {
+		quints[astcQuints(value)] = true
+	}
+	if len(quints) != 125 {
+		t.Errorf("quint blocks decode to %d combinations, want 125", len(quints))
+	}
+}
+
+// The unquantized values of a range are spread evenly over the full range.
+func TestASTCUnquantize(t *testing.T) {
+	check := func(kind string, levels int, max int, unquantize func(int, int) int) {
+		values := make([]int, levels)
+		for i := range values {
+			values[i] = unquantize(i, levels)
+		}
+		sort.Ints(values)
+		for i, value := range values {
+			if even := (i*max + (levels-1)/2) / (levels - 1); value < even-2 || value > even+2 {
+				t.Errorf("%s range %d: value %d is %d, want about %d", kind, levels, i, value, even)
+				return
+			}
+		}
+	}
+
+	for _, levels := range astcColorLevels {
+		check("color", levels, 255, astcUnquantizeColor)
+	}
+	for _, levels := range astcWeightLevels {
+		check("weight", levels, 64, astcUnquantizeWeight)
+	}
+}
+
+func TestDecodeASTCBlock(t *testing.T) {
+	// 4x4 weight grid with 2 bit weights, one partition of luminance from 0
+	// to 255, the weights count 0 to 3 along each row
+	block := blockOf([2]int{0x42, 11}, [2]int{0, 2}, [2]int{0, 4}, [2]int{0, 8}, [2]int{255, 8})
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 2; j++ {
+			if (i%4)>>j&1 != 0 {
+				pos := 127 - (2*i + j)
+				block[pos/8] |= 1 << (pos % 8)
+			}
+		}
+	}
+
+	out := make([][4]uint8, 16)
+	decodeASTCBlock(block, 4, 4, out)
+	for i, luminance := range []uint8{0, 84, 171, 255, 0, 84, 171, 255, 0, 84, 171, 255, 0, 84, 171, 255} {
+		if want := [4]uint8{luminance, luminance, luminance, 255}; out[i] != want {
+			t.Errorf("texel %d = %v, want %v", i, out[i], want)
+		}
+	}
+}
+
+func TestDecodeASTCVoidExtent(t *testing.T) {
+	block := make([]byte, 16)
+	binary.LittleEndian.PutUint64(block, 0xfffffffffffffdfc)
+	binary.LittleEndian.PutUint64(block[8:], 0xffff_00ff_ab00_1234)
+
+	out := make([][4]uint8, 36)
+	decodeASTCBlock(block, 6, 6, out)
+	for i := range out {
+		if want := [4]uint8{0x12, 0xab, 0, 255}; out[i] != want {
+			t.Fatalf("texel %d = %v, want %v", i, out[i], want)
+		}
+	}
+}
+
+func TestDecodeASTCReservedBlock(t *testing.T) {
+	out := make([][4]uint8, 16)
+	decodeASTCBlock(make([]byte, 16), 4, 4, out)
+	if out[0] != astcErrorColor {
+		t.Errorf("reserved block decoded to %v, want the error color", out[0])
+	}
+}
+
+func TestDecodeTextureASTCSize(t *testing.T) {
+	// a 7x5 texture needs 2x1 blocks of 6x6
+	block := make([]byte, 16)
+	binary.LittleEndian.PutUint64(block, 0xfffffffffffffdfc)
+	binary.LittleEndian.PutUint64(block[8:], 0xffff_0000_0000_ff00)
+	data := append(append([]byte(nil), block...), block...)
+
+	img, err := DecodeTexture(TextureFormatASTC6x6, 7, 5, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.NRGBAAt(6, 4); got.R != 255 || got.A != 255 {
+		t.Errorf("pixel (6, 4) = %v, want red", got)
+	}
+
+	if _, err := DecodeTexture(TextureFormatASTC6x6, 7, 5, block); err == nil {
+		t.Error("decoding a texture with missing blocks did not fail")
+	}
+}
+
+func TestDecodeTextureUnsupported(t *testing.T) {
+	const dxt1Crunched = 28
+	_, err := DecodeTexture(dxt1Crunched, 4, 4, make([]byte, 64))
+	if !errors.Is(err, ErrUnsupportedTextureFormat) {
+		t.Errorf("crunched texture returned %v, want ErrUnsupportedTextureFormat", err)
+	}
+}
+
+// textureFixtures are the 8x8 textures of testdata/textures, each decoded to
+// a png of the same name.
+var textureFixtures = map[string]int{
+	"alpha8":     TextureFormatAlpha8,
+	"r8":         TextureFormatR8,
+	"argb4444":   TextureFormatARGB4444,
+	"rgba4444":   TextureFormatRGBA4444,
+	"rgb565":     TextureFormatRGB565,
+	"rg16":       TextureFormatRG16,
+	"rgb24":      TextureFormatRGB24,
+	"rgba32":     TextureFormatRGBA32,
+	"argb32":     TextureFormatARGB32,
+	"bgra32":     TextureFormatBGRA32,
+	"dxt1":       TextureFormatDXT1,
+	"dxt5":       TextureFormatDXT5,
+	"bc4":        TextureFormatBC4,
+	"bc7":        TextureFormatBC7,
+	"etc_rgb4":   TextureFormatETCRGB4,
+	"etc2_rgb":   TextureFormatETC2RGB,
+	"etc2_rgba1": TextureFormatETC2RGBA1,
+	"etc2_rgba8": TextureFormatETC2RGBA8,
+	"astc_4x4":   TextureFormatASTC4x4,
+	"astc_6x6":   TextureFormatASTC6x6,
+}
+
+// The golden pngs are compared by their pixels, so a different png encoder
+// does not fail the test.
+func TestDecodeTextureGolden(t *testing.T) {
+	for name, format := range textureFixtures {
+		img, err := DecodeTexture(format, 8, 8, readTestdata(t, filepath.Join("textures", name+".bin")))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		goldenPath := filepath.Join("textures", name+".png")
+		if *update {
+			var encoded bytes.Buffer
+			if err := png.Encode(&encoded, img); err != nil {
+				t.Fatal(err)
+			}
+			golden(t, goldenPath, encoded.Bytes())
+			continue
+		}
+
+		want, err := png.Decode(bytes.NewReader(readTestdata(t, goldenPath)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				if got, want := img.NRGBAAt(x, y), color.NRGBAModel.Convert(want.At(x, y)); got != want {
+					t.Errorf("%s: pixel (%d, %d) = %v, want %v", name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// The fixtures start with blocks of known colors, the textures are flipped so
+// the first block is at the bottom left.
+func TestDecodeTextureFixtureBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y int
+		want color.NRGBA
+	}{
+		{"dxt1", 0, 7, color.NRGBA{170, 0, 85, 255}},
+		{"astc_4x4", 1, 7, color.NRGBA{84, 84, 84, 255}},
+		{"astc_4x4", 7, 4, color.NRGBA{0x12, 0xab, 0, 255}},
+		{"astc_6x6", 7, 0, color.NRGBA{0x12, 0xab, 0, 255}},
+	}
+	for _, test := range tests {
+		img, err := DecodeTexture(textureFixtures[test.name], 8, 8, readTestdata(t, filepath.Join("textures", test.name+".bin")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.NRGBAAt(test.x, test.y); got != test.want {
+			t.Errorf("%s: pixel (%d, %d) = %v, want %v", test.name, test.x, test.y, got, test.want)
+		}
+	}
+}
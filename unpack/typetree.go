@@ -0,0 +1,265 @@
+package unpack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unityCommonStrings is the shared string buffer of the unity runtime that type
+// tree nodes reference with the high bit set. The offset of each string is the
+// sum of the lengths (plus null terminator) of the strings before it.
+var unityCommonStrings = buildUnityCommonStrings([]string{
+	"AABB", "AnimationClip", "AnimationCurve", "AnimationState", "Array", "Base", "BitField", "bitset", "bool", "char",
+	"ColorRGBA", "Component", "data", "deque", "double", "dynamic_array", "FastPropertyName", "first", "float", "Font",
+	"GameObject", "Generic Mono", "GradientNEW", "GUID", "GUIStyle", "int", "list", "long long", "map", "Matrix4x4f",
+	"MdFour", "MonoBehaviour", "MonoScript", "m_ByteSize", "m_Curve", "m_EditorClassIdentifier", "m_EditorHideFlags", "m_Enabled", "m_ExtensionPtr", "m_GameObject",
+	"m_Index", "m_IsArray", "m_IsStatic", "m_MetaFlag", "m_Name", "m_ObjectHideFlags", "m_PrefabInternal", "m_PrefabParentObject", "m_Script", "m_StaticEditorFlags",
+	"m_Type", "m_Version", "Object", "pair", "PPtr<Component>", "PPtr<GameObject>", "PPtr<Material>", "PPtr<MonoBehaviour>", "PPtr<MonoScript>", "PPtr<Object>",
+	"PPtr<Prefab>", "PPtr<Sprite>", "PPtr<TextAsset>", "PPtr<Texture>", "PPtr<Texture2D>", "PPtr<Transform>", "Prefab", "Quaternionf", "Rectf", "RectInt",
+	"RectOffset", "second", "set", "short", "size", "SInt16", "SInt32", "SInt64", "SInt8", "staticvector",
+	"string", "TextAsset", "TextMesh", "Texture", "Texture2D", "Transform", "TypelessData", "UInt16", "UInt32", "UInt64",
+	"UInt8", "unsigned int", "unsigned long long", "unsigned short", "vector", "Vector2f", "Vector3f", "Vector4f", "m_ScriptingClassIdentifier", "Gradient",
+	"Type*", "int2_storage", "int3_storage", "BoundsInt", "m_CorrespondingSourceObject", "m_PrefabInstance", "m_PrefabAsset", "FileSize", "Hash128",
+})
+
+func buildUnityCommonStrings(strs []string) map[uint32]string {
+	lookup := make(map[uint32]string, len(strs))
+	offset := uint32(0)
+	for _, str := range strs {
+		lookup[offset] = str
+		offset += uint32(len(str)) + 1
+	}
+	return lookup
+}
+
+const unityAlignFlag = 0x4000
+
+// TypeTreeNode describes one field of a serialized unity type.
+type TypeTreeNode struct {
+	Type     string
+	Name     string
+	Level    uint8
+	ByteSize int32
+	MetaFlag int32
+	IsArray  bool
+	Children []*TypeTreeNode
+}
+
+func (node *TypeTreeNode) aligned() bool {
+	return node.MetaFlag&unityAlignFlag != 0
+}
+
+func readTypeTreeBlob(r *unityReader, version uint32) *TypeTreeNode {
+	nodeCount := int(r.ReadInt32())
+	stringBufferSize := int(r.ReadInt32())
+
+	type rawNode struct {
+		level      uint8
+		typeFlags  uint8
+		typeOffset uint32
+		nameOffset uint32
+		byteSize   int32
+		metaFlag   int32
+	}
+
+	raw := make([]rawNode, nodeCount)
+	for i := range raw {
+		r.ReadUint16() // version
+		raw[i].level = r.ReadUint8()
+		raw[i].typeFlags = r.ReadUint8()
+		raw[i].typeOffset = r.ReadUint32()
+		raw[i].nameOffset = r.ReadUint32()
+		raw[i].byteSize = r.ReadInt32()
+		r.ReadInt32() // index
+		raw[i].metaFlag = r.ReadInt32()
+		if version >= 19 {
+			r.ReadUint64() // ref type hash
+		}
+	}
+
+	stringBuffer := r.ReadBytes(stringBufferSize)
+	lookup := func(offset uint32) string {
+		if offset&0x80000000 != 0 {
+			if str, ok := unityCommonStrings[offset&0x7fffffff]; ok {
+				return str
+			}
+			return fmt.Sprintf("unknown_%d", offset&0x7fffffff)
+		}
+		if int(offset) >= len(stringBuffer) {
+			return ""
+		}
+		end := strings.IndexByte(string(stringBuffer[offset:]), 0)
+		if end < 0 {
+			return string(stringBuffer[offset:])
+		}
+		return string(stringBuffer[offset : int(offset)+end])
+	}
+
+	var root *TypeTreeNode
+	var parents []*TypeTreeNode
+	for _, node := range raw {
+		treeNode := &TypeTreeNode{
+			Type:     lookup(node.typeOffset),
+			Name:     lookup(node.nameOffset),
+			Level:    node.level,
+			ByteSize: node.byteSize,
+			MetaFlag: node.metaFlag,
+			IsArray:  node.typeFlags&1 != 0,
+		}
+
+		if root == nil {
+			root = treeNode
+			parents = []*TypeTreeNode{treeNode}
+			continue
+		}
+
+		for len(parents) > int(node.level) {
+			parents = parents[:len(parents)-1]
+		}
+		if len(parents) == 0 {
+			panic(fmt.Errorf("malformed type tree"))
+		}
+		parent := parents[len(parents)-1]
+		parent.Children = append(parent.Children, treeNode)
+		parents = append(parents, treeNode)
+	}
+
+	return root
+}
+
+// typeTreeReader decodes an object with its type tree into plain go values
+// that marshal to the same JSON as the unity-bundle-unwrap tool.
+type typeTreeReader struct {
+	r    *unityReader
+	file *SerializedFile
+}
+
+func (tr *typeTreeReader) read(node *TypeTreeNode) interface{} {
+	var value interface{}
+	align := node.aligned()
+
+	switch node.Type {
+	case "SInt8":
+		value = tr.r.ReadInt8()
+	case "UInt8", "char":
+		value = tr.r.ReadUint8()
+	case "short", "SInt16":
+		value = tr.r.ReadInt16()
+	case "UInt16", "unsigned short":
+		value = tr.r.ReadUint16()
+	case "int", "SInt32":
+		value = tr.r.ReadInt32()
+	case "UInt32", "unsigned int", "Type*":
+		value = tr.r.ReadUint32()
+	case "long long", "SInt64":
+		value = tr.r.ReadInt64()
+	case "UInt64", "unsigned long long", "FileSize":
+		value = tr.r.ReadUint64()
+	case "float":
+		value = tr.r.ReadFloat32()
+	case "double":
+		value = tr.r.ReadFloat64()
+	case "bool":
+		// the game data expects booleans as numbers
+		if tr.r.ReadBool() {
+			value = 1
+		} else {
+			value = 0
+		}
+	case "string":
+		value = tr.r.ReadAlignedString()
+		if len(node.Children) > 0 && node.Children[0].aligned() {
+			align = true
+		}
+	case "TypelessData":
+		size := int(tr.r.ReadInt32())
+		value = tr.r.ReadBytes(size)
+	case "ReferencedObject":
+		value = tr.readReferencedObject(node)
+	case "ManagedReferencesRegistry":
+		registry := make(map[string]interface{}, len(node.Children))
+		for _, child := range node.Children {
+			registry[child.Name] = tr.read(child)
+		}
+		// RefIds is written as a plain list, unlike every other vector
+		if refIds, ok := registry["RefIds"].(map[string]interface{}); ok {
+			registry["RefIds"] = refIds["Array"]
+		}
+		value = registry
+	default:
+		if node.IsArray || node.Type == "Array" {
+			value = tr.readArray(node)
+		} else {
+			obj := make(map[string]interface{}, len(node.Children))
+			for _, child := range node.Children {
+				obj[child.Name] = tr.read(child)
+			}
+			value = obj
+		}
+	}
+
+	if align {
+		tr.r.align(4)
+	}
+
+	return value
+}
+
+func (tr *typeTreeReader) readArray(node *TypeTreeNode) []interface{} {
+	if len(node.Children) < 2 {
+		panic(fmt.Errorf("malformed array node %s", node.Name))
+	}
+
+	size := int(tr.r.ReadInt32())
+	if size < 0 || size > len(tr.r.data)-tr.r.pos {
+		panic(errUnexpectedEOF)
+	}
+
+	element := node.Children[1]
+	values := make([]interface{}, size)
+	for i := range values {
+		values[i] = tr.read(element)
+	}
+	return values
+}
+
+// readReferencedObject reads an entry of a [SerializeReference] registry. Its
+// data has no type tree of its own; it is looked up in the reference types
+// of the serialized file by class, namespace and assembly.
+func (tr *typeTreeReader) readReferencedObject(node *TypeTreeNode) interface{} {
+	obj := make(map[string]interface{}, len(node.Children))
+	var class, namespace, assembly string
+
+	for _, child := range node.Children {
+		if child.Type == "ReferencedObjectData" {
+			refType := tr.file.findRefType(class, namespace, assembly)
+			if refType == nil || refType.Tree == nil {
+				obj[child.Name] = nil
+				continue
+			}
+
+			data := make(map[string]interface{}, len(refType.Tree.Children))
+			for _, field := range refType.Tree.Children {
+				data[field.Name] = tr.read(field)
+			}
+			if refType.Tree.aligned() {
+				tr.r.align(4)
+			}
+			obj[child.Name] = data
+			continue
+		}
+
+		value := tr.read(child)
+		obj[child.Name] = value
+
+		if child.Type == "ReferencedManagedType" {
+			if managedType, ok := value.(map[string]interface{}); ok {
+				class, _ = managedType["class"].(string)
+				namespace, _ = managedType["ns"].(string)
+				assembly, _ = managedType["asm"].(string)
+			}
+		}
+	}
+
+	return obj
+}
@@ -0,0 +1,54 @@
+package unpack
+
+import (
+	"fmt"
+)
+
+// SerializedFiles parses every serialized file contained in the bundle.
+func (bundle *UnityBundle) SerializedFiles() ([]*SerializedFile, error) {
+	var files []*SerializedFile
+	for _, entry := range bundle.Files {
+		if !entry.IsSerialized {
+			continue
+		}
+
+		file, err := NewSerializedFile(entry.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// UnityBundleData decodes the game data of a Dofus 3 data bundle. These bundles
+// hold one MonoBehaviour root with all entries in its [SerializeReference]
+// registry. The biggest MonoBehaviour is returned when there are several.
+func UnityBundleData(data []byte) (map[string]interface{}, error) {
+	bundle, err := NewUnityBundle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := bundle.SerializedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var rootFile *SerializedFile
+	var root *SerializedObject
+	for _, file := range files {
+		for _, object := range file.ObjectsOfClass(UnityClassMonoBehaviour) {
+			if root == nil || object.ByteSize > root.ByteSize {
+				rootFile = file
+				root = object
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("bundle contains no MonoBehaviour")
+	}
+
+	return rootFile.ReadObject(root)
+}
@@ -0,0 +1,67 @@
+package unpack
+
+import (
+	"testing"
+)
+
+// data.bundle is a UnityFS bundle with an LZMA compressed blocks info, an LZ4
+// and an uncompressed block, and a serialized file with two MonoBehaviours.
+// The bigger one has strings, numbers, a vector, typeless data and a
+// [SerializeReference] registry with two entries of a reference type and a
+// null reference.
+func TestUnityBundleData(t *testing.T) {
+	data, err := UnityBundleData(readTestdata(t, "data.bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goldenJSON(t, "data.bundle.json", data)
+}
+
+func TestUnityBundle(t *testing.T) {
+	bundle, err := NewUnityBundle(readTestdata(t, "data.bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle.UnityRevision != "2022.3.20f1" || len(bundle.Files) != 2 {
+		t.Fatalf("bundle of %s with %d files, want 2022.3.20f1 with 2", bundle.UnityRevision, len(bundle.Files))
+	}
+	if resources := bundle.File("CAB-fixture.resS"); resources == nil || resources.IsSerialized || string(resources.Data) != "resource bytes\n" {
+		t.Errorf("CAB-fixture.resS = %+v", resources)
+	}
+
+	files, err := bundle.SerializedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := files[0]
+	if file.Version != 22 || len(file.Objects) != 2 || len(file.RefTypes) != 1 {
+		t.Fatalf("serialized file version %d with %d objects and %d reference types, want 22 with 2 and 1", file.Version, len(file.Objects), len(file.RefTypes))
+	}
+	if got := file.Externals; len(got) != 1 || got[0] != "library/unity default resources" {
+		t.Errorf("Externals = %v", got)
+	}
+
+	other, err := file.ReadObject(file.Objects[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other["m_Name"] != "Other" {
+		t.Errorf("object 6 is called %v, want Other", other["m_Name"])
+	}
+}
+
+func TestUnityBundleMalformed(t *testing.T) {
+	data := readTestdata(t, "data.bundle")
+
+	tests := map[string][]byte{
+		"wrong signature": append([]byte("UnityWeb"), data[7:]...),
+		"truncated":       data[:len(data)-100],
+		"header only":     data[:40],
+	}
+	for name, data := range tests {
+		if _, err := UnityBundleData(data); err == nil {
+			t.Errorf("%s: decoding did not fail", name)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package unpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	unityCompressionNone = iota
+	unityCompressionLZMA
+	unityCompressionLZ4
+	unityCompressionLZ4HC
+	unityCompressionLZHAM
+)
+
+const (
+	unityBlocksInfoAtTheEnd          = 0x80
+	unityBlockInfoNeedPaddingAtStart = 0x200
+	unityNodeSerializedFile          = 0x4
+)
+
+// UnityBundleFile is one entry of the directory of a UnityFS bundle, most of
+// the time a serialized file (CAB-...) and its .resS resources.
+type UnityBundleFile struct {
+	Path         string
+	Flags        uint32
+	Data         []byte
+	IsSerialized bool
+}
+
+// UnityBundle is a decompressed UnityFS asset bundle.
+type UnityBundle struct {
+	FormatVersion uint32
+	UnityVersion  string
+	UnityRevision string
+	Files         []UnityBundleFile
+}
+
+type unityStorageBlock struct {
+	uncompressedSize uint32
+	compressedSize   uint32
+	flags            uint16
+}
+
+func NewUnityBundle(data []byte) (bundle *UnityBundle, err error) {
+	defer recoverUnityError(&err)
+
+	r := newUnityReader(data, true)
+	signature := r.ReadCString()
+	if signature != "UnityFS" {
+		return nil, fmt.Errorf("unsupported bundle signature %q", signature)
+	}
+
+	bundle = &UnityBundle{}
+	bundle.FormatVersion = r.ReadUint32()
+	bundle.UnityVersion = r.ReadCString()
+	bundle.UnityRevision = r.ReadCString()
+
+	r.ReadInt64() // total size
+	compressedInfoSize := int(r.ReadUint32())
+	uncompressedInfoSize := int(r.ReadUint32())
+	flags := r.ReadUint32()
+
+	if bundle.FormatVersion >= 7 {
+		r.align(16)
+	}
+
+	var blocksInfo []byte
+	if flags&unityBlocksInfoAtTheEnd != 0 {
+		if compressedInfoSize > len(data) {
+			return nil, errUnexpectedEOF
+		}
+		blocksInfo = data[len(data)-compressedInfoSize:]
+	} else {
+		blocksInfo = r.ReadBytes(compressedInfoSize)
+	}
+
+	blocksInfo, err = decompressUnityBlock(blocksInfo, flags&0x3f, uncompressedInfoSize)
+	if err != nil {
+		return nil, fmt.Errorf("blocks info: %w", err)
+	}
+
+	info := newUnityReader(blocksInfo, true)
+	info.ReadBytes(16) // uncompressed data hash
+	blocks := make([]unityStorageBlock, info.ReadInt32())
+	totalSize := 0
+	for i := range blocks {
+		blocks[i].uncompressedSize = info.ReadUint32()
+		blocks[i].compressedSize = info.ReadUint32()
+		blocks[i].flags = info.ReadUint16()
+		totalSize += int(blocks[i].uncompressedSize)
+	}
+
+	type directoryNode struct {
+		offset int64
+		size   int64
+		flags  uint32
+		path   string
+	}
+	nodes := make([]directoryNode, info.ReadInt32())
+	for i := range nodes {
+		nodes[i].offset = info.ReadInt64()
+		nodes[i].size = info.ReadInt64()
+		nodes[i].flags = info.ReadUint32()
+		nodes[i].path = info.ReadCString()
+	}
+
+	if flags&unityBlockInfoNeedPaddingAtStart != 0 {
+		r.align(16)
+	}
+
+	blockData := make([]byte, 0, totalSize)
+	for i, block := range blocks {
+		raw := r.ReadBytes(int(block.compressedSize))
+		decompressed, err := decompressUnityBlock(raw, uint32(block.flags&0x3f), int(block.uncompressedSize))
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		blockData = append(blockData, decompressed...)
+	}
+
+	for _, node := range nodes {
+		if node.offset < 0 || node.offset+node.size > int64(len(blockData)) {
+			return nil, fmt.Errorf("directory entry %s out of bounds", node.path)
+		}
+		bundle.Files = append(bundle.Files, UnityBundleFile{
+			Path:         node.path,
+			Flags:        node.flags,
+			Data:         blockData[node.offset : node.offset+node.size],
+			IsSerialized: node.flags&unityNodeSerializedFile != 0,
+		})
+	}
+
+	return bundle, nil
+}
+
+// File returns the bundle entry with the given path, for example the .resS
+// file referenced by a texture stream.
+func (bundle *UnityBundle) File(path string) *UnityBundleFile {
+	for i := range bundle.Files {
+		if bundle.Files[i].Path == path {
+			return &bundle.Files[i]
+		}
+	}
+	return nil
+}
+
+func decompressUnityBlock(data []byte, compression uint32, uncompressedSize int) ([]byte, error) {
+	switch compression {
+	case unityCompressionNone:
+		return data, nil
+	case unityCompressionLZ4, unityCompressionLZ4HC:
+		out := make([]byte, uncompressedSize)
+		n, err := lz4.UncompressBlock(data, out)
+		if err != nil {
+			return nil, err
+		}
+		if n != uncompressedSize {
+			return nil, fmt.Errorf("lz4 size mismatch: %d != %d", n, uncompressedSize)
+		}
+		return out, nil
+	case unityCompressionLZMA:
+		// unity stores the 5 byte properties without the uncompressed size
+		if len(data) < 5 {
+			return nil, errUnexpectedEOF
+		}
+		header := make([]byte, 13)
+		copy(header, data[:5])
+		binary.LittleEndian.PutUint64(header[5:], uint64(uncompressedSize))
+		reader, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header), bytes.NewReader(data[5:])))
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, uncompressedSize)
+		_, err = io.ReadFull(reader, out)
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression type %d", compression)
+	}
+}
@@ -0,0 +1,409 @@
+package unpack
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"path"
+)
+
+const (
+	UnityClassAssetBundle = 142
+	UnityClassSpriteAtlas = 687078895
+)
+
+const (
+	spritePackingRotationFlipHorizontal = 1
+	spritePackingRotationFlipVertical   = 2
+	spritePackingRotation180            = 3
+	spritePackingRotation90             = 4
+)
+
+// UnityImage is a sprite or a standalone texture of an image bundle.
+type UnityImage struct {
+	Name string
+	// Container is the asset path inside the bundle, for example
+	// "Assets/BuiltAssets/items/2x/123.png". Empty if the asset has none.
+	Container string
+	Image     *image.NRGBA
+}
+
+// unityImageExtractor keeps the decoded textures of one serialized file so
+// sprites that share an atlas only decode it once.
+type unityImageExtractor struct {
+	bundle    *UnityBundle
+	file      *SerializedFile
+	objects   map[int64]*SerializedObject
+	textures  map[int64]*image.NRGBA
+	container map[int64]string
+	atlases   map[int64]map[string]interface{}
+	failed    map[int64]error
+	skipped   []error
+}
+
+// UnityBundleImages decodes every sprite of an image bundle, cut out of its
+// texture, and every texture that no sprite uses. fn is called once per image.
+// Images with an unsupported texture format are skipped and reported in the
+// returned error, which then matches ErrUnsupportedTextureFormat.
+func UnityBundleImages(data []byte, fn func(img UnityImage) error) error {
+	bundle, err := NewUnityBundle(data)
+	if err != nil {
+		return err
+	}
+
+	files, err := bundle.SerializedFiles()
+	if err != nil {
+		return err
+	}
+
+	var skipped []error
+	for _, file := range files {
+		extractor := &unityImageExtractor{
+			bundle:    bundle,
+			file:      file,
+			objects:   make(map[int64]*SerializedObject, len(file.Objects)),
+			textures:  make(map[int64]*image.NRGBA),
+			container: make(map[int64]string),
+			atlases:   make(map[int64]map[string]interface{}),
+			failed:    make(map[int64]error),
+		}
+		for _, object := range file.Objects {
+			extractor.objects[object.PathID] = object
+		}
+
+		if err := extractor.readContainer(); err != nil {
+			return err
+		}
+
+		if err := extractor.extract(fn); err != nil {
+			return err
+		}
+		skipped = append(skipped, extractor.skipped...)
+	}
+
+	return errors.Join(skipped...)
+}
+
+func (e *unityImageExtractor) readContainer() error {
+	for _, object := range e.file.ObjectsOfClass(UnityClassAssetBundle) {
+		assetBundle, err := e.file.ReadObject(object)
+		if err != nil {
+			return fmt.Errorf("asset bundle: %w", err)
+		}
+
+		for _, entry := range unityArray(assetBundle["m_Container"]) {
+			pair, _ := entry.(map[string]interface{})
+			containerPath, _ := pair["first"].(string)
+			info, _ := pair["second"].(map[string]interface{})
+			fileID, pathID := unityPPtr(info["asset"])
+			if fileID != 0 || containerPath == "" {
+				continue
+			}
+			if _, ok := e.container[pathID]; !ok {
+				e.container[pathID] = containerPath
+			}
+		}
+	}
+	return nil
+}
+
+func (e *unityImageExtractor) extract(fn func(img UnityImage) error) error {
+	usedTextures := make(map[int64]bool)
+
+	for _, object := range e.file.ObjectsOfClass(UnityClassSprite) {
+		sprite, err := e.file.ReadObject(object)
+		if err != nil {
+			return fmt.Errorf("sprite %d: %w", object.PathID, err)
+		}
+
+		name, _ := sprite["m_Name"].(string)
+		renderData, ok := e.spriteRenderData(sprite)
+		if !ok {
+			continue // the texture lives in another bundle
+		}
+
+		fileID, texturePathID := unityPPtr(renderData["texture"])
+		if fileID != 0 {
+			continue
+		}
+		usedTextures[texturePathID] = true
+
+		texture, err := e.texture(texturePathID)
+		if errors.Is(err, ErrUnsupportedTextureFormat) {
+			continue // reported once with its texture below
+		}
+		if err != nil {
+			return fmt.Errorf("sprite %s: %w", name, err)
+		}
+
+		img := cropSprite(texture, renderData)
+
+		containerPath, ok := e.container[object.PathID]
+		if !ok {
+			containerPath = e.container[texturePathID]
+		}
+
+		err = fn(UnityImage{Name: name, Container: containerPath, Image: img})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, object := range e.file.ObjectsOfClass(UnityClassTexture2D) {
+		if err, ok := e.failed[object.PathID]; ok {
+			e.skipped = append(e.skipped, err)
+			continue
+		}
+		if usedTextures[object.PathID] {
+			continue
+		}
+
+		texture, err := e.texture(object.PathID)
+		if errors.Is(err, ErrUnsupportedTextureFormat) {
+			e.skipped = append(e.skipped, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("texture %d: %w", object.PathID, err)
+		}
+
+		name, err := e.objectName(object)
+		if err != nil {
+			return err
+		}
+
+		err = fn(UnityImage{Name: name, Container: e.container[object.PathID], Image: texture})
+		if err != nil {
+			return err
+		}
+		delete(e.textures, object.PathID)
+	}
+
+	return nil
+}
+
+// spriteRenderData returns the render data of a sprite. Sprites packed into a
+// sprite atlas have their texture and rect in the atlas instead of m_RD.
+func (e *unityImageExtractor) spriteRenderData(sprite map[string]interface{}) (map[string]interface{}, bool) {
+	atlasFileID, atlasPathID := unityPPtr(sprite["m_SpriteAtlas"])
+	if atlasPathID != 0 {
+		if atlasFileID != 0 {
+			return nil, false
+		}
+
+		atlas, err := e.atlas(atlasPathID)
+		if err == nil {
+			key := fmt.Sprint(sprite["m_RenderDataKey"])
+			for _, entry := range unityArray(atlas["m_RenderDataMap"]) {
+				pair, _ := entry.(map[string]interface{})
+				if fmt.Sprint(pair["first"]) == key {
+					renderData, ok := pair["second"].(map[string]interface{})
+					return renderData, ok
+				}
+			}
+		}
+	}
+
+	renderData, ok := sprite["m_RD"].(map[string]interface{})
+	return renderData, ok
+}
+
+func (e *unityImageExtractor) atlas(pathID int64) (map[string]interface{}, error) {
+	if atlas, ok := e.atlases[pathID]; ok {
+		return atlas, nil
+	}
+
+	object, ok := e.objects[pathID]
+	if !ok {
+		return nil, fmt.Errorf("unknown sprite atlas %d", pathID)
+	}
+
+	atlas, err := e.file.ReadObject(object)
+	if err != nil {
+		return nil, err
+	}
+	e.atlases[pathID] = atlas
+	return atlas, nil
+}
+
+func (e *unityImageExtractor) objectName(object *SerializedObject) (string, error) {
+	value, err := e.file.ReadObject(object)
+	if err != nil {
+		return "", err
+	}
+	name, _ := value["m_Name"].(string)
+	return name, nil
+}
+
+func (e *unityImageExtractor) texture(pathID int64) (*image.NRGBA, error) {
+	if texture, ok := e.textures[pathID]; ok {
+		return texture, nil
+	}
+	if err, ok := e.failed[pathID]; ok {
+		return nil, err
+	}
+
+	object, ok := e.objects[pathID]
+	if !ok || object.ClassID != UnityClassTexture2D {
+		return nil, fmt.Errorf("unknown texture %d", pathID)
+	}
+
+	value, err := e.file.ReadObject(object)
+	if err != nil {
+		return nil, err
+	}
+
+	width := int(unityInt(value["m_Width"]))
+	height := int(unityInt(value["m_Height"]))
+	format := int(unityInt(value["m_TextureFormat"]))
+
+	data, _ := value["image data"].([]byte)
+	if len(data) == 0 {
+		data, err = e.streamData(value["m_StreamData"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	texture, err := DecodeTexture(format, width, height, data)
+	if err != nil {
+		name, _ := value["m_Name"].(string)
+		err = fmt.Errorf("%s: %w", name, err)
+		e.failed[pathID] = err
+		return nil, err
+	}
+
+	e.textures[pathID] = texture
+	return texture, nil
+}
+
+// streamData reads texture data that is stored in a .resS file of the bundle.
+func (e *unityImageExtractor) streamData(value interface{}) ([]byte, error) {
+	stream, _ := value.(map[string]interface{})
+	streamPath, _ := stream["path"].(string)
+	if streamPath == "" {
+		return nil, fmt.Errorf("texture has no image data")
+	}
+
+	resource := e.bundle.File(path.Base(streamPath))
+	if resource == nil {
+		return nil, fmt.Errorf("resource %s not found in bundle", streamPath)
+	}
+
+	offset := unityInt(stream["offset"])
+	size := unityInt(stream["size"])
+	if offset < 0 || offset+size > int64(len(resource.Data)) {
+		return nil, fmt.Errorf("resource %s out of bounds", streamPath)
+	}
+
+	return resource.Data[offset : offset+size], nil
+}
+
+// cropSprite cuts the sprite rect out of its texture. Rects count from the
+// bottom left corner while the decoded texture is top-down.
+func cropSprite(texture *image.NRGBA, renderData map[string]interface{}) *image.NRGBA {
+	rect, _ := renderData["textureRect"].(map[string]interface{})
+	x, y := unityFloat(rect["x"]), unityFloat(rect["y"])
+	width, height := unityFloat(rect["width"]), unityFloat(rect["height"])
+
+	bounds := texture.Bounds()
+	left := int(math.Floor(x))
+	right := min(int(math.Ceil(x+width)), bounds.Dx())
+	bottom := int(math.Floor(y))
+	top := min(int(math.Ceil(y+height)), bounds.Dy())
+
+	area := image.Rect(left, bounds.Dy()-top, right, bounds.Dy()-bottom).Intersect(bounds)
+	img := image.NewNRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	for row := 0; row < area.Dy(); row++ {
+		start := texture.PixOffset(area.Min.X, area.Min.Y+row)
+		copy(img.Pix[row*img.Stride:(row+1)*img.Stride], texture.Pix[start:start+area.Dx()*4])
+	}
+
+	settings := unityInt(renderData["settingsRaw"])
+	packed := settings&1 != 0
+	if !packed {
+		return img
+	}
+
+	switch (settings >> 2) & 0xf {
+	case spritePackingRotationFlipHorizontal:
+		return transformImage(img, false, func(x, y, w, h int) (int, int) { return w - 1 - x, y })
+	case spritePackingRotationFlipVertical:
+		return transformImage(img, false, func(x, y, w, h int) (int, int) { return x, h - 1 - y })
+	case spritePackingRotation180:
+		return transformImage(img, false, func(x, y, w, h int) (int, int) { return w - 1 - x, h - 1 - y })
+	case spritePackingRotation90:
+		// the packer rotated the sprite clockwise, turn it back
+		return transformImage(img, true, func(x, y, w, h int) (int, int) { return h - 1 - y, x })
+	}
+	return img
+}
+
+// transformImage builds a new image where every destination pixel (x, y) is
+// read from source(x, y, width, height) of the source image.
+func transformImage(src *image.NRGBA, swap bool, source func(x, y, w, h int) (int, int)) *image.NRGBA {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	if swap {
+		width, height = height, width
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := source(x, y, width, height)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):])
+		}
+	}
+	return dst
+}
+
+func unityArray(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		array, _ := v["Array"].([]interface{})
+		return array
+	}
+	return nil
+}
+
+func unityPPtr(value interface{}) (int32, int64) {
+	pptr, _ := value.(map[string]interface{})
+	return int32(unityInt(pptr["m_FileID"])), unityInt(pptr["m_PathID"])
+}
+
+func unityInt(value interface{}) int64 {
+	switch v := value.(type) {
+	case int8:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	case int:
+		return int64(v)
+	}
+	return 0
+}
+
+func unityFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return float64(unityInt(value))
+}
@@ -0,0 +1,126 @@
+package unpack
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+// goldenImage compares img pixel by pixel with a golden PNG.
+func goldenImage(t *testing.T, name string, img *image.NRGBA) {
+	t.Helper()
+
+	if *update {
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, img); err != nil {
+			t.Fatal(err)
+		}
+		golden(t, name, encoded.Bytes())
+		return
+	}
+
+	want, err := png.Decode(bytes.NewReader(readTestdata(t, name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != want.Bounds() {
+		t.Errorf("%s: bounds = %v, want %v", name, img.Bounds(), want.Bounds())
+		return
+	}
+	for y := 0; y < want.Bounds().Dy(); y++ {
+		for x := 0; x < want.Bounds().Dx(); x++ {
+			if got, want := img.NRGBAAt(x, y), color.NRGBAModel.Convert(want.At(x, y)); got != want {
+				t.Errorf("%s: pixel (%d, %d) = %v, want %v", name, x, y, got, want)
+			}
+		}
+	}
+}
+
+// images.bundle has an 8x8 RGBA32 texture in its .resS file with a sprite
+// atlas that holds a sprite as is and one rotated by the packer, a flipped
+// sprite with its own render data and a sprite of an atlas in another
+// bundle. An unused RGB24 texture has inline image data and another one an
+// unsupported format.
+func TestUnityBundleImages(t *testing.T) {
+	type imageInfo struct {
+		Name      string
+		Container string
+		Width     int
+		Height    int
+	}
+
+	var infos []imageInfo
+	err := UnityBundleImages(readTestdata(t, "images.bundle"), func(img UnityImage) error {
+		infos = append(infos, imageInfo{img.Name, img.Container, img.Image.Rect.Dx(), img.Image.Rect.Dy()})
+		goldenImage(t, filepath.Join("images", img.Name+".png"), img.Image)
+		return nil
+	})
+	if !errors.Is(err, ErrUnsupportedTextureFormat) {
+		t.Errorf("UnityBundleImages returned %v, want the unsupported texture", err)
+	}
+	goldenJSON(t, "images.bundle.json", infos)
+}
+
+// Texture rects count from the bottom left corner and packed sprites are
+// turned back.
+func TestCropSprite(t *testing.T) {
+	// the pixel at (x, y) from the top left holds x and y in red and green
+	texture := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			texture.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	renderData := func(x, y, width, height float32, settings uint32) map[string]interface{} {
+		return map[string]interface{}{
+			"textureRect": map[string]interface{}{"x": x, "y": y, "width": width, "height": height},
+			"settingsRaw": settings,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		renderData map[string]interface{}
+		width      int
+		height     int
+		// pixels of the sprite and the texture pixel they come from
+		pixels map[[2]int][2]int
+	}{
+		{"plain", renderData(0, 0, 4, 3, 1), 4, 3, map[[2]int][2]int{{0, 0}: {0, 5}, {3, 2}: {3, 7}}},
+		{"not packed", renderData(1, 1, 2, 2, 3<<2), 2, 2, map[[2]int][2]int{{0, 0}: {1, 5}, {1, 1}: {2, 6}}},
+		{"flip horizontal", renderData(0, 4, 3, 4, 1|1<<2), 3, 4, map[[2]int][2]int{{0, 0}: {2, 0}, {2, 3}: {0, 3}}},
+		{"flip vertical", renderData(0, 4, 3, 4, 1|2<<2), 3, 4, map[[2]int][2]int{{0, 0}: {0, 3}, {2, 3}: {2, 0}}},
+		{"rotate 180", renderData(0, 4, 3, 4, 1|3<<2), 3, 4, map[[2]int][2]int{{0, 0}: {2, 3}, {2, 3}: {0, 0}}},
+		{"rotate 90", renderData(4, 0, 2, 4, 1|4<<2), 4, 2, map[[2]int][2]int{{0, 0}: {5, 4}, {3, 0}: {5, 7}, {0, 1}: {4, 4}, {3, 1}: {4, 7}}},
+		{"fractional", renderData(0.5, 0.5, 2, 2, 1), 3, 3, map[[2]int][2]int{{0, 0}: {0, 5}}},
+		{"outside", renderData(6, 6, 4, 4, 1), 2, 2, map[[2]int][2]int{{0, 0}: {6, 0}}},
+	}
+	for _, test := range tests {
+		img := cropSprite(texture, test.renderData)
+		if img.Rect.Dx() != test.width || img.Rect.Dy() != test.height {
+			t.Errorf("%s: size = %dx%d, want %dx%d", test.name, img.Rect.Dx(), img.Rect.Dy(), test.width, test.height)
+			continue
+		}
+		for pixel, source := range test.pixels {
+			if got := img.NRGBAAt(pixel[0], pixel[1]); got.R != uint8(source[0]) || got.G != uint8(source[1]) {
+				t.Errorf("%s: pixel %v comes from (%d, %d), want %v", test.name, pixel, got.R, got.G, source)
+			}
+		}
+	}
+}
+
+func TestUnityBundleImagesStop(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := UnityBundleImages(readTestdata(t, "images.bundle"), func(img UnityImage) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("UnityBundleImages returned %v after %d calls, want stop after 1", err, calls)
+	}
+}
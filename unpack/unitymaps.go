@@ -0,0 +1,167 @@
+package unpack
+
+import (
+	"fmt"
+	"sort"
+)
+
+// UnityMap is the cell data of a Dofus 3 map. Properties holds the scalar
+// fields of the map object, like its neighbours and subarea, by their
+// original names.
+type UnityMap struct {
+	ID         int64                  `json:"id"`
+	Properties map[string]interface{} `json:"properties"`
+	Cells      []DLMCell              `json:"cells"`
+}
+
+// Names of the map ID and cell list in the map objects, tried in order.
+var (
+	unityMapIDFields    = []string{"id", "mapId", "m_id"}
+	unityMapCellsFields = []string{"cells", "cellsData", "m_cells"}
+)
+
+// UnityBundleMaps decodes the maps of a Dofus 3 map data bundle. Every object
+// in the MonoBehaviours, including the [SerializeReference] registry, that has
+// a map ID and a cell list is a map. fn is called once per map in object
+// order.
+func UnityBundleMaps(data []byte, fn func(m UnityMap) error) error {
+	bundle, err := NewUnityBundle(data)
+	if err != nil {
+		return err
+	}
+
+	files, err := bundle.SerializedFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		for _, object := range file.ObjectsOfClass(UnityClassMonoBehaviour) {
+			value, err := file.ReadObject(object)
+			if err != nil {
+				return fmt.Errorf("monobehaviour %d: %w", object.PathID, err)
+			}
+			if err := findUnityMaps(value, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func findUnityMaps(value interface{}, fn func(m UnityMap) error) error {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if err := findUnityMaps(elem, fn); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if m, ok := unityMapFromObject(v); ok {
+			return fn(m)
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := findUnityMaps(v[key], fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unityMapFromObject(object map[string]interface{}) (UnityMap, bool) {
+	var id interface{}
+	for _, name := range unityMapIDFields {
+		if value, ok := object[name]; ok {
+			id = value
+			break
+		}
+	}
+	if _, ok := toFloat64(id); !ok {
+		return UnityMap{}, false
+	}
+
+	var cells []interface{}
+	cellsField := ""
+	for _, name := range unityMapCellsFields {
+		if value, ok := object[name]; ok {
+			cells = unityArray(value)
+			cellsField = name
+			break
+		}
+	}
+	if cellsField == "" {
+		return UnityMap{}, false
+	}
+
+	m := UnityMap{
+		ID:         unityInt(id),
+		Properties: make(map[string]interface{}),
+		Cells:      make([]DLMCell, 0, len(cells)),
+	}
+
+	for key, value := range object {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			continue
+		}
+		m.Properties[key] = value
+	}
+
+	for i, value := range cells {
+		cell, _ := value.(map[string]interface{})
+		m.Cells = append(m.Cells, unityMapCell(cell, i))
+	}
+
+	return m, true
+}
+
+// unityMapCell reads the fields the Dofus 2 CellData has under the same
+// names. Missing fields keep their zero value.
+func unityMapCell(cell map[string]interface{}, index int) DLMCell {
+	field := func(names ...string) interface{} {
+		for _, name := range names {
+			if value, ok := cell[name]; ok {
+				return value
+			}
+		}
+		return nil
+	}
+
+	result := DLMCell{CellID: index}
+	if id := field("id", "cellId", "cellNumber"); id != nil {
+		result.CellID = int(unityInt(id))
+	}
+	result.Floor = int(unityInt(field("floor")))
+	result.Mov = unityBool(field("mov", "walkable"))
+	result.Los = unityBool(field("los"))
+	result.NonWalkableDuringFight = unityBool(field("nonWalkableDuringFight"))
+	result.NonWalkableDuringRP = unityBool(field("nonWalkableDuringRP"))
+	result.Visible = unityBool(field("visible"))
+	result.FarmCell = unityBool(field("farmCell"))
+	result.HavenbagCell = unityBool(field("havenbagCell"))
+	result.Blue = unityBool(field("blue"))
+	result.Red = unityBool(field("red"))
+	result.TopArrow = unityBool(field("topArrow", "useTopArrow"))
+	result.BottomArrow = unityBool(field("bottomArrow", "useBottomArrow"))
+	result.RightArrow = unityBool(field("rightArrow", "useRightArrow"))
+	result.LeftArrow = unityBool(field("leftArrow", "useLeftArrow"))
+	result.Speed = int8(unityInt(field("speed")))
+	result.MapChangeData = uint8(unityInt(field("mapChangeData")))
+	result.MoveZone = uint8(unityInt(field("moveZone")))
+	result.LinkedZone = uint8(unityInt(field("linkedZone")))
+	return result
+}
+
+func unityBool(value interface{}) bool {
+	if b, ok := value.(bool); ok {
+		return b
+	}
+	return unityInt(value) != 0
+}
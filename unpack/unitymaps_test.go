@@ -0,0 +1,41 @@
+package unpack
+
+import (
+	"errors"
+	"testing"
+)
+
+// mapdata.bundle has a MonoBehaviour with a list of maps, one without cells,
+// next to objects with an ID but no cells, and a map with other field names
+// in its [SerializeReference] registry. A second MonoBehaviour has no maps.
+func TestUnityBundleMaps(t *testing.T) {
+	var maps []UnityMap
+	err := UnityBundleMaps(readTestdata(t, "mapdata.bundle"), func(m UnityMap) error {
+		maps = append(maps, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	goldenJSON(t, "mapdata.bundle.json", maps)
+
+	var ids []int64
+	for _, m := range maps {
+		ids = append(ids, m.ID)
+	}
+	if len(ids) != 3 || ids[0] != 1001 || ids[1] != 1002 || ids[2] != 1<<33+7 {
+		t.Errorf("map IDs = %v, want [1001 1002 %d]", ids, int64(1<<33+7))
+	}
+}
+
+func TestUnityBundleMapsStop(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := UnityBundleMaps(readTestdata(t, "mapdata.bundle"), func(m UnityMap) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("UnityBundleMaps returned %v after %d calls, want stop after 1", err, calls)
+	}
+}
@@ -0,0 +1,121 @@
+package unpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var errUnexpectedEOF = errors.New("unexpected end of unity data")
+
+// unityReader reads the in-memory unity formats. Reading out of bounds panics
+// with errUnexpectedEOF, which the exported entry points turn into an error.
+type unityReader struct {
+	data      []byte
+	pos       int
+	bigEndian bool
+}
+
+func newUnityReader(data []byte, bigEndian bool) *unityReader {
+	return &unityReader{data: data, bigEndian: bigEndian}
+}
+
+func (r *unityReader) order() binary.ByteOrder {
+	if r.bigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func (r *unityReader) next(n int) []byte {
+	if n < 0 || r.pos+n > len(r.data) {
+		panic(errUnexpectedEOF)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *unityReader) align(n int) {
+	if rest := r.pos % n; rest != 0 {
+		r.pos += n - rest
+	}
+}
+
+func (r *unityReader) ReadBytes(n int) []byte {
+	return r.next(n)
+}
+
+func (r *unityReader) ReadBool() bool {
+	return r.next(1)[0] != 0
+}
+
+func (r *unityReader) ReadInt8() int8 {
+	return int8(r.next(1)[0])
+}
+
+func (r *unityReader) ReadUint8() uint8 {
+	return r.next(1)[0]
+}
+
+func (r *unityReader) ReadInt16() int16 {
+	return int16(r.order().Uint16(r.next(2)))
+}
+
+func (r *unityReader) ReadUint16() uint16 {
+	return r.order().Uint16(r.next(2))
+}
+
+func (r *unityReader) ReadInt32() int32 {
+	return int32(r.order().Uint32(r.next(4)))
+}
+
+func (r *unityReader) ReadUint32() uint32 {
+	return r.order().Uint32(r.next(4))
+}
+
+func (r *unityReader) ReadInt64() int64 {
+	return int64(r.order().Uint64(r.next(8)))
+}
+
+func (r *unityReader) ReadUint64() uint64 {
+	return r.order().Uint64(r.next(8))
+}
+
+func (r *unityReader) ReadFloat32() float32 {
+	return math.Float32frombits(r.ReadUint32())
+}
+
+func (r *unityReader) ReadFloat64() float64 {
+	return math.Float64frombits(r.ReadUint64())
+}
+
+// ReadCString reads a null terminated string.
+func (r *unityReader) ReadCString() string {
+	for i := r.pos; i < len(r.data); i++ {
+		if r.data[i] == 0 {
+			str := string(r.data[r.pos:i])
+			r.pos = i + 1
+			return str
+		}
+	}
+	panic(errUnexpectedEOF)
+}
+
+// ReadAlignedString reads an int32 length prefixed string.
+func (r *unityReader) ReadAlignedString() string {
+	length := int(r.ReadInt32())
+	return string(r.next(length))
+}
+
+// recoverUnityError converts a read panic into an error for the caller.
+func recoverUnityError(err *error) {
+	if recovered := recover(); recovered != nil {
+		if e, ok := recovered.(error); ok && errors.Is(e, errUnexpectedEOF) {
+			*err = e
+			return
+		}
+		*err = fmt.Errorf("unity: %v", recovered)
+	}
+}
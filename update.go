@@ -1,15 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -17,20 +13,15 @@ import (
 	"sync"
 
 	"github.com/charmbracelet/log"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
 	"github.com/dofusdude/ankabuffer"
+	"github.com/dofusdude/doduda/pkg/doduda"
 	"github.com/dofusdude/doduda/ui"
-	"github.com/dofusdude/doduda/unpack"
-	jsnan "github.com/xhhuango/json"
 )
 
-type HashFile struct {
-	Hash         string
-	Filename     string
-	FriendlyName string
-}
+type (
+	HashFile         = doduda.HashFile
+	IncrementalState = doduda.IncrementalState
+)
 
 func PartitionSlice[T any](items []T, parts int) (chunks [][]T) {
 	var divided [][]T
@@ -59,31 +50,6 @@ func Values[M ~map[K]V, K comparable, V any](m M) []V {
 	return r
 }
 
-func GetLatestLauncherVersion(release string) string {
-	versionResponse, err := http.Get("https://cytrus.cdn.ankama.com/cytrus.json")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	versionBody, err := io.ReadAll(versionResponse.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	var versionJson map[string]interface{}
-	err = json.Unmarshal(versionBody, &versionJson)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	games := versionJson["games"].(map[string]interface{})
-	dofus := games["dofus"].(map[string]interface{})
-	platform := dofus["platforms"].(map[string]interface{})
-	windows := platform["windows"].(map[string]interface{})
-
-	return windows[release].(string)
-}
-
 func touchFileIfNotExists(fileName string) error {
 	_, err := os.Stat(fileName)
 	if os.IsNotExist(err) {
@@ -91,37 +57,19 @@ func touchFileIfNotExists(fileName string) error {
 		if err != nil {
 			return err
 		}
-		defer func() {
-			if err := file.Close(); err != nil {
-				log.Fatal(err)
-			}
-		}()
+		return file.Close()
 	}
 
 	return nil
 }
 
-func CreateDataDirectoryStructure(dir string) {
-	os.MkdirAll(fmt.Sprintf("%s/data", dir), os.ModePerm)
-	os.MkdirAll(fmt.Sprintf("%s/images", dir), os.ModePerm)
-	os.MkdirAll(fmt.Sprintf("%s/languages", dir), os.ModePerm)
-}
-
-func GetReleaseManifest(version string, gameVersionType string, platform string, dir string) ([]byte, error) {
-	gameHashesUrl := fmt.Sprintf("https://cytrus.cdn.ankama.com/dofus/releases/%s/%s/%s.manifest", gameVersionType, platform, version)
-	hashResponse, err := http.Get(gameHashesUrl)
-	if err != nil {
-		log.Fatal("Could not get manifest file", err)
-		return nil, err
-	}
-
-	hashBody, err := io.ReadAll(hashResponse.Body)
-	if err != nil {
-		log.Fatal("Could not read response body of manifest file", err)
-		return nil, err
+func CreateDataDirectoryStructure(dir string) error {
+	for _, sub := range []string{"data", "images", "languages"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), os.ModePerm); err != nil {
+			return newError(KindIO, "create output directories", err)
+		}
 	}
-
-	return hashBody, nil
+	return nil
 }
 
 func contains(arr []string, str string) bool {
@@ -165,9 +113,62 @@ func humanFileSize(bytes float64, decimal bool, precision int) string {
 	return fmt.Sprintf("%.*f %s", precision, bytes, units[u])
 }
 
-func Download(releaseChannel string, version string, dir string, clean bool, fullGame bool, platform string, bin int, manifest string, ignore []string, indent string, headless bool) error {
+// DownloadParams controls Download, the root command and `doduda run` fill it
+// from their flags and config.
+type DownloadParams struct {
+	Release      string
+	Platform     string
+	Version      string // game version or "latest"
+	Dir          string
+	CacheIgnore  bool   // always fetch the manifest
+	Manifest     string // manifest file to use, empty looks for ManifestPath
+	ManifestPath string // where fetched manifests are cached, defaults to manifest.json
+	Full         bool
+	Fragments    []string // fragments of a full download, empty downloads all
+	Incremental  bool
+	Bin          int
+	Ignore       []string // parts to skip: languages, data, images
+	Maps         bool
+	Languages    []string // empty downloads all languages of the game version
+	Images       *ImageLayout
+	Indent       string
+	Headless     bool
+	DryRun       bool // print the plan instead of downloading
+	PlanJSON     bool // print the plan as JSON instead of tables
+
+	Client *doduda.Client         // nil uses the client of the root command
+	Rules  *doduda.SelectionRules // nil uses the rules of the root command
+}
+
+func (p DownloadParams) client() *doduda.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return cdn
+}
+
+func (p DownloadParams) rules() *doduda.SelectionRules {
+	if p.Rules != nil {
+		return p.Rules
+	}
+	return selection
+}
+
+// Download loads the manifest of a version and downloads the game or its data,
+// languages and images into p.Dir. Temporary files are removed in any case,
+// also when ctx is canceled.
+func Download(ctx context.Context, p DownloadParams) error {
 	var ankaManifest ankabuffer.Manifest
 	manifestSearchPath := "manifest.json"
+	if p.ManifestPath != "" {
+		manifestSearchPath = p.ManifestPath
+	}
+	// the spinner writes to stdout, keep it out of the JSON plan
+	headless := p.Headless || (p.DryRun && p.PlanJSON)
+	dir := p.Dir
+	bin := p.Bin
+	client := p.client()
+	rules := p.rules()
 
 	var manifestWg sync.WaitGroup
 	feedbacks := make(chan string)
@@ -177,76 +178,68 @@ func Download(releaseChannel string, version string, dir string, clean bool, ful
 		ui.Spinner("Manifest", feedbacks, false, headless)
 	}()
 
-	if isChannelClosed(feedbacks) {
-		os.Exit(1)
+	closeSpinner := func() {
+		if feedbacks != nil {
+			close(feedbacks)
+			manifestWg.Wait()
+			feedbacks = nil
+		}
 	}
+	defer closeSpinner()
+
 	feedbacks <- "loading"
 
 	var manifestPath string
-	if manifest == "" {
+	if p.Manifest == "" {
 		if _, err := os.Stat(manifestSearchPath); os.IsNotExist(err) {
 			manifestPath = ""
 		} else {
 			manifestPath, err = filepath.Abs(manifestSearchPath)
 			if err != nil {
-				log.Fatal(err)
+				return newError(KindIO, "manifest", err)
 			}
 		}
 	} else {
 		var err error
-		if _, err := os.Stat(manifest); os.IsNotExist(err) {
-			log.Fatal(err)
+		if _, err := os.Stat(p.Manifest); err != nil {
+			return newError(KindUsage, "manifest", err)
 		}
-		manifestPath, err = filepath.Abs(manifest)
+		manifestPath, err = filepath.Abs(p.Manifest)
 		if err != nil {
-			log.Fatal(err)
+			return newError(KindIO, "manifest", err)
 		}
 	}
 
 	var dofusVersion string
 
-	if manifestPath == "" || clean {
-		cytrusPrefix := "6.0_"
-		if version == "latest" {
-			version = GetLatestLauncherVersion(releaseChannel)
-		} else {
-			// ATT: prefix changes with cytrus updates
-			if !strings.HasPrefix(version, cytrusPrefix) {
-				version = fmt.Sprintf("%s%s", cytrusPrefix, version)
-			}
-		}
-
-		dofusVersion = strings.TrimPrefix(version, cytrusPrefix)
-
-		rawManifest, err := GetReleaseManifest(version, releaseChannel, platform, dir)
+	if manifestPath == "" || p.CacheIgnore {
+		parsedManifest, err := client.FetchManifest(ctx, doduda.ManifestOptions{Release: p.Release, Platform: p.Platform, Version: p.Version})
 		if err != nil {
 			return err
 		}
+		ankaManifest = *parsedManifest
+		dofusVersion = ankaManifest.GameVersion
 
-		feedbacks <- "parsing"
-		ankaManifest = *ankabuffer.ParseManifest(rawManifest, dofusVersion)
-
-		marshalledBytes, err := json.Marshal(ankaManifest)
-		if err != nil {
-			log.Fatal(err)
+		if !p.DryRun {
+			marshalledBytes, err := json.Marshal(ankaManifest)
+			if err != nil {
+				return newError(KindManifest, "manifest", err)
+			}
+			err = os.WriteFile(manifestSearchPath, marshalledBytes, os.ModePerm)
+			if err != nil {
+				return newError(KindIO, "save manifest", err)
+			}
 		}
-		os.WriteFile(manifestSearchPath, marshalledBytes, os.ModePerm)
 	} else {
 		log.Debug("Using cached manifest")
-		manifestFile, err := os.Open(manifestPath)
+		byteValue, err := os.ReadFile(manifestPath)
 		if err != nil {
-			log.Fatal(err)
-		}
-		defer manifestFile.Close()
-
-		byteValue, err := io.ReadAll(manifestFile)
-		if err != nil {
-			log.Fatal(err)
+			return newError(KindIO, "cached manifest", err)
 		}
 
 		err = json.Unmarshal(byteValue, &ankaManifest)
 		if err != nil {
-			log.Fatal(err)
+			return newError(KindManifest, "cached manifest "+manifestPath, err)
 		}
 
 		dofusVersion = ankaManifest.GameVersion
@@ -254,19 +247,40 @@ func Download(releaseChannel string, version string, dir string, clean bool, ful
 
 	rawDofusMajorVersion, err := strconv.Atoi(strings.Split(dofusVersion, ".")[0])
 	if err != nil {
-		log.Fatal("Invalid version")
+		return newError(KindManifest, "manifest", fmt.Errorf("invalid version %q", dofusVersion))
+	}
+
+	var state *IncrementalState
+	if p.Incremental {
+		state, err = doduda.LoadIncrementalState(dir)
+		if err != nil {
+			return newError(KindIO, "incremental state", err)
+		}
+		state.GameVersion = dofusVersion
 	}
 
 	betaSuffix := ""
-	if strings.Contains(releaseChannel, "beta") {
+	if strings.Contains(p.Release, "beta") {
 		betaSuffix = " [beta]"
 	}
 	feedbacks <- dofusVersion + betaSuffix
 
-	close(feedbacks)
-	manifestWg.Wait()
+	closeSpinner()
+
+	if p.DryRun {
+		plan := PlanDownload(&ankaManifest, p, rawDofusMajorVersion, state)
+		if p.PlanJSON {
+			err = plan.WriteJSON(os.Stdout, p.Indent)
+		} else {
+			err = plan.WriteText(os.Stdout)
+		}
+		if err != nil {
+			return newError(KindIO, "plan", err)
+		}
+		return nil
+	}
 
-	if fullGame {
+	if p.Full {
 		var fullGameUiWg sync.WaitGroup
 		feedbacks := make(chan string)
 		fullGameUiWg.Add(1)
@@ -274,14 +288,17 @@ func Download(releaseChannel string, version string, dir string, clean bool, ful
 			defer fullGameUiWg.Done()
 			ui.Spinner(ankaManifest.GameVersion, feedbacks, false, headless)
 		}()
-
-		if isChannelClosed(feedbacks) {
-			os.Exit(1)
-		}
+		defer func() {
+			close(feedbacks)
+			fullGameUiWg.Wait()
+		}()
 
 		var totalSize int64
 		fragmentFiles := map[string][]HashFile{}
 		for _, fragment := range ankaManifest.Fragments {
+			if len(p.Fragments) > 0 && !contains(p.Fragments, fragment.Name) {
+				continue
+			}
 			for _, fragmentFile := range fragment.Files {
 				if fragmentFile.Name == "" {
 					continue
@@ -303,369 +320,84 @@ func Download(releaseChannel string, version string, dir string, clean bool, ful
 		for fragmentName, files := range fragmentFiles {
 			fragmentCounter++
 			feedbacks <- "Fragment " + strconv.Itoa(fragmentCounter) + "/" + strconv.Itoa(totalFragments)
-			err = DownloadUnpackFiles(ankaManifest.GameVersion, bin, &ankaManifest, fragmentName, files, dir, dir, false, "", headless, false)
+			err = downloadFiles(ctx, client, &ankaManifest, files, doduda.DownloadOptions{Title: ankaManifest.GameVersion, Fragment: fragmentName, DestDir: dir, BinSize: bin, State: state}, headless)
 			if err != nil {
 				return err
 			}
 		}
-
-		close(feedbacks)
-		fullGameUiWg.Wait()
-
 	} else {
-		CreateDataDirectoryStructure(dir)
+		if err := CreateDataDirectoryStructure(dir); err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Join(dir, "tmp"))
 
-		if !contains(ignore, "languages") {
-			if err := DownloadLanguages(releaseChannel, &ankaManifest, bin, rawDofusMajorVersion, dir, indent, headless); err != nil {
-				log.Fatal(err)
+		if !contains(p.Ignore, "languages") {
+			if err := DownloadLanguages(ctx, client, p.Release, &ankaManifest, p.Languages, bin, rawDofusMajorVersion, dir, p.Indent, headless, state); err != nil {
+				return err
 			}
 		}
 
-		if !contains(ignore, "data") {
-			if err := DownloadGameData(&ankaManifest, bin, rawDofusMajorVersion, dir, indent, headless); err != nil {
-				log.Fatal(err)
+		if !contains(p.Ignore, "data") {
+			if err := DownloadGameData(ctx, client, rules, &ankaManifest, bin, rawDofusMajorVersion, dir, p.Indent, headless, state); err != nil {
+				return err
 			}
 		}
 
-		if !contains(ignore, "images") {
-			if err := DownloadImagesLauncher(&ankaManifest, bin, rawDofusMajorVersion, dir, headless); err != nil {
-				log.Fatal(err)
+		if !contains(p.Ignore, "images") {
+			if err := DownloadImagesLauncher(ctx, client, rules, &ankaManifest, p.Images, bin, rawDofusMajorVersion, dir, headless, state); err != nil {
+				return err
 			}
 		}
 
-		os.RemoveAll(fmt.Sprintf("%s/tmp", dir))
-	}
-
-	return nil
-}
-
-func DownloadBundle(bundleHash string) ([]byte, error) {
-	url := fmt.Sprintf("https://cytrus.cdn.ankama.com/dofus/bundles/%s/%s", bundleHash[0:2], bundleHash)
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bundle %s status %d", bundleHash, resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
-}
-
-// TODO category not used anymore
-func UnpackUnityBundle(category string, inputPath string, outputPath string, muteSpinner bool, headless bool) error {
-	if !strings.HasSuffix(inputPath, ".bundle") {
-		return fmt.Errorf("invalid bundle suffix")
-	}
-
-	if !strings.HasSuffix(outputPath, ".json") {
-		return fmt.Errorf("can only output to json")
-	}
-
-	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
-		return fmt.Errorf("bundle %s does not exist", inputPath)
-	}
-
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return err
-	}
-	defer cli.Close()
-
-	outputTrimmed := strings.TrimSuffix(outputPath, ".asset.json")
-	outputFileName := outputTrimmed + ".json"
-
-	inputRawFileName := filepath.Base(inputPath)
-	outputRawFileName := filepath.Base(outputFileName)
-
-	inputDir := filepath.Dir(inputPath)
-
-	cmd := []string{"dotnet", "out/unity-bundle-unwrap.dll", path.Join("/app", "data", inputRawFileName), path.Join("/app", "data", outputRawFileName)}
-
-	ctx := context.Background()
-	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: "stelzo/doduda-umbu:" + ARCH,
-		Cmd:   cmd,
-		Volumes: map[string]struct{}{
-			"/app/data": {},
-		},
-	}, &container.HostConfig{
-		Binds: []string{
-			fmt.Sprintf("%s:/app/data", inputDir),
-		},
-		AutoRemove: true,
-	}, nil, nil, "")
-	if err != nil {
-		return err
-	}
-
-	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return err
-	}
-
-	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return err
+		if p.Maps {
+			if err := DownloadMaps(ctx, client, rules, &ankaManifest, bin, rawDofusMajorVersion, dir, p.Indent, headless, state); err != nil {
+				return err
+			}
 		}
-	case <-statusCh:
 	}
 
 	return nil
 }
 
-func PullImages(images []string, muteSpinner bool, headless bool) error {
-	feedbacks := make(chan string)
-
-	var feedbackWg sync.WaitGroup
-	if !muteSpinner {
-		feedbackWg.Add(1)
-		go func() {
-			defer feedbackWg.Done()
-			ui.Spinner("Docker", feedbacks, false, headless)
-		}()
-	}
+// selectFiles returns the files that the selection rules of group pick from
+// the manifest and logs the ones the last run into dir did not pick.
+func selectFiles(rules *doduda.SelectionRules, manifest *ankabuffer.Manifest, group string, version int, dir string) (map[string][]HashFile, error) {
+	selected := rules.Select(manifest, group, version)
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	known, err := doduda.LoadKnownBundles(dir)
 	if err != nil {
-		return err
+		return nil, newError(KindIO, "known bundles", err)
 	}
-	defer cli.Close()
 
-	for _, imageRef := range images {
-		ctx := context.Background()
-		feedbacks <- "Pulling " + imageRef
-		imageHandle, err := cli.ImagePull(ctx, imageRef, image.PullOptions{})
-		if err != nil {
-			return err
-		}
-		imageHandle.Close()
+	for _, file := range known.Discover(group, selected) {
+		log.Warn("Newly discovered", "group", group, "file", file.Filename, "name", file.FriendlyName)
 	}
 
-	close(feedbacks)
-	feedbackWg.Wait()
+	if err := known.Save(); err != nil {
+		return nil, newError(KindIO, "known bundles", err)
+	}
 
-	return nil
+	return selected, nil
 }
 
-func UnpackUnityImages(inputDir string, outputDir string, muteSpinner bool, headless bool) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Fatal(err)
+// downloadSelection downloads the files returned by selectFiles fragment by
+// fragment, opts.Fragment is set for each of them.
+func downloadSelection(ctx context.Context, client *doduda.Client, manifest *ankabuffer.Manifest, selected map[string][]HashFile, opts doduda.DownloadOptions, headless bool) error {
+	fragments := make([]string, 0, len(selected))
+	for fragment := range selected {
+		fragments = append(fragments, fragment)
 	}
-	defer cli.Close()
-
-	imageName := "stelzo/assetstudio-cli:" + ARCH
+	sort.Strings(fragments)
 
-	ctx := context.Background()
-	bundles, err := os.ReadDir(inputDir)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	for _, bundle := range bundles {
-		if bundle.IsDir() || !strings.HasSuffix(bundle.Name(), ".imagebundle") {
-			continue
-		}
-
-		absInputPath := filepath.Join(inputDir, bundle.Name())
-
-		cmd := []string{"./data", "--unity-version", "2022.3.29f1"}
-
-		uid := strconv.Itoa(os.Getuid())
-		gid := strconv.Itoa(os.Getgid())
-		user := uid + ":" + gid
-		if uidInt, err := strconv.Atoi(uid); err != nil || uidInt < 0 || uidInt > 2147483647 {
-			user = "0:0" // fallback to root if UID is invalid
-		}
-		if gidInt, err := strconv.Atoi(gid); err != nil || gidInt < 0 || gidInt > 2147483647 {
-			user = "0:0" // fallback to root if GID is invalid
-		}
-		resp, err := cli.ContainerCreate(ctx, &container.Config{
-			Image: imageName,
-			Cmd:   cmd,
-			User:  user,
-			Volumes: map[string]struct{}{
-				"/app/AssetStudio/data":     {},
-				"/app/AssetStudio/ASExport": {},
-			},
-		}, &container.HostConfig{
-			Binds: []string{
-				fmt.Sprintf("%s:/app/AssetStudio/data", absInputPath),
-				fmt.Sprintf("%s:/app/AssetStudio/ASExport", outputDir)},
-			AutoRemove: true,
-		}, nil, nil, "")
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+	for _, fragment := range fragments {
+		opts.Fragment = fragment
+		if err := downloadFiles(ctx, client, manifest, selected[fragment], opts, headless); err != nil {
 			return err
 		}
-
-		statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-		select {
-		case err := <-errCh:
-			if err != nil {
-				return err
-			}
-		case <-statusCh:
-		}
-
 	}
-
 	return nil
 }
 
-func Unpack(file string, dir string, destDir string, category string, indent string, muteSpinner bool, headless bool) {
-	suffix := filepath.Ext(file)[1:]
-
-	if suffix == "png" || suffix == "jpg" || suffix == "jpeg" {
-		return // no need to unpack images files
-	}
-
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		log.Fatal(err)
-	}
-
-	fileNoExt := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
-	absOutPath := filepath.Join(destDir, fileNoExt+".json")
-
-	supportedUnpack := []string{"d2o", "d2i", "imagebundle", "bundle", "bin"}
-	isSupported := false
-	for _, unpackType := range supportedUnpack {
-		if suffix == unpackType {
-			isSupported = true
-			break
-		}
-	}
-
-	if !isSupported {
-		log.Warnf("Unsupported file type for unpacking %s", suffix)
-	}
-
-	if suffix == "d2o" {
-		f, err := os.Open(file)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer func() {
-			err := f.Close()
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
-
-		reader, err := unpack.NewD2OReader(f)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		objects := reader.GetObjects()
-		var marshalledBytes []byte
-		if indent != "" {
-			marshalledBytes, err = jsnan.MarshalIndent(objects, "", indent)
-		} else {
-			marshalledBytes, err = jsnan.Marshal(objects)
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
-		marshalledBytes = bytes.Replace(marshalledBytes, []byte("NaN"), []byte("null"), -1)
-
-		err = os.WriteFile(absOutPath, marshalledBytes, os.ModePerm)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	if suffix == "d2i" {
-		f, err := os.Open(file)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer func() {
-			err := f.Close()
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
-
-		data := unpack.NewD2I(f).Read()
-
-		var marshalledBytes []byte
-		if indent != "" {
-			marshalledBytes, err = jsnan.MarshalIndent(data, "", indent)
-		} else {
-			marshalledBytes, err = jsnan.Marshal(data)
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
-		marshalledBytes = bytes.Replace(marshalledBytes, []byte("NaN"), []byte("null"), -1)
-
-		err = os.WriteFile(absOutPath, marshalledBytes, os.ModePerm)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	if suffix == "imagebundle" {
-		dir := filepath.Dir(file)
-		err := UnpackUnityImages(dir, destDir, muteSpinner, headless)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	if suffix == "bundle" {
-		err := UnpackUnityBundle(category, file, absOutPath, muteSpinner, headless)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	if suffix == "bin" {
-		rawData, err := os.ReadFile(file)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		langWithExt := filepath.Base(file)
-		langWithoutExt := strings.TrimSuffix(langWithExt, filepath.Ext(langWithExt))
-
-		entities, err := unpack.LanguageExtractStrings(rawData)
-		data := map[string]interface{}{
-			"language": langWithoutExt,
-			"texts":    entities,
-		}
-
-		var marshalledBytes []byte
-		if indent != "" {
-			marshalledBytes, err = jsnan.MarshalIndent(data, "", indent)
-		} else {
-			marshalledBytes, err = jsnan.Marshal(data)
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
-		marshalledBytes = bytes.Replace(marshalledBytes, []byte("NaN"), []byte("null"), -1)
-
-		err = os.WriteFile(absOutPath, marshalledBytes, os.ModePerm)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-}
-
 func isChannelClosed[T any](ch chan T) bool {
 	select {
 	case _, ok := <-ch:
@@ -678,259 +410,70 @@ func isChannelClosed[T any](ch chan T) bool {
 	return false
 }
 
-func convertMBToBytes(mb int) int64 {
-	return int64(mb) * 1024 * 1024
-}
-
-func splitFilesIntoBins(files []ankabuffer.File, maxMBPerBin int) [][]ankabuffer.File {
-	maxBytesPerBin := convertMBToBytes(maxMBPerBin)
-	var bins [][]ankabuffer.File
-	var currentBin []ankabuffer.File
-	var currentBinSize int64
-
-	for _, file := range files {
-		if file.Size > maxBytesPerBin {
-			if len(currentBin) > 0 {
-				bins = append(bins, currentBin)
-				currentBin = nil
-				currentBinSize = 0
-			}
-			bins = append(bins, []ankabuffer.File{file})
-			continue
-		}
-
-		if currentBinSize+file.Size > maxBytesPerBin {
-			bins = append(bins, currentBin)
-			currentBin = []ankabuffer.File{file}
-			currentBinSize = file.Size
-		} else {
-			currentBin = append(currentBin, file)
-			currentBinSize += file.Size
-		}
-	}
-
-	if len(currentBin) > 0 {
-		bins = append(bins, currentBin)
-	}
+// downloadFiles downloads the files of a fragment with the client and shows
+// a spinner and a progress bar for each bin. Closing the interface cancels
+// the download.
+func downloadFiles(ctx context.Context, client *doduda.Client, manifest *ankabuffer.Manifest, toDownload []HashFile, opts doduda.DownloadOptions, headless bool) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	return bins
-}
+	var feedbacks chan string
+	var bundleUpdates chan bool
+	var feedbackWg sync.WaitGroup
 
-func DownloadUnpackFiles(title string, bin int, manifest *ankabuffer.Manifest, fragment string, toDownload []HashFile, dir string, destDir string, unpack bool, indent string, silent bool, muteSpinner bool) error {
-	var filesToDownload []ankabuffer.File
-	toDownloadFiltered := []HashFile{}
-	for _, file := range toDownload {
-		if manifest.Fragments[fragment].Files[file.Filename].Name == "" {
-			continue
+	closeSpinner := func() {
+		if feedbacks != nil {
+			close(feedbacks)
+			feedbackWg.Wait()
+			feedbacks = nil
 		}
-		toDownloadFiltered = append(toDownloadFiltered, file)
 	}
 
-	toDownload = toDownloadFiltered
+	opts.OnProgress = func(p doduda.Progress) {
+		innerTitle := fmt.Sprintf("%s (%d/%d)", opts.Title, p.Bin, p.Bins)
 
-	for i, file := range toDownload {
-		filesToDownload = append(filesToDownload, manifest.Fragments[fragment].Files[file.Filename])
-		toDownload[i].Hash = manifest.Fragments[fragment].Files[file.Filename].Hash
-	}
-
-	var filebins [][]ankabuffer.File
-	if bin > 0 {
-		filebins = splitFilesIntoBins(filesToDownload, bin)
-	} else {
-		filebins = [][]ankabuffer.File{filesToDownload}
-	}
-
-	for idx, filesToDownload := range filebins {
-		innerTitle := fmt.Sprintf("%s (%d/%d)", title, idx+1, len(filebins))
-
-		feedbacks := make(chan string)
-
-		var feedbackWg sync.WaitGroup
-		if !muteSpinner {
+		switch p.Stage {
+		case doduda.StagePrepare:
+			feedbacks = make(chan string)
 			feedbackWg.Add(1)
 			go func() {
 				defer feedbackWg.Done()
-				ui.Spinner(innerTitle, feedbacks, false, silent)
+				ui.Spinner(innerTitle, feedbacks, false, headless)
 			}()
-		}
-
-		if !muteSpinner {
-			feedbacks <- fmt.Sprintf("preparing %d files", len(filesToDownload))
-		}
-
-		bundles := ankabuffer.GetNeededBundles(filesToDownload)
-
-		if len(bundles) == 0 && len(filesToDownload) > 0 {
-			for _, file := range filesToDownload {
-				log.Warn("Missing bundle for", file.Name)
+			feedbacks <- fmt.Sprintf("preparing %d files", p.Total)
+		case doduda.StageDownload:
+			if p.Current == 0 {
+				feedbacks <- "downloading"
+				closeSpinner()
+
+				bundleUpdates = make(chan bool, p.Total+1)
+				feedbackWg.Add(1)
+				go func() {
+					defer feedbackWg.Done()
+					ui.Progress(innerTitle, p.Total+1, bundleUpdates, 0, true, headless)
+				}()
+				return
 			}
-		}
-
-		if len(bundles) == 0 {
-			//log.Warn("No bundles to download") // TODO it seems like the files come out okay even if there are no bundles, maybe warning is not needed
-			if !muteSpinner {
-				close(feedbacks)
-				feedbackWg.Wait()
+			if isChannelClosed(bundleUpdates) {
+				cancel(errCanceledByUser)
+				return
 			}
-			continue
-		}
-
-		bundlesMap := ankabuffer.GetBundleHashMap(manifest)
-
-		type DownloadedBundle struct {
-			BundleHash string
-			Data       []byte
-		}
-
-		bundlesBuffer := make(map[string]DownloadedBundle)
-
-		if !muteSpinner {
-			feedbacks <- "downloading"
-			close(feedbacks)
-		}
-		feedbackWg.Wait()
-
-		bundleUpdates := make(chan bool, len(bundles))
-		feedbackWg.Add(1)
-		go func() {
-			defer feedbackWg.Done()
-			ui.Progress(innerTitle, len(bundles)+1, bundleUpdates, 0, true, silent)
-		}()
-
-		var bundleDownloadWg sync.WaitGroup
-		var bundleDownloadMu sync.Mutex
-
-		for _, bundle := range bundles {
-			bundleDownloadWg.Add(1)
-			go func(bundle string) {
-				defer bundleDownloadWg.Done()
-
-				bundleData, err := DownloadBundle(bundle)
-				if err != nil {
-					log.Errorf("Could not download bundle %s: %s\n", bundle, err)
-					return
-				}
-
-				bundleDownloadMu.Lock()
-				bundlesBuffer[bundle] = DownloadedBundle{BundleHash: bundle, Data: bundleData}
-				bundleDownloadMu.Unlock()
-
-				if isChannelClosed(bundleUpdates) {
-					os.Exit(1)
-				}
-				bundleUpdates <- true
-			}(bundle)
-		}
-
-		bundleDownloadWg.Wait()
-
-		var wg sync.WaitGroup
-		for i, file := range filesToDownload {
-			wg.Add(1)
-			go func(file ankabuffer.File, bundlesBuffer map[string]DownloadedBundle, dir string, destDir string, i int) {
-				defer wg.Done()
-				var fileData []byte
-
-				if file.Chunks == nil || len(file.Chunks) == 0 { // file is not chunked
-					for _, bundle := range bundlesBuffer {
-						for _, chunk := range bundlesMap[bundle.BundleHash].Chunks {
-							if chunk.Hash == file.Hash {
-								fileData = bundle.Data[chunk.Offset : chunk.Offset+chunk.Size]
-								break
-							}
-						}
-						if fileData != nil {
-							break
-						}
-					}
-				} else { // file is chunked
-					type ChunkData struct {
-						Data   []byte
-						Offset int64
-						Size   int64
-					}
-					var chunksData []ChunkData
-					for _, chunk := range file.Chunks { // all chunks of the file
-						for _, bundle := range bundlesBuffer { // search in downloaded bundles for the chunk
-							foundChunk := false
-							for _, bundleChunk := range bundlesMap[bundle.BundleHash].Chunks { // each chunk of the searched bundle could be a chunk of the file
-								if bundleChunk.Hash == chunk.Hash {
-									foundChunk = true
-									if len(bundle.Data) < int(bundleChunk.Offset+bundleChunk.Size) {
-										err := fmt.Errorf("bundle data is too small. Bundle offset/size: %d/%d, BundleData length: %d, BundleHash: %s, BundleChunkHash: %s", bundleChunk.Offset, bundleChunk.Size, len(bundle.Data), bundle.BundleHash, bundleChunk.Hash)
-										log.Fatal(err)
-									}
-
-									chunksData = append(chunksData, ChunkData{Data: bundle.Data[bundleChunk.Offset : bundleChunk.Offset+bundleChunk.Size], Offset: chunk.Offset, Size: chunk.Size})
-								}
-							}
-							if foundChunk {
-								break
-							}
-						}
-					}
-					sort.Slice(chunksData, func(i, j int) bool {
-						return chunksData[i].Offset < chunksData[j].Offset
-					})
-					//if len(chunksData) > 1 {
-					//	log.Println("Chunks data", chunksData[0].Offset, chunksData[len(chunksData)-1].Offset)
-					//}
-					for _, chunk := range chunksData {
-						fileData = append(fileData, chunk.Data...)
-					}
-				}
-
-				if len(fileData) == 0 {
-					err := fmt.Errorf("file data is empty %s", file.Hash)
-					log.Fatal(err)
-				}
-
-				offlineFilePath := filepath.Join(destDir, toDownload[i].FriendlyName)
-
-				// anonymous function to safely defer closing file
-				func() {
-					path := filepath.Dir(offlineFilePath)
-					_ = os.MkdirAll(path, os.ModePerm)
-
-					fp, err := os.Create(offlineFilePath)
-					if err != nil {
-						log.Fatal(err)
-					}
-					defer func() {
-						err := fp.Close()
-						if err != nil {
-							log.Fatal(err)
-						}
-					}()
-					_, err = fp.Write(fileData)
-					if err != nil {
-						log.Fatal(err)
-						return
-					}
-				}()
-
-				log.Infof("%s ✅", filepath.Base(file.Name))
-
-				if unpack {
-					Unpack(offlineFilePath, dir, destDir, title, indent, muteSpinner, silent)
-					err := os.Remove(offlineFilePath)
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-
-			}(file, bundlesBuffer, dir, destDir, i)
-		}
-
-		wg.Wait()
-
-		if !isChannelClosed(bundleUpdates) {
 			bundleUpdates <- true
+		case doduda.StageBinDone:
+			closeSpinner()
+			if bundleUpdates == nil {
+				return
+			}
+			// the process ends with the error, the progress bar is not waited for
+			if ctx.Err() == nil {
+				if !isChannelClosed(bundleUpdates) {
+					bundleUpdates <- true
+				}
+				feedbackWg.Wait()
+			}
+			bundleUpdates = nil
 		}
-
-		feedbackWg.Wait()
-
 	}
 
-	return nil
+	return client.DownloadFiles(ctx, manifest, toDownload, opts)
 }
@@ -1,184 +1,353 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/dofusdude/doduda/pkg/doduda"
 )
 
+// VersionFile persists the last seen versions of the watchdog. The windows
+// versions of main, dofus3 and beta are kept in their own fields, every other
+// release and platform in Platforms.
 type VersionFile struct {
-	Main   string `json:"main"`
-	Dofus3 string `json:"dofus3"`
-	Beta   string `json:"beta"`
-}
-
-func VersionChanged(dir string, gameVersion string, versionFilePath string, customBodyPath string, volatile bool, initialHook *bool) (bool, string, string, error) { // changed?, old version, new version, error
-	var versionFile VersionFile
-
-	if !volatile {
-		if _, err := os.Stat(versionFilePath); os.IsNotExist(err) {
-			err = os.MkdirAll(filepath.Dir(versionFilePath), 0755)
-			if err != nil {
-				return false, "", "", err
-			}
-			versionFile = VersionFile{
-				Main: "",
-				Beta: "",
-			}
-		} else {
-			versions, err := os.ReadFile(versionFilePath)
-			if err != nil {
-				return false, "", "", err
-			}
-
-			err = json.Unmarshal(versions, &versionFile)
-			if err != nil {
-				return false, "", "", err
-			}
-		}
-	} else {
-		versionFile.Beta = "-"
-		versionFile.Main = "-"
-	}
+	Main      string                       `json:"main"`
+	Dofus3    string                       `json:"dofus3"`
+	Beta      string                       `json:"beta"`
+	Platforms map[string]map[string]string `json:"platforms,omitempty"` // platform -> release -> version
+}
 
-	serverVersion := GetLatestLauncherVersion(gameVersion)
-	serverVersion = serverVersion[4:] // removing updater version
+// LoadVersionFile reads the version file, a missing file has no versions.
+func LoadVersionFile(path string) (*VersionFile, error) {
+	versionFile := &VersionFile{}
 
-	var versionChanged bool
-	switch gameVersion {
-	case "main":
-		versionChanged = versionFile.Main != serverVersion
-	case "dofus3":
-		versionChanged = versionFile.Dofus3 != serverVersion
-	case "beta":
-		versionChanged = versionFile.Beta != serverVersion
+	versions, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return versionFile, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if *initialHook {
-		*initialHook = false
-		return true, "-", serverVersion, nil
+	if err := json.Unmarshal(versions, versionFile); err != nil {
+		return nil, err
 	}
+	return versionFile, nil
+}
 
-	if versionChanged {
-		var oldVersion string
+// Version returns the last seen version of a release on a platform.
+func (v *VersionFile) Version(release string, platform string) string {
+	if platform == "windows" {
+		switch release {
+		case "main":
+			return v.Main
+		case "dofus3":
+			return v.Dofus3
+		case "beta":
+			return v.Beta
+		}
+	}
+	return v.Platforms[platform][release]
+}
 
-		switch gameVersion {
+func (v *VersionFile) SetVersion(release string, platform string, version string) {
+	if platform == "windows" {
+		switch release {
 		case "main":
-			oldVersion = versionFile.Main
-			versionFile.Main = serverVersion
+			v.Main = version
+			return
 		case "dofus3":
-			oldVersion = versionFile.Dofus3
-			versionFile.Dofus3 = serverVersion
+			v.Dofus3 = version
+			return
 		case "beta":
-			oldVersion = versionFile.Beta
-			versionFile.Beta = serverVersion
+			v.Beta = version
+			return
 		}
+	}
+
+	if v.Platforms == nil {
+		v.Platforms = make(map[string]map[string]string)
+	}
+	if v.Platforms[platform] == nil {
+		v.Platforms[platform] = make(map[string]string)
+	}
+	v.Platforms[platform][release] = version
+}
 
-		if !volatile {
-			versionsCurrent, err := json.Marshal(versionFile)
-			if err != nil {
-				return false, oldVersion, serverVersion, err
-			}
+// Save writes the version file atomically.
+func (v *VersionFile) Save(path string) error {
+	versions, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, versions)
+}
+
+// Pipeline states of a VersionEvent.
+const (
+	PipelineStarted   = "started"
+	PipelineSucceeded = "succeeded"
+	PipelineFailed    = "failed"
+)
 
-			err = os.WriteFile(versionFilePath, versionsCurrent, 0644)
-			if err != nil {
-				return false, oldVersion, serverVersion, err
-			}
+// VersionEvent is a new version of a release on a platform or, if Pipeline
+// is set, the status of the pipeline that runs for it.
+type VersionEvent struct {
+	Release    string `json:"release"`
+	Platform   string `json:"platform"`
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+	Pipeline   string `json:"pipeline,omitempty"` // started, succeeded or failed
+	Stage      string `json:"stage,omitempty"`    // stage that failed
+	Error      string `json:"error,omitempty"`
+}
+
+// Message is the human readable text of the event.
+func (e VersionEvent) Message() string {
+	var on string
+	if e.Platform != "windows" {
+		on = " on " + e.Platform
+	}
+
+	switch e.Pipeline {
+	case PipelineStarted:
+		return fmt.Sprintf("⚙️ Pipeline for Dofus %s version %s%s started.", e.Release, e.NewVersion, on)
+	case PipelineSucceeded:
+		return fmt.Sprintf("✅ Pipeline for Dofus %s version %s%s succeeded.", e.Release, e.NewVersion, on)
+	case PipelineFailed:
+		return fmt.Sprintf("❌ Pipeline for Dofus %s version %s%s failed in %s: %s", e.Release, e.NewVersion, on, e.Stage, e.Error)
+	default:
+		return fmt.Sprintf("🎉 Dofus %s version %s available%s!", e.Release, e.NewVersion, on)
+	}
+}
+
+// watchTarget is a release on a platform the watchdog checks.
+type watchTarget struct {
+	release  string
+	platform string
+}
+
+// Watchdog checks the versions of several releases and platforms with one
+// request to cytrus.json per tick and notifies every notifier once per
+// changed release. Notifications go through the outbox, so they are retried
+// until they are delivered. With a Pipeline every change is downloaded and
+// processed in-process and its status is notified as well. The pipelines run
+// one after the other in the background, so the checks keep their interval.
+type Watchdog struct {
+	Targets         []watchTarget
+	VersionFilePath string
+	Volatile        bool // do not persist versions, every tick is a change
+	InitialHook     bool // notify all targets on the first tick
+	Notifiers       []Notifier
+	Outbox          *Outbox
+	DeadlyHook      bool // stop after the first successful notification
+	Pipeline        *PipelineConfig
+	PipelineDir     string                 // relative paths of the pipeline are relative to it
+	PipelineRules   *doduda.SelectionRules // see PipelineConfig.PipelineSetup
+	PipelineClient  *doduda.Client
+	Monitor         *Monitor // nil does not record the status
+
+	queue         []VersionEvent // changes waiting for the pipeline
+	running       *VersionEvent  // change the pipeline runs for, nil if idle
+	pipelineStart time.Time
+	pipelineDone  chan error
+}
+
+// CheckVersions returns the targets whose version changed since the last
+// check, queues their notifications and then persists the new versions. A
+// target seen for the first time is only saved, unless InitialHook is set.
+func (w *Watchdog) CheckVersions(ctx context.Context) ([]VersionEvent, error) {
+	versionFile := &VersionFile{}
+	if !w.Volatile {
+		var err error
+		versionFile, err = LoadVersionFile(w.VersionFilePath)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	requestStart := time.Now()
+	serverVersions, err := cdn.GameVersions(ctx)
+	w.Monitor.CytrusRequest(time.Since(requestStart))
+	if err != nil {
+		return nil, err
+	}
 
-		if oldVersion == "" { // first time, just save the file
-			return false, oldVersion, serverVersion, nil
+	var events []VersionEvent
+	changed := false
+	for _, target := range w.Targets {
+		serverVersion, err := serverVersions.Version(target.release, target.platform)
+		if err != nil {
+			log.Error(err)
+			continue
 		}
+		w.Monitor.Seen(target.release, target.platform, serverVersion)
 
-		return true, oldVersion, serverVersion, nil
+		oldVersion := versionFile.Version(target.release, target.platform)
+		if w.Volatile {
+			oldVersion = "-"
+		}
+
+		if w.InitialHook {
+			events = append(events, VersionEvent{Release: target.release, Platform: target.platform, OldVersion: "-", NewVersion: serverVersion})
+		} else if oldVersion != serverVersion && oldVersion != "" {
+			events = append(events, VersionEvent{Release: target.release, Platform: target.platform, OldVersion: oldVersion, NewVersion: serverVersion})
+		}
+
+		if oldVersion != serverVersion {
+			versionFile.SetVersion(target.release, target.platform, serverVersion)
+			changed = true
+		}
 	}
+	w.InitialHook = false
 
-	return false, serverVersion, serverVersion, nil
-}
+	if err := w.Outbox.Add(events, w.Notifiers); err != nil {
+		return nil, err
+	}
+
+	if changed && !w.Volatile {
+		if err := versionFile.Save(w.VersionFilePath); err != nil {
+			return events, err
+		}
+	}
 
-func watchdogTick(endTimer chan bool, ticker *time.Ticker, dir string, gameRelease string, versionFilePath string, customBodyPath string, volatile bool, initialHook *bool, hook string, authHeader string, deadlyHook bool) {
-	changed, oldVersion, newVersion, err := VersionChanged(dir, gameRelease, versionFilePath, customBodyPath, volatile, initialHook)
+	return events, nil
+}
 
+// Tick checks the versions, delivers the notifications of the changes and
+// queues the pipeline for each of them. It reports whether the watchdog
+// should stop.
+func (w *Watchdog) Tick(ctx context.Context) bool {
+	events, err := w.CheckVersions(ctx)
 	if err != nil {
 		log.Error(err)
-	} else {
-		if changed {
-			message := fmt.Sprintf("🎉 Dofus %s version %s available!", gameRelease, newVersion)
-			log.Info(message)
-
-			var isJson bool
-			var body []byte
-			if customBodyPath == "" {
-				isJson = true
-				jsonBody := map[string]string{
-					"message":     message,
-					"old_version": oldVersion,
-					"new_version": newVersion,
-					"release":     gameRelease,
-				}
-
-				body, err = json.Marshal(jsonBody)
-				if err != nil {
-					log.Error(err)
-				}
-			} else {
-				if filepath.Ext(customBodyPath) == ".json" {
-					isJson = true
-				}
-
-				body, err = os.ReadFile(customBodyPath)
-				if err != nil {
-					log.Error(err)
-				}
-
-				body = []byte(os.Expand(string(body), func(key string) string {
-					switch key {
-					case "oldVersion":
-						return oldVersion
-					case "newVersion":
-						return newVersion
-					case "release":
-						return gameRelease
-					default:
-						return ""
-					}
-				}))
-			}
-
-			req, err := http.NewRequest("POST", hook, bytes.NewBuffer(body))
-			if err != nil {
-				log.Error(err)
-			}
-
-			if isJson {
-				req.Header.Set("Content-Type", "application/json")
-			} else {
-				req.Header.Set("Content-Type", "text/plain")
-			}
-
-			if authHeader != "" {
-				req.Header.Set("Authorization", authHeader)
-			}
-
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				log.Error(err)
-			} else {
-				err = resp.Body.Close()
-				if err != nil {
-					log.Error(err)
-				} else {
-					if deadlyHook {
-						endTimer <- true
-					}
-				}
-			}
+	}
+	w.Monitor.Checked(err)
+
+	for _, event := range events {
+		log.Info(event.Message())
+		w.Monitor.Changed(event)
+	}
+
+	stop := w.Deliver(ctx)
+	if w.Pipeline == nil {
+		return stop
+	}
+
+	for _, event := range events {
+		w.queuePipeline(event)
+	}
+	return w.startPipeline(ctx) || stop
+}
+
+// queuePipeline queues the pipeline for event. A queued change of the same
+// release and platform is replaced, only the newest version is processed.
+func (w *Watchdog) queuePipeline(event VersionEvent) {
+	for i, queued := range w.queue {
+		if queued.Release == event.Release && queued.Platform == event.Platform {
+			event.OldVersion = queued.OldVersion
+			w.queue[i] = event
+			return
+		}
+	}
+	w.queue = append(w.queue, event)
+}
+
+// startPipeline starts the pipeline for the next queued change in the
+// background, unless one is running. It is restricted to the release and
+// platform of the change. When several targets are watched each gets
+// <output>/<release>/<platform> like a matrix download.
+func (w *Watchdog) startPipeline(ctx context.Context) bool {
+	if w.running != nil || len(w.queue) == 0 || ctx.Err() != nil {
+		return false
+	}
+	event := w.queue[0]
+	w.queue = w.queue[1:]
+
+	config := *w.Pipeline
+	config.Releases = []string{event.Release}
+	config.Platforms = []string{event.Platform}
+	config.Version = event.NewVersion
+	if len(w.Targets) > 1 {
+		config.Output = filepath.Join(config.Output, event.Release, event.Platform)
+	}
+
+	stop := w.notify(ctx, event, PipelineStarted, nil)
+	w.Monitor.PipelineStarted(event)
+
+	w.running = &event
+	w.pipelineStart = time.Now()
+	done := make(chan error, 1)
+	w.pipelineDone = done
+	go func() {
+		done <- RunPipeline(ctx, &config, w.PipelineDir, w.PipelineRules, w.PipelineClient, true)
+	}()
+	return stop
+}
+
+// PipelineDone receives the result of the running pipeline, it is nil if no
+// pipeline runs. Pass the result to FinishPipeline.
+func (w *Watchdog) PipelineDone() <-chan error {
+	return w.pipelineDone
+}
+
+// FinishPipeline notifies the result of the running pipeline and starts the
+// next queued one. It reports whether the watchdog should stop.
+func (w *Watchdog) FinishPipeline(ctx context.Context, err error) bool {
+	event := *w.running
+	w.running = nil
+	w.pipelineDone = nil
+	w.Monitor.PipelineFinished(time.Since(w.pipelineStart), err)
+
+	stop := w.notify(ctx, event, PipelineSucceeded, err)
+	return w.startPipeline(ctx) || stop
+}
+
+// Wait finishes the running pipeline and, unless ctx is done, the queued
+// ones.
+func (w *Watchdog) Wait(ctx context.Context) {
+	for w.pipelineDone != nil {
+		w.FinishPipeline(ctx, <-w.pipelineDone)
+	}
+}
+
+// notify queues and delivers the pipeline status of event, a non nil err
+// makes it a failure.
+func (w *Watchdog) notify(ctx context.Context, event VersionEvent, status string, err error) bool {
+	event.Pipeline = status
+	if err != nil {
+		event.Pipeline = PipelineFailed
+		event.Stage = "pipeline"
+		var pipelineErr *PipelineError
+		if errors.As(err, &pipelineErr) {
+			event.Stage = pipelineErr.Stage
+			err = pipelineErr.Err
 		}
+		event.Error = err.Error()
+		log.Error(event.Message())
+	} else {
+		log.Info(event.Message())
+	}
+
+	if err := w.Outbox.Add([]VersionEvent{event}, w.Notifiers); err != nil {
+		log.Error(err)
+	}
+	return w.Deliver(ctx)
+}
+
+// Deliver sends the due notifications of the outbox. It reports whether the
+// watchdog should stop.
+func (w *Watchdog) Deliver(ctx context.Context) bool {
+	delivered, err := w.Outbox.Deliver(ctx, w.Notifiers)
+	if err != nil {
+		log.Error(err)
 	}
+	w.Monitor.Pending(len(w.Outbox.Pending))
+	return delivered && w.DeadlyHook
 }